@@ -44,6 +44,7 @@ func Initialize(args []string) []string {
 	Flags["part"] = fs.String("part", "", "")
 	Flags["file"] = fs.String("file", "", "")
 	Flags["threads"] = fs.String("threads", "", "")
+	Flags["resume"] = fs.String("resume", "", "")
 	Flags["virtual_file"] = fs.String("virtual_file", "", "")
 	Flags["remote_path"] = fs.String("remote_path", "", "")
 	Flags["index"] = fs.String("index", "", "")
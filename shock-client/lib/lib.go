@@ -88,6 +88,67 @@ func (n *Node) UploadPart(part string, r io.Reader, size int64) (err error) {
 	return nil
 }
 
+// ReplacePart uploads a part through the parts resource. The server verifies
+// the part against md5 and overwrites any previously received copy.
+func (n *Node) ReplacePart(part string, r io.Reader, size int64, md5 string) (err error) {
+	form := client.NewForm()
+	form.AddFileReader(part, r, size)
+	if md5 != "" {
+		form.AddParam("md5", md5)
+	}
+	if err = form.Create(); err != nil {
+		return err
+	}
+
+	headers := client.Header{
+		"Content-Type":   form.ContentType,
+		"Content-Length": strconv.FormatInt(form.Length, 10),
+	}
+
+	if res, err := client.Do("PUT", conf.Server.Url+"/node/"+n.Id+"/parts/"+part, headers, form.Reader); err == nil {
+		if res.StatusCode == 200 {
+			return nil
+		} else {
+			r := Wrapper{}
+			body, _ := ioutil.ReadAll(res.Body)
+			if err = json.Unmarshal(body, &r); err == nil {
+				return errors.New(res.Status + ": " + (*r.Error)[0])
+			} else {
+				return errors.New("request error: " + res.Status)
+			}
+		}
+	} else {
+		return err
+	}
+}
+
+// Parts returns the partial upload state of the node
+func (n *Node) Parts() (s *PartsStatus, err error) {
+	if n.Id == "" {
+		return nil, errors.New("missing node Id")
+	}
+	res, err := client.Get(conf.Server.Url+"/node/"+n.Id+"/parts/", client.Header{}, nil)
+	if err == nil {
+		if res.StatusCode == 200 {
+			r := WParts{Data: &PartsStatus{}}
+			body, _ := ioutil.ReadAll(res.Body)
+			if err = json.Unmarshal(body, &r); err == nil {
+				return r.Data, nil
+			}
+			return nil, err
+		} else {
+			r := Wrapper{}
+			body, _ := ioutil.ReadAll(res.Body)
+			if err = json.Unmarshal(body, &r); err == nil {
+				return nil, errors.New(res.Status + ": " + (*r.Error)[0])
+			} else {
+				return nil, errors.New("request error: " + res.Status)
+			}
+		}
+	}
+	return nil, err
+}
+
 func (n *Node) createOrUpdate(opts Opts) (err error) {
 	url := conf.Server.Url + "/node"
 	method := "POST"
@@ -7,6 +7,8 @@ import (
 
 type Node node.Node
 
+type PartsStatus node.PartsStatus
+
 type User struct {
 	Username string
 	Password string
@@ -25,6 +27,11 @@ type WNode struct {
 	Error *[]string `json:"error"`
 }
 
+type WParts struct {
+	Data  *PartsStatus `json:"data"`
+	Error *[]string    `json:"error"`
+}
+
 type WAcl struct {
 	Data  *acl.Acl  `json:"data"`
 	Error *[]string `json:"error"`
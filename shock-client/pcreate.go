@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/md5"
 	"fmt"
 	"github.com/MG-RAST/Shock/shock-client/conf"
 	"github.com/MG-RAST/Shock/shock-client/lib"
@@ -15,7 +16,46 @@ func uploader(args ...interface{}) interface{} {
 	part := args[1].(int)
 	fh := args[2].(*os.File)
 	size := args[3].(int64)
-	return n.UploadPart(strconv.Itoa(part), io.NewSectionReader(fh, int64(part-1)*conf.CHUNK_SIZE, size), size)
+	sum, err := partMd5(fh, part, size)
+	if err != nil {
+		return err
+	}
+	return n.ReplacePart(strconv.Itoa(part), io.NewSectionReader(fh, int64(part-1)*conf.CHUNK_SIZE, size), size, sum)
+}
+
+func partMd5(fh *os.File, part int, size int64) (sum string, err error) {
+	h := md5.New()
+	if _, err = io.Copy(h, io.NewSectionReader(fh, int64(part-1)*conf.CHUNK_SIZE, size)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func partSize(part int, filesize int64) int64 {
+	size := int64(conf.CHUNK_SIZE)
+	if size*int64(part) > filesize {
+		size = filesize - size*int64(part-1)
+	}
+	return size
+}
+
+// receivedParts returns the parts of an interrupted upload that the server
+// already holds intact, so they are not uploaded again.
+func receivedParts(n lib.Node, fh *os.File, chunks int, filesize int64) (done map[int]bool, err error) {
+	s, err := n.Parts()
+	if err != nil {
+		return nil, err
+	}
+	if s.Count != chunks {
+		return nil, fmt.Errorf("node %s expects %d parts, file has %d", n.Id, s.Count, chunks)
+	}
+	done = map[int]bool{}
+	for _, p := range s.Received {
+		if sum, err := partMd5(fh, p.Part, partSize(p.Part, filesize)); err == nil && sum == p.MD5 {
+			done[p.Part] = true
+		}
+	}
+	return
 }
 
 func pcreate(args []string) (err error) {
@@ -56,22 +96,30 @@ func pcreate(args []string) (err error) {
 			threads = 1
 		}
 
-		//create node
-		opts := lib.Opts{}
-		opts["upload_type"] = "parts"
-		opts["parts"] = strconv.Itoa(chunks)
-		if err := n.Create(opts); err != nil {
-			handleString(fmt.Sprintf("Error creating node: %s\n", err.Error()))
+		done := map[int]bool{}
+		if ne(conf.Flags["resume"]) {
+			n.Id = (*conf.Flags["resume"])
+			if done, err = receivedParts(n, fh, chunks, filesize); err != nil {
+				handleString(fmt.Sprintf("Error resuming upload: %s\n", err.Error()))
+			}
+			fmt.Printf("resuming upload: %d of %d parts already received\n", len(done), chunks)
+		} else {
+			//create node
+			opts := lib.Opts{}
+			opts["upload_type"] = "parts"
+			opts["parts"] = strconv.Itoa(chunks)
+			if err := n.Create(opts); err != nil {
+				handleString(fmt.Sprintf("Error creating node: %s\n", err.Error()))
+			}
 		}
 
 		workers := pool.New(threads)
 		workers.Run()
-		for i := 0; i < chunks; i++ {
-			size := int64(conf.CHUNK_SIZE)
-			if size*(int64(i)+1) > filesize {
-				size = filesize - size*(int64(i))
+		for i := 1; i <= chunks; i++ {
+			if done[i] {
+				continue
 			}
-			workers.Add(uploader, n, (i + 1), fh, size)
+			workers.Add(uploader, n, i, fh, partSize(i, filesize))
 		}
 		workers.Wait()
 		maxRetries := 10
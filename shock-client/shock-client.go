@@ -31,8 +31,10 @@ create [options...]
 pcreate [options...]
     -full=<u>                   Path to file
     -threads=<i>                number of threads to use for uploading (default 4)
+    -resume=<id>                Resume an interrupted upload to node <id>
 
-    Note: parallel uploading for the whole file.
+    Note: parallel uploading for the whole file. When resuming, parts already
+          received by the server with a matching md5 are skipped.
 
 update [options...] <id>
     -part=<p> -file=<f>         The part number to be uploaded and path to file
@@ -3,21 +3,77 @@ package auth
 
 import (
 	"errors"
+	"fmt"
 	"github.com/MG-RAST/Shock/shock-server/auth/basic"
+	"github.com/MG-RAST/Shock/shock-server/auth/cache"
 	"github.com/MG-RAST/Shock/shock-server/auth/globus"
 	"github.com/MG-RAST/Shock/shock-server/auth/mgrast"
+	"github.com/MG-RAST/Shock/shock-server/auth/token"
 	"github.com/MG-RAST/Shock/shock-server/conf"
 	e "github.com/MG-RAST/Shock/shock-server/errors"
 	"github.com/MG-RAST/Shock/shock-server/user"
+	"strconv"
+	"time"
 )
 
-// authCache is a
-var authCache cache
-var authMethods []func(string) (*user.User, error)
+// defaults of the [Auth] cache options
+const (
+	defaultCacheSize        = 10000
+	defaultCacheTTL         = 60 // minutes
+	defaultFailureCacheSize = 10000
+	defaultFailureTTL       = 30 // seconds
+	defaultMaxFailures      = 10
+	defaultLockout          = 15 // minutes
+)
+
+var (
+	// users caches authenticated users by authorization header
+	users *cache.Cache
+	// rejected caches authorization headers that failed, so they are
+	// not sent to the auth provider again right away
+	rejected *cache.Cache
+	// failures counts the failed logins per client and username, see identity
+	failures    *cache.Cache
+	maxFailures int
+	authMethods []func(string) (*user.User, error)
+)
+
+// failure counts the failed logins of username from one client address
+type failure struct {
+	username string
+	count    int
+}
 
-func Initialize() {
-	authCache = cache{m: make(map[string]cacheValue)}
-	authMethods = []func(string) (*user.User, error){}
+// Initialize reads the cache options and sets up the auth methods of the
+// configured providers
+func Initialize() (err error) {
+	var size, ttl, failureSize, failureTTL, lockout int
+	for _, o := range []struct {
+		key string
+		val *int
+		def int
+	}{
+		{"auth_cache_size", &size, defaultCacheSize},
+		{"auth_cache_ttl", &ttl, defaultCacheTTL},
+		{"auth_failure_cache_size", &failureSize, defaultFailureCacheSize},
+		{"auth_failure_ttl", &failureTTL, defaultFailureTTL},
+		{"auth_max_failures", &maxFailures, defaultMaxFailures},
+		{"auth_lockout", &lockout, defaultLockout},
+	} {
+		*o.val = o.def
+		if v := conf.Conf[o.key]; v != "" {
+			if *o.val, err = strconv.Atoi(v); err != nil || *o.val < 0 {
+				return fmt.Errorf("[Auth] %s: invalid number: %s", o.key[len("auth_"):], v)
+			}
+		}
+	}
+	users = cache.New(size, time.Duration(ttl)*time.Minute)
+	// sized apart from users so disabling the credentials cache keeps the lockout
+	rejected = cache.New(failureSize, time.Duration(failureTTL)*time.Second)
+	failures = cache.New(failureSize, time.Duration(lockout)*time.Minute)
+
+	// api tokens are issued by Shock and always accepted
+	authMethods = []func(string) (*user.User, error){token.Auth}
 	if conf.Conf["basic_auth"] != "" {
 		authMethods = append(authMethods, basic.Auth)
 	}
@@ -27,18 +83,62 @@ func Initialize() {
 	if conf.Conf["mgrast_oauth_url"] != "" {
 		authMethods = append(authMethods, mgrast.Auth)
 	}
+	return nil
 }
 
-func Authenticate(header string) (u *user.User, err error) {
-	if u = authCache.lookup(header); u != nil {
-		return u, nil
-	} else {
-		for _, auth := range authMethods {
-			if u, _ := auth(header); u != nil {
-				authCache.add(header, u)
-				return u, nil
+// Authenticate returns the user of the authorization header of a request
+// from the client address addr
+func Authenticate(header string, addr string) (u *user.User, err error) {
+	if v, ok := users.Get(header); ok {
+		return v.(*user.User), nil
+	}
+	if _, ok := rejected.Get(header); ok {
+		return nil, errors.New(e.InvalidAuth)
+	}
+	id, username := identity(header, addr)
+	if v, ok := failures.Get(id); ok && maxFailures > 0 && v.(failure).count >= maxFailures {
+		return nil, errors.New(e.TooManyFailures)
+	}
+	for _, auth := range authMethods {
+		if u, _ := auth(header); u != nil {
+			// tokens are checked on every request so revocation is immediate
+			if u.Scope == nil {
+				users.Add(header, u)
 			}
+			failures.Remove(id)
+			return u, nil
 		}
 	}
+	rejected.Add(header, true)
+	failures.Update(id, func(v interface{}) interface{} {
+		f, _ := v.(failure)
+		return failure{username: username, count: f.count + 1}
+	})
 	return nil, errors.New(e.InvalidAuth)
 }
+
+// identity returns the key failed logins are counted under: the client
+// address and username for basic auth, so that guessing passwords is
+// limited without letting others lock the user out, else the header.
+func identity(header string, addr string) (id string, username string) {
+	if username, _, err := basic.DecodeHeader(header); err == nil {
+		return "basic:" + addr + " " + username, username
+	}
+	return header, ""
+}
+
+// FlushUser drops the cached credentials of the user with uuid so that
+// changes to the user apply to its next request. It returns the number of
+// cached credentials dropped.
+func FlushUser(uuid string) int {
+	return users.RemoveFunc(func(v interface{}) bool {
+		return v.(*user.User).Uuid == uuid
+	})
+}
+
+// Unlock forgets the failed logins of username from all clients
+func Unlock(username string) {
+	failures.RemoveFunc(func(v interface{}) bool {
+		return v.(failure).username == username
+	})
+}
@@ -13,7 +13,7 @@ import (
 // DecodeHeader takes the request authorization header and returns
 // username and password if it is correctly encoded.
 func DecodeHeader(header string) (username string, password string, err error) {
-	if strings.ToLower(strings.Split(header, " ")[0]) == "basic" {
+	if fields := strings.Split(header, " "); len(fields) > 1 && strings.ToLower(fields[0]) == "basic" {
 		if val, err := base64.URLEncoding.DecodeString(strings.Split(header, " ")[1]); err == nil {
 			tmp := strings.Split(string(val), ":")
 			if len(tmp) >= 2 {
@@ -0,0 +1,141 @@
+// Package cache implements a size bounded, expiring LRU cache that is
+// safe for concurrent use
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache holds at most size entries, each for ttl after it was added. When
+// full, adding an entry evicts the least recently used one.
+type Cache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// New returns an empty cache of size entries with time to live ttl
+func New(size int, ttl time.Duration) *Cache {
+	return &Cache{size: size, ttl: ttl, ll: list.New(), items: make(map[string]*list.Element), now: time.Now}
+}
+
+// SetClock replaces the clock of the cache, for testing
+func (c *Cache) SetClock(now func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Get returns the unexpired value of key
+func (c *Cache) Get(key string) (value interface{}, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	en := el.Value.(*entry)
+	if !c.now().Before(en.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return en.value, true
+}
+
+// Add sets the value of key, renewing its time to live
+func (c *Cache) Add(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(key, value)
+}
+
+// Update atomically sets the value of key to f of its current value, nil
+// if there is none, renewing its time to live
+func (c *Cache) Update(key string, f func(value interface{}) interface{}) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var old interface{}
+	if el, ok := c.items[key]; ok {
+		if en := el.Value.(*entry); c.now().Before(en.expires) {
+			old = en.value
+		}
+	}
+	value := f(old)
+	c.add(key, value)
+	return value
+}
+
+// Remove deletes key
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// RemoveFunc deletes the entries whose value f returns true for and
+// returns how many were deleted
+func (c *Cache) RemoveFunc(f func(value interface{}) bool) (n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if f(el.Value.(*entry).value) {
+			c.remove(el)
+			n++
+		}
+		el = next
+	}
+	return
+}
+
+// Purge deletes all entries
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// Len returns the number of entries, including expired ones not yet evicted
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *Cache) add(key string, value interface{}) {
+	if c.size <= 0 {
+		return
+	}
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		en := el.Value.(*entry)
+		en.value = value
+		en.expires = expires
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, expires: expires})
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
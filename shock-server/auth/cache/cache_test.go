@@ -0,0 +1,94 @@
+package cache_test
+
+import (
+	"fmt"
+	. "github.com/MG-RAST/Shock/shock-server/auth/cache"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEviction(t *testing.T) {
+	c := New(2, time.Hour)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Get("a")
+	c.Add("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("least recently used entry b was not evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.Get(k); !ok {
+			t.Errorf("entry %s was evicted", k)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	c := New(10, time.Minute)
+	c.SetClock(func() time.Time { return now })
+	c.Add("a", 1)
+	now = now.Add(59 * time.Second)
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("entry expired early")
+	}
+	now = now.Add(time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expired entry returned")
+	}
+	if c.Len() != 0 {
+		t.Errorf("expired entry not removed on lookup")
+	}
+}
+
+func TestRemoveFunc(t *testing.T) {
+	c := New(10, time.Hour)
+	for i := 0; i < 6; i++ {
+		c.Add(fmt.Sprint(i), i%2)
+	}
+	if n := c.RemoveFunc(func(v interface{}) bool { return v.(int) == 1 }); n != 3 {
+		t.Errorf("RemoveFunc removed %d entries, want 3", n)
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("entry 1 not removed")
+	}
+	if _, ok := c.Get("2"); !ok {
+		t.Errorf("entry 2 removed")
+	}
+}
+
+func TestZeroSize(t *testing.T) {
+	c := New(0, time.Hour)
+	c.Add("a", 1)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("cache of size 0 stored an entry")
+	}
+}
+
+func TestConcurrentUpdate(t *testing.T) {
+	c := New(1000, time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Update("n", func(v interface{}) interface{} {
+					if n, ok := v.(int); ok {
+						return n + 1
+					}
+					return 1
+				})
+				c.Add(fmt.Sprint(j), j)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := c.Get("n"); v != 5000 {
+		t.Errorf("n = %v, want 5000", v)
+	}
+}
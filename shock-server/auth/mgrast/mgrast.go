@@ -74,7 +74,16 @@ func authToken(t string) (*user.User, error) {
 			if err = json.Unmarshal(body, &r); err != nil {
 				return nil, err
 			}
-			return &user.User{Username: r.Uname, Fullname: r.Fname + " " + r.Lname, Email: r.Email, CustomFields: map[string][]string{"groups": r.Groups}}, nil
+			// provider groups are namespaced so local groups can not take their names
+			groups := []string{}
+			for _, g := range r.Groups {
+				groups = append(groups, "mgrast:"+g)
+			}
+			u := &user.User{Username: r.Uname, Fullname: r.Fname + " " + r.Lname, Email: r.Email, Groups: groups}
+			if err = u.SetUuid(); err != nil {
+				return nil, err
+			}
+			return u, nil
 		} else {
 			r := resErr{}
 			body, _ := ioutil.ReadAll(res.Body)
@@ -0,0 +1,150 @@
+// Package token implements api tokens issued by Shock: scoped, expiring
+// and revocable credentials for unattended clients
+package token
+
+import (
+	"code.google.com/p/go-uuid/uuid"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/db"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+// token scopes
+const (
+	Read  = "read"
+	Write = "write"
+)
+
+// secrets start with this prefix so they are recognizable, e.g. in logs
+const prefix = "shock_"
+
+// Token is an api token of a user. Only the hash of the secret is stored,
+// the secret itself is shown once when the token is created.
+type Token struct {
+	Id          string    `bson:"id" json:"id"`
+	Hash        string    `bson:"hash" json:"-"`
+	Owner       string    `bson:"owner" json:"owner"`
+	Name        string    `bson:"name" json:"name"`
+	Scope       string    `bson:"scope" json:"scope"`
+	Nodes       []string  `bson:"nodes" json:"nodes"`
+	Collections []string  `bson:"collections" json:"collections"`
+	Created     time.Time `bson:"created" json:"created_on"`
+	Expires     time.Time `bson:"expires" json:"expires"`
+	LastUsed    time.Time `bson:"last_used" json:"last_used"`
+	Secret      string    `bson:"-" json:"token,omitempty"`
+}
+
+func collection(session *mgo.Session) *mgo.Collection {
+	return session.DB(conf.Conf["mongodb-database"]).C("Tokens")
+}
+
+// Initialize ensures the indexes of the Tokens collection. Expired tokens
+// are removed by mongodb.
+func Initialize() {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := collection(session)
+	c.EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
+	c.EnsureIndex(mgo.Index{Key: []string{"hash"}, Unique: true})
+	c.EnsureIndex(mgo.Index{Key: []string{"owner"}})
+	c.EnsureIndex(mgo.Index{Key: []string{"expires"}, ExpireAfter: time.Second})
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// New issues a token for the user with uuid owner. Scope is read or
+// write, nodes and collections limit the token to those resources if set.
+func New(owner string, name string, scope string, nodes []string, collections []string, expires time.Time) (t *Token, err error) {
+	if scope != Read && scope != Write {
+		return nil, errors.New("token scope must be read or write")
+	}
+	if !expires.After(time.Now()) {
+		return nil, errors.New("token expiration must be in the future")
+	}
+	b := make([]byte, 20)
+	if _, err = rand.Read(b); err != nil {
+		return nil, err
+	}
+	secret := prefix + hex.EncodeToString(b)
+	if nodes == nil {
+		nodes = []string{}
+	}
+	if collections == nil {
+		collections = []string{}
+	}
+	t = &Token{Id: uuid.New(), Hash: hash(secret), Owner: owner, Name: name, Scope: scope, Nodes: nodes, Collections: collections, Created: time.Now(), Expires: expires}
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	if err = collection(session).Insert(t); err != nil {
+		return nil, err
+	}
+	t.Secret = secret
+	return
+}
+
+// Load returns the token with id
+func Load(id string) (t *Token, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	t = &Token{}
+	if err = collection(session).Find(bson.M{"id": id}).One(t); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// List returns the unexpired tokens of the user with uuid owner
+func List(owner string) (ts []Token, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	ts = []Token{}
+	err = collection(session).Find(bson.M{"owner": owner, "expires": bson.M{"$gt": time.Now()}}).Sort("-created").All(&ts)
+	return
+}
+
+// Revoke deletes the token
+func (t *Token) Revoke() (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	return collection(session).Remove(bson.M{"id": t.Id})
+}
+
+// Auth takes the request authorization header, Token <secret>, and
+// returns the owner of the token restricted to its scope. Tokens never
+// carry admin rights.
+func Auth(header string) (u *user.User, err error) {
+	fields := strings.Fields(header)
+	if len(fields) != 2 || strings.ToLower(fields[0]) != "token" || !strings.HasPrefix(fields[1], prefix) {
+		return nil, errors.New(e.InvalidAuth)
+	}
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := collection(session)
+	t := &Token{}
+	if err = c.Find(bson.M{"hash": hash(fields[1])}).One(t); err != nil {
+		return nil, errors.New(e.InvalidAuth)
+	}
+	now := time.Now()
+	if !t.Expires.After(now) {
+		return nil, errors.New(e.InvalidAuth)
+	}
+	if u, err = user.FindByUuid(t.Owner); err != nil {
+		return nil, errors.New(e.InvalidAuth)
+	}
+	c.Update(bson.M{"id": t.Id}, bson.M{"$set": bson.M{"last_used": now}})
+	u.Admin = false
+	u.Scope = &user.Scope{Token: t.Id, Write: t.Scope == Write, Nodes: t.Nodes, Collections: t.Collections}
+	return u, nil
+}
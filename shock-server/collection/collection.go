@@ -0,0 +1,155 @@
+// Package collection implements named sets of nodes that share an acl
+package collection
+
+import (
+	"code.google.com/p/go-uuid/uuid"
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/db"
+	"github.com/MG-RAST/Shock/shock-server/node/acl"
+	"github.com/MG-RAST/Shock/shock-server/util"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// Collection groups nodes, e.g. the data of a study. Its acl is inherited
+// by the member nodes, membership is recorded on the nodes.
+type Collection struct {
+	Id           string    `bson:"id" json:"id"`
+	Name         string    `bson:"name" json:"name"`
+	Description  string    `bson:"description" json:"description"`
+	Acl          acl.Acl   `bson:"acl" json:"-"`
+	Created      time.Time `bson:"created" json:"created_on"`
+	LastModified time.Time `bson:"last_modified" json:"last_modified"`
+}
+
+var ErrNameRequired = errors.New("collection name required")
+
+func collection(session *mgo.Session) *mgo.Collection {
+	return session.DB(conf.Conf["mongodb-database"]).C("Collections")
+}
+
+// Initialize ensures the indexes of the Collections collection
+func Initialize() {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := collection(session)
+	c.EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
+	c.EnsureIndex(mgo.Index{Key: []string{"acl.read"}})
+	c.EnsureIndex(mgo.Index{Key: []string{"acl.owner"}})
+}
+
+// New creates a collection owned by the user with uuid owner
+func New(name string, description string, owner string) (c *Collection, err error) {
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+	now := time.Now()
+	c = &Collection{Id: uuid.New(), Name: name, Description: description, Created: now, LastModified: now}
+	c.Acl.SetOwner(owner)
+	c.Acl.Set(owner, acl.Rights{"read": true, "write": true, "delete": true})
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	if err = collection(session).Insert(c); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Load returns the collection with id
+func Load(id string) (c *Collection, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c = &Collection{}
+	if err = collection(session).Find(bson.M{"id": id}).One(c); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// LoadAll returns the collections with the given ids that exist
+func LoadAll(ids []string) (cs []Collection, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	cs = []Collection{}
+	err = collection(session).Find(bson.M{"id": bson.M{"$in": ids}}).All(&cs)
+	return
+}
+
+// readableBy is the query for the collections ids can read, a user uuid
+// followed by its group acl entries
+func readableBy(ids []string) bson.M {
+	return bson.M{"$or": []bson.M{{"acl.read": bson.M{"$in": ids}}, {"acl.owner": ids[0]}}}
+}
+
+// List returns one page of the collections readable by ids, all
+// collections if ids is empty.
+func List(ids []string, limit int, offset int) (cs []Collection, count int, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	q := bson.M{}
+	if len(ids) > 0 {
+		q = readableBy(ids)
+	}
+	query := collection(session).Find(q)
+	if count, err = query.Count(); err != nil {
+		return nil, 0, err
+	}
+	cs = []Collection{}
+	err = query.Sort("name").Limit(limit).Skip(offset).All(&cs)
+	return
+}
+
+// Readable returns the ids of the collections readable by ids
+func Readable(ids []string) (cids []string, err error) {
+	cids = []string{}
+	if len(ids) == 0 {
+		return
+	}
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	cs := []Collection{}
+	if err = collection(session).Find(readableBy(ids)).Select(bson.M{"id": 1}).All(&cs); err != nil {
+		return nil, err
+	}
+	for _, c := range cs {
+		cids = append(cids, c.Id)
+	}
+	return
+}
+
+// Rights returns the rights ids hold on the collection. Unlike node acls
+// an empty list grants nothing, a collection never makes its nodes public.
+// The owner holds all rights.
+func (c *Collection) Rights(ids ...string) acl.Rights {
+	r := acl.Rights{"read": false, "write": false, "delete": false}
+	for k, v := range map[string][]string{"read": c.Acl.Read, "write": c.Acl.Write, "delete": c.Acl.Delete} {
+		for _, id := range v {
+			if util.StringInSlice(id, ids) {
+				r[k] = true
+				break
+			}
+		}
+		if len(ids) > 0 && ids[0] != "" && ids[0] == c.Acl.Owner {
+			r[k] = true
+		}
+	}
+	return r
+}
+
+// Save stores changes to the name, description and acl
+func (c *Collection) Save() (err error) {
+	c.LastModified = time.Now()
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	return collection(session).Update(bson.M{"id": c.Id}, c)
+}
+
+// Delete removes the collection. Its nodes are left in place, the caller
+// removes their membership.
+func (c *Collection) Delete() (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	return collection(session).Remove(bson.M{"id": c.Id})
+}
@@ -0,0 +1,37 @@
+package collection_test
+
+import (
+	. "github.com/MG-RAST/Shock/shock-server/collection"
+	"github.com/MG-RAST/Shock/shock-server/node/acl"
+	"reflect"
+	"testing"
+)
+
+func TestRights(t *testing.T) {
+	none := acl.Rights{"read": false, "write": false, "delete": false}
+	all := acl.Rights{"read": true, "write": true, "delete": true}
+	g := acl.GroupId("g1")
+	tests := []struct {
+		name string
+		acl  acl.Acl
+		ids  []string
+		want acl.Rights
+	}{
+		{"owner", acl.Acl{Owner: "u1"}, []string{"u1"}, all},
+		{"owner with group ids", acl.Acl{Owner: "u1"}, []string{"u1", g}, all},
+		{"empty lists grant nothing", acl.Acl{Owner: "u1"}, []string{"u2"}, none},
+		{"anonymous", acl.Acl{}, []string{""}, none},
+		{"no ids", acl.Acl{Owner: "u1", Read: []string{"u1"}}, nil, none},
+		{"reader", acl.Acl{Owner: "u1", Read: []string{"u1", "u2"}}, []string{"u2"}, acl.Rights{"read": true, "write": false, "delete": false}},
+		{"group", acl.Acl{Owner: "u1", Read: []string{g}, Write: []string{g}}, []string{"u2", g}, acl.Rights{"read": true, "write": true, "delete": false}},
+		{"other group", acl.Acl{Owner: "u1", Read: []string{g}}, []string{"u2", acl.GroupId("g2")}, none},
+		{"group name is not a uuid", acl.Acl{Owner: "u1", Read: []string{g}}, []string{"g1"}, none},
+		{"group can not own", acl.Acl{Owner: g}, []string{"u2", g}, none},
+	}
+	for _, tt := range tests {
+		c := &Collection{Acl: tt.acl}
+		if got := c.Rights(tt.ids...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
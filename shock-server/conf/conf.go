@@ -70,6 +70,13 @@ func Initialize() {
 	Conf["globus_token_url"], _ = c.String("Auth", "globus_token_url")
 	Conf["globus_profile_url"], _ = c.String("Auth", "globus_profile_url")
 	Conf["mgrast_oauth_url"], _ = c.String("Auth", "mgrast_oauth_url")
+	Conf["token_max_days"], _ = c.String("Auth", "token_max_days")
+	Conf["auth_cache_size"], _ = c.String("Auth", "cache_size")
+	Conf["auth_cache_ttl"], _ = c.String("Auth", "cache_ttl")
+	Conf["auth_failure_cache_size"], _ = c.String("Auth", "failure_cache_size")
+	Conf["auth_failure_ttl"], _ = c.String("Auth", "failure_ttl")
+	Conf["auth_max_failures"], _ = c.String("Auth", "max_failures")
+	Conf["auth_lockout"], _ = c.String("Auth", "lockout")
 
 	// Admin
 	Conf["admin-email"], _ = c.String("Admin", "email")
@@ -81,6 +88,22 @@ func Initialize() {
 	Conf["logs-path"], _ = c.String("Directories", "logs")
 	Conf["local-paths"], _ = c.String("Directories", "local_paths")
 
+	// Storage
+	Conf["storage"], _ = c.String("Storage", "driver")
+	Conf["s3-endpoint"], _ = c.String("S3", "endpoint")
+	Conf["s3-bucket"], _ = c.String("S3", "bucket")
+	Conf["s3-region"], _ = c.String("S3", "region")
+	Conf["s3-access-key"], _ = c.String("S3", "access_key")
+	Conf["s3-secret-key"], _ = c.String("S3", "secret_key")
+
+	// Revisions
+	Conf["max-revisions"], _ = c.String("Revisions", "max")
+
+	// Quota
+	Conf["quota-user-bytes"], _ = c.String("Quota", "user_bytes")
+	Conf["quota-user-nodes"], _ = c.String("Quota", "user_nodes")
+	Conf["quota-max-decompressed"], _ = c.String("Quota", "max_decompressed")
+
 	// Runtime
 	Conf["GOMAXPROCS"], _ = c.String("Runtime", "GOMAXPROCS")
 
@@ -143,11 +166,23 @@ func Print() {
 		fmt.Printf("##### Auth #####\ntype:\tglobus\ntoken_url:\t%s\nprofile_url:\t%s\n\n", Conf["globus_token_url"], Conf["globus_profile_url"])
 	}
 	fmt.Printf("##### Directories #####\nsite:\t%s\ndata:\t%s\nlogs:\t%s\nlocal_paths:\t%s\n\n", Conf["site-path"], Conf["data-path"], Conf["logs-path"], Conf["local-paths"])
+	if Conf["storage"] != "" {
+		fmt.Printf("##### Storage #####\ndriver:\t%s\n\n", Conf["storage"])
+	}
+	if Conf["s3-endpoint"] != "" {
+		fmt.Printf("##### S3 #####\nendpoint:\t%s\nbucket:\t%s\nregion:\t%s\n\n", Conf["s3-endpoint"], Conf["s3-bucket"], Conf["s3-region"])
+	}
 	if Bool(Conf["ssl"]) {
 		fmt.Printf("##### SSL #####\nenabled:\t%s\nkey:\t%s\ncert:\t%s\n\n", Conf["ssl"], Conf["ssl-key"], Conf["ssl-cert"])
 	} else {
 		fmt.Printf("##### SSL #####\nenabled:\t%s\n\n", Conf["ssl"])
 	}
+	if Conf["max-revisions"] != "" {
+		fmt.Printf("##### Revisions #####\nmax:\t%s\n\n", Conf["max-revisions"])
+	}
+	if Conf["quota-user-bytes"] != "" || Conf["quota-user-nodes"] != "" {
+		fmt.Printf("##### Quota #####\nuser_bytes:\t%s\nuser_nodes:\t%s\n\n", Conf["quota-user-bytes"], Conf["quota-user-nodes"])
+	}
 	fmt.Printf("##### Mongodb #####\nhost(s):\t%s\ndatabase:\t%s\n\n", Conf["mongodb-hosts"], Conf["mongodb-database"])
 	fmt.Printf("##### Port #####\napi:\t%s\n\n", Conf["api-port"])
 	if Bool(Conf["perf-log"]) {
@@ -0,0 +1,440 @@
+// Package collection implements /collection resource
+package collection
+
+import (
+	"archive/zip"
+	"github.com/MG-RAST/Shock/shock-server/collection"
+	acon "github.com/MG-RAST/Shock/shock-server/controller/node/acl"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/logger"
+	"github.com/MG-RAST/Shock/shock-server/node"
+	nacl "github.com/MG-RAST/Shock/shock-server/node/acl"
+	"github.com/MG-RAST/Shock/shock-server/request"
+	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"github.com/MG-RAST/Shock/shock-server/util"
+	"github.com/stretchr/goweb/context"
+	"io"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"strings"
+)
+
+// GET, POST: /collection
+// GET lists the collections the user can read, all collections for
+// admins. POST creates a collection owned by the user.
+func CollectionRequest(ctx context.Context) {
+	u := request.RequireAuth(ctx)
+	if u == nil {
+		return
+	}
+	switch ctx.HttpRequest().Method {
+	case "GET":
+		query := ctx.HttpRequest().URL.Query()
+		limit := 25
+		offset := 0
+		if _, ok := query["limit"]; ok {
+			limit = util.ToInt(query.Get("limit"))
+		}
+		if _, ok := query["offset"]; ok {
+			offset = util.ToInt(query.Get("offset"))
+		}
+		ids := u.Ids()
+		if u.Admin {
+			ids = nil
+		}
+		cs, count, err := collection.List(ids, limit, offset)
+		if err != nil {
+			err_msg := "err@collection_List: " + err.Error()
+			logger.Error(err_msg)
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+			return
+		}
+		responder.RespondWithPaginatedData(ctx, cs, limit, offset, count)
+	case "POST":
+		params := parseParams(ctx, "name", "description")
+		c, err := collection.New(params["name"], params["description"], u.Uuid)
+		if err == collection.ErrNameRequired {
+			responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		} else if err != nil {
+			err_msg := "err@collection_New: " + err.Error()
+			logger.Error(err_msg)
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+			return
+		}
+		logger.Info("access", "collection "+c.Id+" created by "+u.Username)
+		responder.RespondWithData(ctx, c)
+	default:
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+	}
+}
+
+// GET, PUT, DELETE: /collection/{cid}
+// GET with ?download streams the files of the member nodes the user can
+// read as a zip archive.
+func CollectionTypedRequest(ctx context.Context) {
+	u, c, rights := load(ctx)
+	if c == nil {
+		return
+	}
+	switch ctx.HttpRequest().Method {
+	case "GET":
+		if !rights["read"] {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+			return
+		}
+		if _, ok := ctx.HttpRequest().URL.Query()["download"]; ok {
+			download(ctx, u, c)
+			return
+		}
+	case "PUT":
+		if !rights["write"] {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+			return
+		}
+		params := parseParams(ctx, "name", "description")
+		if _, ok := params["name"]; ok {
+			if params["name"] == "" {
+				responder.RespondWithError(ctx, http.StatusBadRequest, collection.ErrNameRequired.Error())
+				return
+			}
+			c.Name = params["name"]
+		}
+		if _, ok := params["description"]; ok {
+			c.Description = params["description"]
+		}
+		if err := c.Save(); err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+	case "DELETE":
+		if !rights["delete"] {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+			return
+		}
+		// members first so no node is left pointing at a removed collection
+		if err := node.ClearCollection(c.Id); err != nil {
+			err_msg := "err@collection_Delete: " + err.Error()
+			logger.Error(err_msg)
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+			return
+		}
+		if err := c.Delete(); err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logger.Info("access", "collection "+c.Id+" deleted by "+u.Username)
+		responder.RespondOK(ctx)
+		return
+	default:
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	}
+	responder.RespondWithData(ctx, c)
+}
+
+// GET, PUT, DELETE: /collection/{cid}/nodes
+// GET lists the member nodes the user can read. PUT and DELETE add and
+// remove the nodes in the ids parameter; adding a node requires write
+// rights on the collection and on the node, since its readers gain access.
+func NodesRequest(ctx context.Context) {
+	u, c, rights := load(ctx)
+	if c == nil {
+		return
+	}
+	method := ctx.HttpRequest().Method
+	if method == "GET" {
+		if !rights["read"] {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+			return
+		}
+		listNodes(ctx, u, c)
+		return
+	} else if method != "PUT" && method != "DELETE" {
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	}
+
+	ids := parseParams(ctx, "ids")["ids"]
+	if ids == "" {
+		responder.RespondWithError(ctx, http.StatusBadRequest, "Action requires list of comma separated node ids in 'ids' parameter")
+		return
+	}
+	// check every node before changing any
+	nodes := node.Nodes{}
+	for _, id := range strings.Split(ids, ",") {
+		n, err := node.Load(id, u.Ids()...)
+		if err != nil {
+			if err.Error() == e.UnAuth {
+				responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth+": "+id)
+			} else if err.Error() == e.MongoDocNotFound {
+				responder.RespondWithError(ctx, http.StatusNotFound, "Node not found: "+id)
+			} else {
+				err_msg := "err@collection_LoadNode: " + err.Error()
+				logger.Error(err_msg)
+				responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+			}
+			return
+		}
+		allowed := u.Admin || rights["write"]
+		if method == "PUT" && allowed && !u.Admin && u.Uuid != n.Acl.Owner {
+			nrights, err := n.Rights(u.Ids()...)
+			allowed = err == nil && nrights["write"]
+		}
+		if method == "DELETE" && !allowed {
+			// the node's writers may take it out of a collection
+			nrights, err := n.Rights(u.Ids()...)
+			allowed = u.Uuid == n.Acl.Owner || (err == nil && nrights["write"])
+		}
+		if !allowed {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth+": "+id)
+			return
+		}
+		nodes = append(nodes, n)
+	}
+	for _, n := range nodes {
+		var err error
+		if method == "PUT" {
+			err = n.AddToCollection(c.Id)
+		} else {
+			err = n.RemoveFromCollection(c.Id)
+		}
+		if err != nil {
+			err_msg := "err@collection_Membership: " + err.Error()
+			logger.Error(err_msg)
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+			return
+		}
+	}
+	listNodes(ctx, u, c)
+}
+
+// GET, PUT, DELETE: /collection/{cid}/acl/{type}
+// Works as /node/{nid}/acl/{type}. The collection acl is inherited by
+// its member nodes.
+func AclRequest(ctx context.Context) {
+	rtype := ctx.PathValue("type")
+	if rtype == "" {
+		rtype = "all"
+	}
+	if rtype != "all" && rtype != "read" && rtype != "write" && rtype != "delete" && rtype != "owner" {
+		responder.RespondWithError(ctx, http.StatusBadRequest, "Invalid acl type")
+		return
+	}
+	u, c, rights := load(ctx)
+	if c == nil {
+		return
+	}
+	method := ctx.HttpRequest().Method
+	if method != "GET" {
+		ids, err := acon.ParseAclRequestTyped(ctx, u)
+		if err != nil {
+			responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		if (method == "PUT" || method == "POST") && rights["write"] {
+			if rtype == "owner" {
+				if u.Uuid != c.Acl.Owner && !u.Admin {
+					responder.RespondWithError(ctx, http.StatusBadRequest, "Only owner can change ownership of Collection.")
+					return
+				} else if len(ids) != 1 {
+					responder.RespondWithError(ctx, http.StatusBadRequest, "Too many users. Collections may have only one owner.")
+					return
+				} else if strings.HasPrefix(ids[0], nacl.GroupPrefix) {
+					responder.RespondWithError(ctx, http.StatusBadRequest, "Groups can not own a Collection.")
+					return
+				}
+				c.Acl.SetOwner(ids[0])
+			} else {
+				for _, i := range ids {
+					c.Acl.Set(i, typeRights(rtype))
+				}
+			}
+		} else if method == "DELETE" && rights["delete"] {
+			if rtype == "owner" {
+				responder.RespondWithError(ctx, http.StatusBadRequest, "Deleting ownership is not a supported request type.")
+				return
+			}
+			for _, i := range ids {
+				c.Acl.UnSet(i, typeRights(rtype))
+			}
+		} else {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+			return
+		}
+		if err := c.Save(); err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+	} else if !rights["read"] {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		return
+	}
+
+	switch rtype {
+	case "read":
+		responder.RespondWithData(ctx, map[string][]string{"read": c.Acl.Read})
+	case "write":
+		responder.RespondWithData(ctx, map[string][]string{"write": c.Acl.Write})
+	case "delete":
+		responder.RespondWithData(ctx, map[string][]string{"delete": c.Acl.Delete})
+	case "owner":
+		responder.RespondWithData(ctx, map[string]string{"owner": c.Acl.Owner})
+	default:
+		responder.RespondWithData(ctx, c.Acl)
+	}
+}
+
+func typeRights(rtype string) nacl.Rights {
+	if rtype == "all" {
+		return nacl.Rights{"read": true, "write": true, "delete": true}
+	}
+	return nacl.Rights{rtype: true}
+}
+
+// load authenticates the request and loads the collection {cid} with the
+// rights of the user on it. If the request can not be served an error
+// response is written and c is nil.
+func load(ctx context.Context) (u *user.User, c *collection.Collection, rights nacl.Rights) {
+	if u = request.RequireAuth(ctx); u == nil {
+		return nil, nil, nil
+	}
+	c, err := collection.Load(ctx.PathValue("cid"))
+	if err == mgo.ErrNotFound {
+		responder.RespondWithError(ctx, http.StatusNotFound, "Collection not found")
+		return nil, nil, nil
+	} else if err != nil {
+		err_msg := "err@collection_Load: " + err.Error()
+		logger.Error(err_msg)
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+		return nil, nil, nil
+	}
+	if u.Admin {
+		rights = nacl.Rights{"read": true, "write": true, "delete": true}
+	} else {
+		rights = c.Rights(u.Ids()...)
+	}
+	if !rights["read"] && !rights["write"] && !rights["delete"] {
+		// do not reveal collections to users without any rights
+		responder.RespondWithError(ctx, http.StatusNotFound, "Collection not found")
+		return nil, nil, nil
+	}
+	return u, c, rights
+}
+
+// parseParams returns the named parameters of a multipart form, or of the
+// url query for other requests. Only parameters present are set.
+func parseParams(ctx context.Context, names ...string) map[string]string {
+	params := map[string]string{}
+	form, _, err := request.ParseMultipartForm(ctx.HttpRequest())
+	if err != nil {
+		query := ctx.HttpRequest().URL.Query()
+		for _, name := range names {
+			if _, ok := query[name]; ok {
+				params[name] = query.Get(name)
+			}
+		}
+		return params
+	}
+	for _, name := range names {
+		if v, ok := form[name]; ok {
+			params[name] = v
+		}
+	}
+	return params
+}
+
+// memberQuery selects the member nodes of c that u can read
+func memberQuery(u *user.User, c *collection.Collection) (q bson.M, err error) {
+	q = bson.M{"collections": c.Id}
+	if !u.Admin {
+		if q["$or"], err = node.ReadableBy(u); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func listNodes(ctx context.Context, u *user.User, c *collection.Collection) {
+	query := ctx.HttpRequest().URL.Query()
+	limit := 25
+	offset := 0
+	if _, ok := query["limit"]; ok {
+		limit = util.ToInt(query.Get("limit"))
+	}
+	if _, ok := query["offset"]; ok {
+		offset = util.ToInt(query.Get("offset"))
+	}
+	q, err := memberQuery(u, c)
+	if err != nil {
+		responder.RespondWithError(ctx, http.StatusInternalServerError, "err "+err.Error())
+		return
+	}
+	nodes := node.Nodes{}
+	count, err := nodes.GetPaginated(q, limit, offset, "id")
+	if err != nil {
+		err_msg := "err " + err.Error()
+		logger.Error(err_msg)
+		responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
+		return
+	}
+	responder.RespondWithPaginatedData(ctx, nodes, limit, offset, count)
+}
+
+// download streams the files of the readable member nodes of c as a zip
+// archive with an entry <node id>/<file name> per node.
+func download(ctx context.Context, u *user.User, c *collection.Collection) {
+	q, err := memberQuery(u, c)
+	if err != nil {
+		responder.RespondWithError(ctx, http.StatusInternalServerError, "err "+err.Error())
+		return
+	}
+	nodes := node.Nodes{}
+	if err := nodes.GetAll(q); err != nil {
+		responder.RespondWithError(ctx, http.StatusInternalServerError, "err "+err.Error())
+		return
+	}
+	w := ctx.HttpResponseWriter()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", " attachment; filename="+c.Id+".zip")
+	z := zip.NewWriter(w)
+	for _, n := range nodes {
+		if !n.HasFile() {
+			continue
+		}
+		name := n.File.Name
+		if name == "" {
+			name = n.Id
+		}
+		if err := addFile(z, n, n.Id+"/"+name); err != nil {
+			// headers are sent, the truncated archive reports the failure
+			logger.Error("err@collection_download: " + n.Id + ": " + err.Error())
+			return
+		}
+	}
+	if err := z.Close(); err != nil {
+		logger.Error("err@collection_download: " + err.Error())
+	}
+}
+
+func addFile(z *zip.Writer, n *node.Node, name string) (err error) {
+	r, err := n.FileReader()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	fh := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	if n.Modified.IsZero() {
+		fh.SetModTime(n.Created)
+	} else {
+		fh.SetModTime(n.Modified)
+	}
+	w, err := z.CreateHeader(fh)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(w, io.NewSectionReader(r, 0, n.File.Size))
+	return
+}
@@ -0,0 +1,140 @@
+// Package group implements /group resource
+package group
+
+import (
+	"code.google.com/p/go-uuid/uuid"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/group"
+	"github.com/MG-RAST/Shock/shock-server/logger"
+	"github.com/MG-RAST/Shock/shock-server/request"
+	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"github.com/stretchr/goweb/context"
+	"labix.org/v2/mgo"
+	"net/http"
+	"strings"
+)
+
+// GET: /group
+// Lists the local groups the user owns or is a member of, all groups for admins.
+func GroupRequest(ctx context.Context) {
+	if ctx.HttpRequest().Method != "GET" {
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	}
+	u := request.RequireAuth(ctx)
+	if u == nil {
+		return
+	}
+	uuid := u.Uuid
+	if u.Admin {
+		uuid = ""
+	}
+	groups, err := group.List(uuid)
+	if err != nil {
+		err_msg := "err@group_List: " + err.Error()
+		logger.Error(err_msg)
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+		return
+	}
+	responder.RespondWithData(ctx, groups)
+}
+
+// GET, PUT, DELETE: /group/{name}
+// PUT creates the group if needed and adds the users given in the users
+// parameter. DELETE removes the given users, or the group if none are given.
+func GroupTypedRequest(ctx context.Context) {
+	name := ctx.PathValue("name")
+	u := request.RequireAuth(ctx)
+	if u == nil {
+		return
+	}
+
+	method := ctx.HttpRequest().Method
+	users, err := parseUsers(ctx)
+	if err != nil {
+		responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	g, err := group.Load(name)
+	if err == mgo.ErrNotFound && (method == "PUT" || method == "POST") {
+		if g, err = group.New(name, u.Uuid); err == group.ErrInvalidName {
+			responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		} else if err == nil {
+			logger.Info("access", "group "+name+" created by "+u.Username)
+		}
+	}
+	if err == mgo.ErrNotFound {
+		responder.RespondWithError(ctx, http.StatusNotFound, "Group not found")
+		return
+	} else if err != nil {
+		err_msg := "err@group_Load: " + err.Error()
+		logger.Error(err_msg)
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+		return
+	}
+
+	switch method {
+	case "GET":
+		if !g.CanView(u.Uuid, u.Admin) {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+			return
+		}
+	case "PUT", "POST":
+		if !g.CanManage(u.Uuid, u.Admin) {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+			return
+		}
+		if len(users) > 0 {
+			if err := g.AddMembers(users); err != nil {
+				responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+	case "DELETE":
+		if !g.CanManage(u.Uuid, u.Admin) {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+			return
+		}
+		if len(users) == 0 {
+			if err := g.Delete(); err != nil {
+				responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+				return
+			}
+			logger.Info("access", "group "+name+" deleted by "+u.Username)
+			responder.RespondOK(ctx)
+			return
+		}
+		if err := g.RemoveMembers(users); err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+	default:
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	}
+	responder.RespondWithData(ctx, g)
+}
+
+// parseUsers returns the uuids of the comma separated usernames or uuids
+// in the users parameter.
+func parseUsers(ctx context.Context) (ids []string, err error) {
+	users := ctx.HttpRequest().URL.Query().Get("users")
+	if users == "" {
+		return nil, nil
+	}
+	for _, v := range strings.Split(users, ",") {
+		if uuid.Parse(v) != nil {
+			ids = append(ids, v)
+		} else {
+			u := user.User{Username: v}
+			if err := u.SetUuid(); err != nil {
+				return nil, err
+			}
+			ids = append(ids, u.Uuid)
+		}
+	}
+	return ids, nil
+}
@@ -5,8 +5,10 @@ import (
 	"code.google.com/p/go-uuid/uuid"
 	"errors"
 	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/group"
 	"github.com/MG-RAST/Shock/shock-server/logger"
 	"github.com/MG-RAST/Shock/shock-server/node"
+	nacl "github.com/MG-RAST/Shock/shock-server/node/acl"
 	"github.com/MG-RAST/Shock/shock-server/request"
 	"github.com/MG-RAST/Shock/shock-server/responder"
 	"github.com/MG-RAST/Shock/shock-server/user"
@@ -16,7 +18,7 @@ import (
 )
 
 var (
-	validAclTypes = map[string]bool{"all": true, "read": true, "write": true, "delete": true, "owner": true}
+	validAclTypes = map[string]bool{"all": true, "read": true, "write": true, "delete": true, "owner": true, "inherit": true}
 )
 
 // GET, POST, PUT, DELETE: /node/{nid}/acl/
@@ -37,7 +39,7 @@ func AclRequest(ctx context.Context) {
 	}
 
 	// Load node and handle user unauthorized
-	n, err := node.Load(nid, u.Uuid)
+	n, err := node.Load(nid, u.Ids()...)
 	if err != nil {
 		if err.Error() == e.UnAuth {
 			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
@@ -55,7 +57,11 @@ func AclRequest(ctx context.Context) {
 		}
 	}
 
-	rights := n.Acl.Check(u.Uuid)
+	rights, err := n.Rights(u.Ids()...)
+	if err != nil {
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if ctx.HttpRequest().Method == "GET" {
 		if u.Uuid == n.Acl.Owner || rights["read"] {
 			responder.RespondWithData(ctx, n.Acl)
@@ -92,7 +98,7 @@ func AclTypedRequest(ctx context.Context) {
 	}
 
 	// Load node and handle user unauthorized
-	n, err := node.Load(nid, u.Uuid)
+	n, err := node.Load(nid, u.Ids()...)
 	if err != nil {
 		if err.Error() == e.UnAuth {
 			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
@@ -110,10 +116,18 @@ func AclTypedRequest(ctx context.Context) {
 		}
 	}
 
-	rights := n.Acl.Check(u.Uuid)
+	rights, err := n.Rights(u.Ids()...)
+	if err != nil {
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
 	requestMethod := ctx.HttpRequest().Method
+	if rtype == "inherit" {
+		inheritRequest(ctx, u, n, rights)
+		return
+	}
 	if requestMethod != "GET" {
-		ids, err := parseAclRequestTyped(ctx)
+		ids, err := ParseAclRequestTyped(ctx, u)
 		if err != nil {
 			responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
 			return
@@ -121,7 +135,10 @@ func AclTypedRequest(ctx context.Context) {
 		if (requestMethod == "POST" || requestMethod == "PUT") && (u.Uuid == n.Acl.Owner || rights["write"]) {
 			if rtype == "owner" {
 				if u.Uuid == n.Acl.Owner {
-					if len(ids) == 1 {
+					if len(ids) == 1 && strings.HasPrefix(ids[0], nacl.GroupPrefix) {
+						responder.RespondWithError(ctx, http.StatusBadRequest, "Groups can not own a Node.")
+						return
+					} else if len(ids) == 1 {
 						n.Acl.SetOwner(ids[0])
 					} else {
 						responder.RespondWithError(ctx, http.StatusBadRequest, "Too many users. Nodes may have only one owner.")
@@ -185,16 +202,23 @@ func AclTypedRequest(ctx context.Context) {
 	return
 }
 
-func parseAclRequestTyped(ctx context.Context) (ids []string, err error) {
-	var users []string
+// ParseAclRequestTyped returns the acl entries named by the users and
+// groups parameters of an acl modification request.
+func ParseAclRequestTyped(ctx context.Context, u *user.User) (ids []string, err error) {
+	var users, groups []string
 	query := ctx.HttpRequest().URL.Query()
 	params, _, err := request.ParseMultipartForm(ctx.HttpRequest())
-	if _, ok := query["users"]; ok && err != nil && err.Error() == "request Content-Type isn't multipart/form-data" {
-		users = strings.Split(query.Get("users"), ",")
-	} else if params["users"] != "" {
+	if err != nil && err.Error() == "request Content-Type isn't multipart/form-data" {
+		params = map[string]string{"users": query.Get("users"), "groups": query.Get("groups")}
+	}
+	if params["users"] != "" {
 		users = strings.Split(params["users"], ",")
-	} else {
-		return nil, errors.New("Action requires list of comma separated usernames in 'users' parameter")
+	}
+	if params["groups"] != "" {
+		groups = strings.Split(params["groups"], ",")
+	}
+	if len(users) == 0 && len(groups) == 0 {
+		return nil, errors.New("Action requires list of comma separated usernames in 'users' parameter or group names in 'groups' parameter")
 	}
 	for _, v := range users {
 		if uuid.Parse(v) != nil {
@@ -207,5 +231,51 @@ func parseAclRequestTyped(ctx context.Context) (ids []string, err error) {
 			ids = append(ids, u.Uuid)
 		}
 	}
+	for _, name := range groups {
+		// unknown groups can still be removed
+		if ctx.HttpRequest().Method != "DELETE" && !knownGroup(name, u) {
+			return nil, errors.New("Unknown group: " + name)
+		}
+		ids = append(ids, nacl.GroupId(name))
+	}
 	return ids, nil
 }
+
+// inheritRequest shows or, for the node owner, sets whether the node
+// inherits the acls of its collections: PUT /node/{nid}/acl/inherit?value=false
+func inheritRequest(ctx context.Context, u *user.User, n *node.Node, rights nacl.Rights) {
+	if ctx.HttpRequest().Method == "PUT" || ctx.HttpRequest().Method == "POST" {
+		if u.Uuid != n.Acl.Owner && !u.Admin {
+			responder.RespondWithError(ctx, http.StatusBadRequest, "Only owner can change acl inheritance of Node.")
+			return
+		}
+		value := ctx.HttpRequest().URL.Query().Get("value")
+		if value != "true" && value != "false" {
+			responder.RespondWithError(ctx, http.StatusBadRequest, "Action requires value parameter of true or false")
+			return
+		}
+		if err := n.SetInherit(value == "true"); err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+	} else if ctx.HttpRequest().Method != "GET" {
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	} else if u.Uuid != n.Acl.Owner && !rights["read"] {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		return
+	}
+	responder.RespondWithData(ctx, map[string]interface{}{"inherit": !n.NoInherit, "collections": n.Collections})
+}
+
+// knownGroup returns true for local groups and for groups the auth
+// provider asserts u is a member of, so typos do not silently grant nothing.
+func knownGroup(name string, u *user.User) bool {
+	for _, g := range u.Groups {
+		if g == name {
+			return true
+		}
+	}
+	_, err := group.Load(name)
+	return err == nil
+}
@@ -0,0 +1,70 @@
+package node
+
+import (
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/node"
+	"github.com/MG-RAST/Shock/shock-server/request"
+	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"github.com/stretchr/goweb/context"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+)
+
+// bulkResponse reports the outcome of a bulk operation for every matched node
+type bulkResponse struct {
+	DryRun    bool         `json:"dry_run"`
+	Matched   int          `json:"matched"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []bulkResult `json:"results"`
+}
+
+type bulkResult struct {
+	Id    string `json:"id"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func (b *bulkResponse) add(id string, err error) {
+	r := bulkResult{Id: id, Ok: err == nil}
+	if err != nil {
+		r.Error = err.Error()
+		b.Failed++
+	} else {
+		b.Succeeded++
+	}
+	b.Results = append(b.Results, r)
+}
+
+// bulkNodes authenticates a bulk request and loads the nodes it selects
+// with the same query parameters as GET /node. A selection is required
+// so a bare request can not modify every node. If the request can not
+// be served an error response is written and u is nil.
+func bulkNodes(ctx context.Context) (u *user.User, nodes node.Nodes, err error) {
+	if u, err = request.Authenticate(ctx.HttpRequest()); err != nil {
+		if err.Error() == e.NoAuth {
+			return nil, nil, responder.RespondWithError(ctx, http.StatusUnauthorized, e.NoAuth)
+		}
+		return nil, nil, request.AuthError(err, ctx)
+	}
+
+	query := ctx.HttpRequest().URL.Query()
+	dsl, err := urlQuery(query)
+	if err != nil {
+		return nil, nil, responder.RespondWithError(ctx, http.StatusBadRequest, "Invalid query: "+err.Error())
+	}
+	q := bson.M{}
+	if !u.Admin {
+		if q["$or"], err = node.ReadableBy(u); err != nil {
+			return nil, nil, responder.RespondWithError(ctx, http.StatusInternalServerError, "err "+err.Error())
+		}
+	}
+	if !selectNodes(q, query, dsl) {
+		return nil, nil, responder.RespondWithError(ctx, http.StatusBadRequest, "bulk operations require a query selecting the nodes")
+	}
+	if err = nodes.GetAll(q); err != nil {
+		return nil, nil, responder.RespondWithError(ctx, http.StatusInternalServerError, "err "+err.Error())
+	}
+	return u, nodes, nil
+}
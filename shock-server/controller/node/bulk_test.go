@@ -0,0 +1,151 @@
+package node_test
+
+import (
+	"errors"
+	. "github.com/MG-RAST/Shock/shock-server/controller/node"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/node"
+	"github.com/MG-RAST/Shock/shock-server/node/acl"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"reflect"
+	"testing"
+)
+
+// testNodes returns the nodes of a virtual node v1 made of p1 and p2, and
+// an unrelated node p3. u1 owns all of them but p2, which u2 owns and u1
+// can only read.
+func testNodes() node.Nodes {
+	n := func(id, owner string, virtual bool, parts ...string) *node.Node {
+		a := acl.Acl{Owner: owner, Read: []string{owner, "u1"}, Write: []string{owner}, Delete: []string{owner}}
+		nd := &node.Node{Id: id, Acl: a}
+		nd.File.Virtual, nd.File.VirtualParts = virtual, parts
+		return nd
+	}
+	// parts come first to check that virtual nodes are deleted before them
+	return node.Nodes{n("p1", "u1", false), n("p2", "u2", false), n("p3", "u1", false), n("v1", "u1", true, "p1", "p2")}
+}
+
+// stubDelete replaces node deletion with a fake store of nodes, it returns
+// the ids of the deleted nodes and a function restoring the real one
+func stubDelete(nodes node.Nodes) (deleted map[string]bool, restore func()) {
+	del, refs := *DeleteNode, *VirtualReferences
+	deleted = map[string]bool{}
+	references := func(n *node.Node) (ids []string, err error) {
+		for _, v := range nodes {
+			if !deleted[v.Id] && v.File.Virtual {
+				for _, id := range v.File.VirtualParts {
+					if id == n.Id {
+						ids = append(ids, v.Id)
+					}
+				}
+			}
+		}
+		return
+	}
+	*VirtualReferences = references
+	*DeleteNode = func(n *node.Node) error {
+		if ids, _ := references(n); len(ids) > 0 {
+			return errors.New(e.NodeReferenced)
+		}
+		deleted[n.Id] = true
+		return nil
+	}
+	return deleted, func() { *DeleteNode, *VirtualReferences = del, refs }
+}
+
+// checkCounts checks that the counts of a bulk response add up
+func checkCounts(t *testing.T, matched, succeeded, failed int) {
+	if succeeded+failed != matched {
+		t.Errorf("%d succeeded and %d failed of %d matched", succeeded, failed, matched)
+	}
+}
+
+func TestBulkDelete(t *testing.T) {
+	u1 := &user.User{Uuid: "u1"}
+	for _, dryRun := range []bool{true, false} {
+		nodes := testNodes()
+		deleted, restore := stubDelete(nodes)
+		res := BulkDelete(u1, nodes, dryRun)
+		checkCounts(t, res.Matched, res.Succeeded, res.Failed)
+		got := res.Outcome()
+		restore()
+		// p1 goes with v1, p2 can not be deleted by u1
+		want := map[string]string{"v1": "", "p1": "", "p2": e.UnAuth, "p3": ""}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("dryrun %v: got %v, want %v", dryRun, got, want)
+		}
+		if dryRun && len(deleted) > 0 {
+			t.Errorf("dryrun deleted %v", deleted)
+		} else if !dryRun && !reflect.DeepEqual(deleted, map[string]bool{"v1": true, "p1": true, "p3": true}) {
+			t.Errorf("deleted %v", deleted)
+		}
+	}
+
+	// a part of a virtual node that is not deleted is kept
+	for _, dryRun := range []bool{true, false} {
+		nodes := testNodes()
+		deleted, restore := stubDelete(nodes)
+		res := BulkDelete(u1, node.Nodes{nodes[0], nodes[2]}, dryRun)
+		checkCounts(t, res.Matched, res.Succeeded, res.Failed)
+		got := res.Outcome()
+		restore()
+		want := map[string]string{"p1": e.NodeReferenced, "p3": ""}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("dryrun %v: got %v, want %v", dryRun, got, want)
+		}
+		if deleted["p1"] {
+			t.Errorf("dryrun %v: referenced part deleted", dryRun)
+		}
+	}
+
+	// the owner of a part can not delete it while v1 references it
+	nodes := testNodes()
+	_, restore := stubDelete(nodes)
+	res := BulkDelete(&user.User{Uuid: "u2"}, nodes, true)
+	checkCounts(t, res.Matched, res.Succeeded, res.Failed)
+	got := res.Outcome()
+	restore()
+	want := map[string]string{"v1": e.UnAuth, "p1": e.UnAuth, "p2": e.NodeReferenced, "p3": e.UnAuth}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBulkUpdate(t *testing.T) {
+	update := *UpdateNode
+	defer func() { *UpdateNode = update }()
+	params := map[string]string{"tags": "a,b"}
+	for _, dryRun := range []bool{true, false} {
+		updated := map[string]bool{}
+		*UpdateNode = func(n *node.Node, p map[string]string, files node.FormFiles, u *user.User) error {
+			if !reflect.DeepEqual(p, params) {
+				t.Errorf("update with %v, want %v", p, params)
+			}
+			updated[n.Id] = true
+			return nil
+		}
+		res := BulkUpdate(&user.User{Uuid: "u1"}, testNodes(), params, dryRun)
+		checkCounts(t, res.Matched, res.Succeeded, res.Failed)
+		got := res.Outcome()
+		want := map[string]string{"v1": "", "p1": "", "p2": e.UnAuth, "p3": ""}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("dryrun %v: got %v, want %v", dryRun, got, want)
+		}
+		if dryRun && len(updated) > 0 {
+			t.Errorf("dryrun updated %v", updated)
+		} else if !dryRun && !reflect.DeepEqual(updated, map[string]bool{"v1": true, "p1": true, "p3": true}) {
+			t.Errorf("updated %v", updated)
+		}
+	}
+
+	// admins may update any node
+	*UpdateNode = func(n *node.Node, p map[string]string, files node.FormFiles, u *user.User) error { return nil }
+	res := BulkUpdate(&user.User{Uuid: "u3", Admin: true}, testNodes(), params, false)
+	checkCounts(t, res.Matched, res.Succeeded, res.Failed)
+	got := res.Outcome()
+	for id, err := range got {
+		if err != "" {
+			t.Errorf("%s: %s", id, err)
+		}
+	}
+}
@@ -5,8 +5,10 @@ import (
 	e "github.com/MG-RAST/Shock/shock-server/errors"
 	"github.com/MG-RAST/Shock/shock-server/logger"
 	"github.com/MG-RAST/Shock/shock-server/node"
+	"github.com/MG-RAST/Shock/shock-server/quota"
 	"github.com/MG-RAST/Shock/shock-server/request"
 	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/schema"
 	"github.com/MG-RAST/Shock/shock-server/user"
 	"github.com/stretchr/goweb/context"
 	"net/http"
@@ -47,7 +49,13 @@ func (cr *NodeController) Create(ctx context.Context) error {
 
 			n, cn_err := node.CreateNodeUpload(u, params, files)
 
-			if cn_err != nil {
+			if verr, ok := cn_err.(*schema.ValidationError); ok {
+				return respondWithValidationError(ctx, verr)
+			} else if qerr, ok := cn_err.(*quota.ExceededError); ok {
+				return respondWithQuotaError(ctx, qerr)
+			} else if cn_err != nil && cn_err.Error() == e.TokenScope {
+				return responder.RespondWithError(ctx, http.StatusUnauthorized, e.TokenScope)
+			} else if cn_err != nil {
 				err_msg := "Error at create empty node: " + cn_err.Error()
 				logger.Error(err_msg)
 				return responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
@@ -71,7 +79,13 @@ func (cr *NodeController) Create(ctx context.Context) error {
 
 	// Create node
 	n, err := node.CreateNodeUpload(u, params, files)
-	if err != nil {
+	if verr, ok := err.(*schema.ValidationError); ok {
+		return respondWithValidationError(ctx, verr)
+	} else if qerr, ok := err.(*quota.ExceededError); ok {
+		return respondWithQuotaError(ctx, qerr)
+	} else if err != nil && err.Error() == e.TokenScope {
+		return responder.RespondWithError(ctx, http.StatusUnauthorized, e.TokenScope)
+	} else if err != nil {
 		err_msg := "err@node_CreateNodeUpload: " + err.Error()
 		logger.Error(err_msg)
 		return responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
@@ -1,11 +1,14 @@
 package node
 
 import (
+	"errors"
+	"fmt"
 	e "github.com/MG-RAST/Shock/shock-server/errors"
 	"github.com/MG-RAST/Shock/shock-server/logger"
 	"github.com/MG-RAST/Shock/shock-server/node"
 	"github.com/MG-RAST/Shock/shock-server/request"
 	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/user"
 	"github.com/stretchr/goweb/context"
 	"net/http"
 )
@@ -22,7 +25,7 @@ func (cr *NodeController) Delete(id string, ctx context.Context) error {
 	}
 
 	// Load node and handle user unauthorized
-	n, err := node.Load(id, u.Uuid)
+	n, err := node.Load(id, u.Ids()...)
 	if err != nil {
 		if err.Error() == e.UnAuth {
 			return responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
@@ -45,3 +48,65 @@ func (cr *NodeController) Delete(id string, ctx context.Context) error {
 		return responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
 	}
 }
+
+// DELETE: /node?query&<key>=<value>[&dryrun]
+func (cr *NodeController) DeleteMany(ctx context.Context) error {
+	u, nodes, err := bulkNodes(ctx)
+	if u == nil {
+		return err
+	}
+	_, dryRun := ctx.HttpRequest().URL.Query()["dryrun"]
+	res := bulkDelete(u, nodes, dryRun)
+	if !dryRun && res.Failed > 0 {
+		logger.Error(fmt.Sprintf("Err@node_DeleteMany: %d of %d nodes not deleted", res.Failed, res.Matched))
+	}
+	return responder.RespondWithData(ctx, res)
+}
+
+// deleteNode and virtualReferences are replaced in tests
+var (
+	deleteNode        = (*node.Node).Delete
+	virtualReferences = (*node.Node).VirtualReferences
+)
+
+// bulkDelete deletes the nodes u may delete, or only checks that they can
+// be deleted if dryRun is set
+func bulkDelete(u *user.User, nodes node.Nodes, dryRun bool) bulkResponse {
+	res := bulkResponse{DryRun: dryRun, Matched: len(nodes), Results: []bulkResult{}}
+	// virtual nodes go first so the nodes they reference can be deleted with them
+	deletable := map[string]bool{}
+	for _, virtual := range []bool{true, false} {
+		for _, n := range nodes {
+			if n.File.Virtual != virtual {
+				continue
+			}
+			err := n.CheckRight(u, "delete")
+			if err == nil {
+				if dryRun {
+					err = checkReferences(n, deletable)
+				} else {
+					err = deleteNode(n)
+				}
+			}
+			if err == nil {
+				deletable[n.Id] = true
+			}
+			res.add(n.Id, err)
+		}
+	}
+	return res
+}
+
+// checkReferences fails if n is part of a virtual node that is not deleted with it
+func checkReferences(n *node.Node, deleted map[string]bool) error {
+	ids, err := virtualReferences(n)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if !deleted[id] {
+			return errors.New(e.NodeReferenced)
+		}
+	}
+	return nil
+}
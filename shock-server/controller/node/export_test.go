@@ -0,0 +1,19 @@
+package node
+
+// exported for the tests of node_test
+var (
+	BulkDelete        = bulkDelete
+	BulkUpdate        = bulkUpdate
+	DeleteNode        = &deleteNode
+	UpdateNode        = &updateNode
+	VirtualReferences = &virtualReferences
+)
+
+// Outcome maps the ids of the response to their error, "" on success
+func (b bulkResponse) Outcome() map[string]string {
+	m := map[string]string{}
+	for _, r := range b.Results {
+		m[r.Id] = r.Error
+	}
+	return m
+}
@@ -12,7 +12,6 @@ import (
 	"github.com/MG-RAST/Shock/shock-server/user"
 	"github.com/stretchr/goweb/context"
 	"net/http"
-	"os"
 )
 
 type getRes struct {
@@ -39,7 +38,7 @@ func IndexTypedRequest(ctx context.Context) {
 	}
 
 	// Load node and handle user unauthorized
-	n, err := node.Load(nid, u.Uuid)
+	n, err := node.Load(nid, u.Ids()...)
 	if err != nil {
 		if err.Error() == e.UnAuth {
 			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
@@ -90,23 +89,18 @@ func IndexTypedRequest(ctx context.Context) {
 			logger.Perf("START indexing: " + nid)
 		}
 
-		if idxType == "bai" {
-			//bam index is created by the command-line tool samtools
-			if ext := n.FileExt(); ext == ".bam" {
-				if err := index.CreateBamIndex(n.FilePath()); err != nil {
-					responder.RespondWithError(ctx, http.StatusBadRequest, "Error while creating bam index")
-					return
-				}
-				responder.RespondOK(ctx)
-				return
-			} else {
-				responder.RespondWithError(ctx, http.StatusBadRequest, "Index type bai requires .bam file")
-				return
-			}
+		if idxType == "bai" && n.FileExt() != ".bam" {
+			responder.RespondWithError(ctx, http.StatusBadRequest, "Index type bai requires .bam file")
+			return
 		}
 
 		newIndexer := index.Indexers[idxType]
-		f, _ := os.Open(n.FilePath())
+		f, err := n.LocalFile()
+		if err != nil {
+			logger.Error("err " + err.Error())
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
 		defer f.Close()
 		idxer := newIndexer(f)
 		count, err := idxer.Create(n.IndexPath() + "/" + idxType + ".idx")
@@ -117,9 +111,11 @@ func IndexTypedRequest(ctx context.Context) {
 		}
 
 		idxInfo := node.IdxInfo{
-			Type:        idxType,
-			TotalUnits:  count,
-			AvgUnitSize: n.File.Size / count,
+			Type:       idxType,
+			TotalUnits: count,
+		}
+		if count > 0 {
+			idxInfo.AvgUnitSize = n.File.Size / count
 		}
 
 		if idxType == "chunkrecord" {
@@ -143,15 +139,6 @@ func IndexTypedRequest(ctx context.Context) {
 	return
 }
 
-func contains(list []string, s string) bool {
-	for _, i := range list {
-		if i == s {
-			return true
-		}
-	}
-	return false
-}
-
 func filteredIndexes(i node.Indexes) (indexes []string) {
 	for _, name := range availIndexers() {
 		if _, has := i[name]; !has {
@@ -3,6 +3,7 @@ package node
 import (
 	"fmt"
 	"github.com/MG-RAST/Shock/shock-server/conf"
+	dbquery "github.com/MG-RAST/Shock/shock-server/db/query"
 	e "github.com/MG-RAST/Shock/shock-server/errors"
 	"github.com/MG-RAST/Shock/shock-server/logger"
 	"github.com/MG-RAST/Shock/shock-server/node"
@@ -12,6 +13,7 @@ import (
 	"github.com/stretchr/goweb/context"
 	"labix.org/v2/mgo/bson"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -19,6 +21,67 @@ import (
 // To do:
 // - Iterate node queries
 func (cr *NodeController) ReadMany(ctx context.Context) error {
+	dsl, err := urlQuery(ctx.HttpRequest().URL.Query())
+	if err != nil {
+		return responder.RespondWithError(ctx, http.StatusBadRequest, "Invalid query: "+err.Error())
+	}
+	return readMany(ctx, dsl)
+}
+
+// urlQuery parses ?query={json} with the query language, nil for other queries
+func urlQuery(query url.Values) (dsl bson.M, err error) {
+	if qs := query.Get("query"); strings.HasPrefix(strings.TrimSpace(qs), "{") {
+		return dbquery.ParseBytes([]byte(qs))
+	}
+	return nil, nil
+}
+
+// selectNodes adds the node selection of a listing request to q: a
+// query language query, ?query&key=value attribute matches or
+// ?querynode&key=value node field matches. It returns false if nothing is selected.
+func selectNodes(q bson.M, query url.Values, dsl bson.M) bool {
+	// Gather params to make db query. Do not include the
+	// following list.
+	paramlist := map[string]int{"limit": 1, "offset": 1, "query": 1, "querynode": 1, "order": 1, "direction": 1, "fields": 1, "cursor": 1, "dryrun": 1}
+	selected := false
+	if dsl != nil {
+		// kept apart from the acl $or
+		q["$and"] = []bson.M{dsl}
+		selected = true
+	} else if _, ok := query["query"]; ok {
+		for key := range query {
+			if _, found := paramlist[key]; !found {
+				q[fmt.Sprintf("attributes.%s", key)] = query.Get(key)
+				selected = true
+			}
+		}
+	} else if _, ok := query["querynode"]; ok {
+		for key := range query {
+			if key == "type" {
+				querytypes := strings.Split(query.Get(key), ",")
+				q["type"] = bson.M{"$all": querytypes}
+				selected = true
+			} else {
+				if _, found := paramlist[key]; !found {
+					q[key] = query.Get(key)
+					selected = true
+				}
+			}
+		}
+	}
+	return selected
+}
+
+// POST: /node/query -> json query in request body
+func QueryRequest(ctx context.Context) error {
+	dsl, err := dbquery.Parse(ctx.HttpRequest().Body)
+	if err != nil {
+		return responder.RespondWithError(ctx, http.StatusBadRequest, "Invalid query: "+err.Error())
+	}
+	return readMany(ctx, dsl)
+}
+
+func readMany(ctx context.Context, dsl bson.M) error {
 	u, err := request.Authenticate(ctx.HttpRequest())
 	if err != nil && err.Error() != e.NoAuth {
 		return request.AuthError(err, ctx)
@@ -34,7 +97,9 @@ func (cr *NodeController) ReadMany(ctx context.Context) error {
 	if u != nil {
 		// Admin sees all
 		if !u.Admin {
-			q["$or"] = []bson.M{bson.M{"acl.read": []string{}}, bson.M{"acl.read": u.Uuid}, bson.M{"acl.owner": u.Uuid}}
+			if q["$or"], err = node.ReadableBy(u); err != nil {
+				return responder.RespondWithError(ctx, http.StatusInternalServerError, "err "+err.Error())
+			}
 		}
 	} else {
 		if conf.Bool(conf.Conf["anon-read"]) {
@@ -45,27 +110,7 @@ func (cr *NodeController) ReadMany(ctx context.Context) error {
 		}
 	}
 
-	// Gather params to make db query. Do not include the
-	// following list.
-	paramlist := map[string]int{"limit": 1, "offset": 1, "query": 1, "querynode": 1}
-	if _, ok := query["query"]; ok {
-		for key := range query {
-			if _, found := paramlist[key]; !found {
-				q[fmt.Sprintf("attributes.%s", key)] = query.Get(key)
-			}
-		}
-	} else if _, ok := query["querynode"]; ok {
-		for key := range query {
-			if key == "type" {
-				querytypes := strings.Split(query.Get(key), ",")
-				q["type"] = bson.M{"$all": querytypes}
-			} else {
-				if _, found := paramlist[key]; !found {
-					q[key] = query.Get(key)
-				}
-			}
-		}
-	}
+	selectNodes(q, query, dsl)
 
 	// defaults
 	limit := 25
@@ -77,8 +122,38 @@ func (cr *NodeController) ReadMany(ctx context.Context) error {
 		offset = util.ToInt(query.Get("offset"))
 	}
 
+	// ?order=<field>[&direction=asc|desc]
+	order := []string{}
+	if _, ok := query["order"]; ok {
+		if order, err = dbquery.Sort(query.Get("order"), query.Get("direction")); err != nil {
+			return responder.RespondWithError(ctx, http.StatusBadRequest, "Invalid order: "+err.Error())
+		}
+	}
+
+	// ?fields=<field>[,<field>...] returns only those fields of each node
+	var selector bson.M
+	if _, ok := query["fields"]; ok {
+		if selector, err = dbquery.Projection(query.Get("fields")); err != nil {
+			return responder.RespondWithError(ctx, http.StatusBadRequest, "Invalid fields: "+err.Error())
+		}
+	}
+
+	if _, ok := query["cursor"]; ok {
+		return readManyAfter(ctx, q, selector, limit, order, query.Get("cursor"))
+	}
+
+	if selector != nil {
+		docs, count, err := node.GetFields(q, selector, limit, offset, order...)
+		if err != nil {
+			err_msg := "err " + err.Error()
+			logger.Error(err_msg)
+			return responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
+		}
+		return responder.RespondWithPaginatedData(ctx, docs, limit, offset, count)
+	}
+
 	// Get nodes from db
-	count, err := nodes.GetPaginated(q, limit, offset)
+	count, err := nodes.GetPaginated(q, limit, offset, order...)
 	if err != nil {
 		err_msg := "err " + err.Error()
 		logger.Error(err_msg)
@@ -86,3 +161,47 @@ func (cr *NodeController) ReadMany(ctx context.Context) error {
 	}
 	return responder.RespondWithPaginatedData(ctx, nodes, limit, offset, count)
 }
+
+// readManyAfter responds with the page following the cursor token, the
+// first page if it is empty. Pages are sorted by order, by id if unset.
+func readManyAfter(ctx context.Context, q bson.M, selector bson.M, limit int, order []string, token string) (err error) {
+	var c *dbquery.Cursor
+	if token == "" {
+		if len(order) == 0 {
+			order = []string{"id"}
+		}
+		c = &dbquery.Cursor{Order: order}
+		if c.Count, err = node.Count(q); err != nil {
+			err_msg := "err " + err.Error()
+			logger.Error(err_msg)
+			return responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
+		}
+	} else {
+		if c, err = dbquery.ParseCursor(token); err != nil {
+			return responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+		}
+		if len(order) > 0 && strings.Join(order, ",") != strings.Join(c.Order, ",") {
+			return responder.RespondWithError(ctx, http.StatusBadRequest, "cursor does not match order")
+		}
+	}
+
+	var data interface{}
+	var next *dbquery.Cursor
+	if selector != nil {
+		data, next, err = node.GetFieldsAfter(q, selector, limit, c)
+	} else {
+		nodes := node.Nodes{}
+		next, err = nodes.GetAfter(q, limit, c)
+		data = nodes
+	}
+	if err != nil {
+		err_msg := "err " + err.Error()
+		logger.Error(err_msg)
+		return responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
+	}
+	token = ""
+	if next != nil {
+		token = next.String()
+	}
+	return responder.RespondWithCursorData(ctx, data, limit, c.Count, token)
+}
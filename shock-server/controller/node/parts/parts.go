@@ -0,0 +1,157 @@
+// Package parts implements /node/:id/parts resource
+package parts
+
+import (
+	"fmt"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/logger"
+	"github.com/MG-RAST/Shock/shock-server/node"
+	"github.com/MG-RAST/Shock/shock-server/quota"
+	"github.com/MG-RAST/Shock/shock-server/request"
+	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"github.com/stretchr/goweb/context"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// GET: /node/{nid}/parts/
+// Returns the state of the partial upload session of a node.
+func PartsRequest(ctx context.Context) {
+	_, n, ok := loadNode(ctx)
+	if !ok {
+		return
+	}
+	if ctx.HttpRequest().Method != "GET" {
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	}
+	respondWithStatus(ctx, n)
+	return
+}
+
+// PUT: /node/{nid}/parts/{part} -> multipart-form, upload or replace a part
+// PUT: /node/{nid}/parts/close, finalize the upload
+// Changes require an authenticated user with write rights on the node.
+func PartsTypedRequest(ctx context.Context) {
+	part := ctx.PathValue("part")
+	u, n, ok := loadNode(ctx)
+	if !ok {
+		return
+	}
+
+	switch ctx.HttpRequest().Method {
+	case "GET":
+		respondWithStatus(ctx, n)
+	case "PUT", "POST":
+		if u.Uuid == "" {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.NoAuth)
+			return
+		}
+		if err := n.CheckRight(u, "write"); err != nil {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if part == "close" {
+			if err := n.CloseParts(); err != nil {
+				responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+				return
+			}
+			responder.RespondWithData(ctx, n)
+			return
+		}
+
+		pn, err := strconv.Atoi(part)
+		if err != nil || pn < 1 {
+			responder.RespondWithError(ctx, http.StatusBadRequest, "part must be a positive integer or close")
+			return
+		}
+		params, files, err := request.ParseMultipartForm(ctx.HttpRequest())
+		if err != nil {
+			err_msg := "err@parts_ParseMultipartForm: " + err.Error()
+			logger.Error(err_msg)
+			responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
+			return
+		}
+		if len(files) != 1 {
+			removeFiles(files)
+			responder.RespondWithError(ctx, http.StatusBadRequest, "part upload requires exactly one file")
+			return
+		}
+		for _, f := range files {
+			// reject corrupted transfers, client retries the part
+			if md5, has := params["md5"]; has && md5 != f.Checksum["md5"] {
+				os.Remove(f.Path)
+				responder.RespondWithError(ctx, http.StatusBadRequest, fmt.Sprintf("md5 mismatch for part %d: received %s", pn, f.Checksum["md5"]))
+				return
+			}
+			if err := n.ReplacePart(pn, &f); err != nil {
+				os.Remove(f.Path)
+				if qerr, ok := err.(*quota.ExceededError); ok {
+					responder.RespondWithErrorData(ctx, http.StatusForbidden, []string{qerr.Error()}, qerr.Usage)
+					return
+				}
+				responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if n.HasFile() {
+			responder.RespondWithData(ctx, n)
+			return
+		}
+		respondWithStatus(ctx, n)
+	default:
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+	}
+	return
+}
+
+func respondWithStatus(ctx context.Context, n *node.Node) {
+	if s, err := n.PartsStatus(); err != nil {
+		responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+	} else {
+		responder.RespondWithData(ctx, s)
+	}
+}
+
+// loadNode returns the user of the request, the public user if it is not
+// authenticated, and the node it can read
+func loadNode(ctx context.Context) (u *user.User, n *node.Node, ok bool) {
+	nid := ctx.PathValue("nid")
+
+	u, err := request.Authenticate(ctx.HttpRequest())
+	if err != nil && err.Error() != e.NoAuth {
+		request.AuthError(err, ctx)
+		return nil, nil, false
+	}
+
+	// Fake public user
+	if u == nil {
+		u = &user.User{Uuid: ""}
+	}
+
+	// Load node and handle user unauthorized
+	n, err = node.Load(nid, u.Ids()...)
+	if err != nil {
+		if err.Error() == e.UnAuth {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		} else if err.Error() == e.MongoDocNotFound {
+			responder.RespondWithError(ctx, http.StatusNotFound, "Node not found")
+		} else {
+			// In theory the db connection could be lost between
+			// checking user and load but seems unlikely.
+			err_msg := "Err@parts:LoadNode: " + err.Error()
+			logger.Error(err_msg)
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+		}
+		return nil, nil, false
+	}
+	return u, n, true
+}
+
+func removeFiles(files node.FormFiles) {
+	for _, f := range files {
+		os.Remove(f.Path)
+	}
+}
@@ -0,0 +1,93 @@
+// Package revisions implements /node/:id/revisions resource
+package revisions
+
+import (
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/logger"
+	"github.com/MG-RAST/Shock/shock-server/node"
+	"github.com/MG-RAST/Shock/shock-server/request"
+	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"github.com/stretchr/goweb/context"
+	"net/http"
+)
+
+// GET: /node/{nid}/revisions
+// Returns the revision history of a node, or with ?from=<version>[&to=<version>]
+// the changes between two versions.
+func RevisionsRequest(ctx context.Context) {
+	if ctx.HttpRequest().Method != "GET" {
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	}
+	nid := ctx.PathValue("nid")
+
+	u, err := request.Authenticate(ctx.HttpRequest())
+	if err != nil && err.Error() != e.NoAuth {
+		request.AuthError(err, ctx)
+		return
+	}
+
+	// Fake public user
+	if u == nil {
+		if !conf.Bool(conf.Conf["anon-read"]) {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.NoAuth)
+			return
+		}
+		u = &user.User{Uuid: ""}
+	}
+
+	// Load node and handle user unauthorized
+	n, err := node.Load(nid, u.Ids()...)
+	if err != nil {
+		if err.Error() == e.UnAuth {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		} else if err.Error() == e.MongoDocNotFound {
+			responder.RespondWithError(ctx, http.StatusNotFound, "Node not found")
+		} else {
+			err_msg := "Err@revisions:LoadNode: " + err.Error()
+			logger.Error(err_msg)
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+		}
+		return
+	}
+
+	query := ctx.HttpRequest().URL.Query()
+	if from := query.Get("from"); from != "" {
+		to := query.Get("to")
+		if to == "" {
+			to = n.Version
+		}
+		changes, err := n.DiffRevisions(from, to, ShowAcl(u, n))
+		if err != nil {
+			if err.Error() == e.MongoDocNotFound {
+				responder.RespondWithError(ctx, http.StatusNotFound, "Revision not found")
+			} else {
+				responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		responder.RespondWithData(ctx, changes)
+		return
+	}
+
+	history, err := n.History(ShowAcl(u, n))
+	if err != nil {
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	responder.RespondWithData(ctx, history)
+}
+
+// ShowAcl returns true if u may view the acls of n, as with /node/{nid}/acl
+func ShowAcl(u *user.User, n *node.Node) bool {
+	if u.Uuid == "" {
+		return false
+	}
+	if u.Admin || u.Uuid == n.Acl.Owner {
+		return true
+	}
+	rights, err := n.Rights(u.Ids()...)
+	return err == nil && rights["read"]
+}
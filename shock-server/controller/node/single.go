@@ -2,10 +2,12 @@ package node
 
 import (
 	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/controller/node/revisions"
 	e "github.com/MG-RAST/Shock/shock-server/errors"
 	"github.com/MG-RAST/Shock/shock-server/logger"
 	"github.com/MG-RAST/Shock/shock-server/node"
 	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"github.com/MG-RAST/Shock/shock-server/node/file/index"
 	"github.com/MG-RAST/Shock/shock-server/node/filter"
 	"github.com/MG-RAST/Shock/shock-server/preauth"
 	"github.com/MG-RAST/Shock/shock-server/request"
@@ -15,7 +17,9 @@ import (
 	"github.com/stretchr/goweb/context"
 	"io"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -46,7 +50,7 @@ func (cr *NodeController) Read(id string, ctx context.Context) error {
 	}
 
 	// Load node and handle user unauthorized
-	n, err := node.Load(id, u.Uuid)
+	n, err := node.Load(id, u.Ids()...)
 	if err != nil {
 		if err.Error() == e.UnAuth {
 			return responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
@@ -66,6 +70,21 @@ func (cr *NodeController) Read(id string, ctx context.Context) error {
 		}
 	}
 
+	// ?version=<hash> returns the node metadata at that version
+	if version := query.Get("version"); version != "" {
+		if _, ok := query["download"]; ok {
+			return responder.RespondWithError(ctx, http.StatusBadRequest, "version can not be combined with download")
+		}
+		r, err := n.RevisionView(version, revisions.ShowAcl(u, n))
+		if err != nil {
+			if err.Error() == e.MongoDocNotFound {
+				return responder.RespondWithError(ctx, http.StatusNotFound, "Revision not found")
+			}
+			return responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+		}
+		return responder.RespondWithData(ctx, r)
+	}
+
 	// Switch though param flags
 	// ?download=1
 	if _, ok := query["download"]; ok {
@@ -76,29 +95,126 @@ func (cr *NodeController) Read(id string, ctx context.Context) error {
 		if _, ok := query["filename"]; ok {
 			filename = query.Get("filename")
 		}
+		compression := query.Get("compression")
+		if compression != "" && !request.IsValidCompression(compression) {
+			return responder.RespondWithError(ctx, http.StatusBadRequest, "Invalid compression, must be one of: "+strings.Join(request.Compressions(), ", "))
+		}
 
 		if _, ok := query["index"]; ok {
-			//handling bam file
+			//handling bam file, alignments are returned as sam text
 			if query.Get("index") == "bai" {
-				s := &request.Streamer{R: []file.SectionReader{}, W: ctx.HttpResponseWriter(), ContentType: "application/octet-stream", Filename: filename, Size: n.File.Size, Filter: fFunc}
-
-				var region string
-
-				if _, ok := query["region"]; ok {
-					//retrieve alingments overlapped with specified region
-					region = query.Get("region")
+				v, err := request.ParseBamView(ctx)
+				if err != nil {
+					return responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+				}
+				var idx *index.BamIndex
+				if v.Region != "" {
+					if idx, err = loadBamIndex(n); err != nil {
+						return responder.RespondWithError(ctx, http.StatusBadRequest, "Region queries require a bai index, create it with ?index=bai")
+					}
+				}
+				r, ok := openFile(ctx, n)
+				if !ok {
+					return nil
 				}
+				defer r.Close()
+				s := &request.Streamer{W: ctx.HttpResponseWriter(), ContentType: "text/plain", Filename: filename + ".sam", Compression: compression}
+				if err = s.StreamBam(io.NewSectionReader(r, 0, n.File.Size), idx, v); err != nil {
+					return responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+				}
+				return nil
+			}
 
-				argv, err := request.ParseSamtoolsArgs(ctx)
+			//handling indexed text files, records overlapping the region are returned
+			if query.Get("index") == "tabix" {
+				region := query.Get("region")
+				if region == "" {
+					return responder.RespondWithError(ctx, http.StatusBadRequest, "Index tabix requires region parameter")
+				}
+				idx, err := index.LoadTabixIndex(n.IndexPath() + "/tabix.idx")
 				if err != nil {
-					return responder.RespondWithError(ctx, http.StatusBadRequest, "Invaid args in query url")
+					return responder.RespondWithError(ctx, http.StatusBadRequest, "Region queries require a tabix index, create it with ?index=tabix")
 				}
+				r, ok := openFile(ctx, n)
+				if !ok {
+					return nil
+				}
+				defer r.Close()
+				_, head := query["head"]
+				s := &request.Streamer{W: ctx.HttpResponseWriter(), ContentType: "text/plain", Filename: filename, Compression: compression}
+				if err = s.StreamTabix(io.NewSectionReader(r, 0, n.File.Size), idx, region, head); err != nil {
+					return responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+				}
+				return nil
+			}
 
-				err = s.StreamSamtools(n.FilePath(), region, argv...)
+			//handling fasta files, the bases of the regions are returned
+			if query.Get("index") == "fai" {
+				if _, ok := query["region"]; !ok {
+					return responder.RespondWithError(ctx, http.StatusBadRequest, "Index fai requires region parameter")
+				}
+				idx, err := index.LoadFaiIndex(n.IndexPath() + "/fai.idx")
 				if err != nil {
-					return responder.RespondWithError(ctx, http.StatusBadRequest, "error while involking samtools")
+					return responder.RespondWithError(ctx, http.StatusBadRequest, "Region queries require a fai index, create it with ?index=fai")
+				}
+				r, ok := openFile(ctx, n)
+				if !ok {
+					return nil
 				}
+				defer r.Close()
+				_, revcomp := query["revcomp"]
+				s := &request.Streamer{W: ctx.HttpResponseWriter(), ContentType: "text/plain", Filename: filename, Compression: compression}
+				if err = s.StreamFai(io.NewSectionReader(r, 0, n.File.Size), idx, query["region"], revcomp); err != nil {
+					return responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+				}
+				return nil
+			}
 
+			//handling lookups by record id, the records are returned in the order of the ids
+			if query.Get("index") == "name" {
+				ids := []string{}
+				for _, v := range query["id"] {
+					for _, id := range strings.Split(v, ",") {
+						if id != "" {
+							ids = append(ids, id)
+						}
+					}
+				}
+				if len(ids) == 0 {
+					return responder.RespondWithError(ctx, http.StatusBadRequest, "Index name requires id parameter")
+				}
+				idx, err := index.OpenNameIndex(n.IndexPath() + "/name.idx")
+				if err != nil {
+					return responder.RespondWithError(ctx, http.StatusBadRequest, "Id lookups require a name index, create it with ?index=name")
+				}
+				defer idx.Close()
+				r, ok := openFile(ctx, n)
+				if !ok {
+					return nil
+				}
+				defer r.Close()
+				var size int64 = 0
+				s := &request.Streamer{R: []file.SectionReader{}, W: ctx.HttpResponseWriter(), ContentType: "application/octet-stream", Filename: filename, Filter: fFunc, Compression: compression}
+				for _, id := range ids {
+					recs, err := idx.Lookup(id)
+					if err != nil {
+						err_msg := "err:@node_Read name index: " + err.Error()
+						logger.Error(err_msg)
+						return responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+					} else if len(recs) == 0 {
+						return responder.RespondWithError(ctx, http.StatusNotFound, "Unknown id: "+id)
+					}
+					for _, rec := range recs {
+						size += rec[1]
+						s.R = append(s.R, io.NewSectionReader(r, rec[0], rec[1]))
+					}
+				}
+				s.Size = size
+				if err = streamRanged(ctx, n, s); err != nil {
+					err_msg := "err:@node_Read s.stream: " + err.Error()
+					logger.Error(err_msg)
+					responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
+				}
 				return nil
 			}
 
@@ -106,14 +222,11 @@ func (cr *NodeController) Read(id string, ctx context.Context) error {
 			if _, ok := query["part"]; !ok {
 				return responder.RespondWithError(ctx, http.StatusBadRequest, "Index parameter requires part parameter")
 			}
-			// open file
-			r, err := n.FileReader()
-			defer r.Close()
-			if err != nil {
-				err_msg := "Err@node_Read:Open: " + err.Error()
-				logger.Error(err_msg)
-				return responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+			r, ok := openFile(ctx, n)
+			if !ok {
+				return nil
 			}
+			defer r.Close()
 			// load index
 			idx, err := n.Index(query.Get("index"))
 			if err != nil {
@@ -131,17 +244,25 @@ func (cr *NodeController) Read(id string, ctx context.Context) error {
 				idx.Set(map[string]interface{}{"ChunkSize": csize})
 			}
 			var size int64 = 0
-			s := &request.Streamer{R: []file.SectionReader{}, W: ctx.HttpResponseWriter(), ContentType: "application/octet-stream", Filename: filename, Filter: fFunc}
+			s := &request.Streamer{R: []file.SectionReader{}, W: ctx.HttpResponseWriter(), ContentType: "application/octet-stream", Filename: filename, Filter: fFunc, Compression: compression}
 			for _, p := range query["part"] {
-				pos, length, err := idx.Part(p)
+				var ranges [][]int64
+				if ri, ok := idx.(index.RangeIndex); ok {
+					ranges, err = ri.Ranges(p)
+				} else {
+					pos, length, er := idx.Part(p)
+					ranges, err = [][]int64{{pos, length}}, er
+				}
 				if err != nil {
 					return responder.RespondWithError(ctx, http.StatusBadRequest, "Invalid index part")
 				}
-				size += length
-				s.R = append(s.R, io.NewSectionReader(r, pos, length))
+				for _, rg := range ranges {
+					size += rg[1]
+					s.R = append(s.R, io.NewSectionReader(r, rg[0], rg[1]))
+				}
 			}
 			s.Size = size
-			err = s.Stream()
+			err = streamRanged(ctx, n, s)
 			if err != nil {
 				// causes "multiple response.WriteHeader calls" error but better than no response
 				err_msg := "err:@node_Read s.stream: " + err.Error()
@@ -150,7 +271,6 @@ func (cr *NodeController) Read(id string, ctx context.Context) error {
 			}
 		} else {
 			nf, err := n.FileReader()
-			defer nf.Close()
 			if err != nil {
 				// File not found or some sort of file read error.
 				// Probably deserves more checking
@@ -158,8 +278,9 @@ func (cr *NodeController) Read(id string, ctx context.Context) error {
 				logger.Error(err_msg)
 				return responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
 			}
-			s := &request.Streamer{R: []file.SectionReader{nf}, W: ctx.HttpResponseWriter(), ContentType: "application/octet-stream", Filename: filename, Size: n.File.Size, Filter: fFunc}
-			err = s.Stream()
+			defer nf.Close()
+			s := &request.Streamer{R: []file.SectionReader{nf}, W: ctx.HttpResponseWriter(), ContentType: "application/octet-stream", Filename: filename, Size: n.File.Size, Filter: fFunc, Compression: compression}
+			err = streamRanged(ctx, n, s)
 			if err != nil {
 				// causes "multiple response.WriteHeader calls" error but better than no response
 				err_msg := "err:@node_Read: s.stream: " + err.Error()
@@ -177,6 +298,12 @@ func (cr *NodeController) Read(id string, ctx context.Context) error {
 			if _, ok := query["filename"]; ok {
 				options["filename"] = query.Get("filename")
 			}
+			if c := query.Get("compression"); c != "" {
+				if !request.IsValidCompression(c) {
+					return responder.RespondWithError(ctx, http.StatusBadRequest, "Invalid compression, must be one of: "+strings.Join(request.Compressions(), ", "))
+				}
+				options["compression"] = c
+			}
 			if p, err := preauth.New(util.RandString(20), "download", n.Id, options); err != nil {
 				err_msg := "err:@node_Read download_url: " + err.Error()
 				logger.Error(err_msg)
@@ -187,7 +314,53 @@ func (cr *NodeController) Read(id string, ctx context.Context) error {
 		}
 	} else {
 		// Base case respond with node in json
+		setETag(ctx, n)
 		responder.RespondWithData(ctx, n)
 	}
 	return nil
 }
+
+// streamRanged streams s honoring Range and If-Range request headers, the
+// ETag and Last-Modified validators of If-Range are sent with the file.
+// Filtered or compressed output has no stable byte offsets so ranges are only offered without either.
+func streamRanged(ctx context.Context, n *node.Node, s *request.Streamer) error {
+	if s.Filter != nil || s.Compression != "" {
+		return s.Stream()
+	}
+	etag := "\"" + n.Version + "\""
+	s.W.Header().Set("Accept-Ranges", "bytes")
+	s.W.Header().Set("ETag", etag)
+	modified := n.LastModified
+	if modified == "-" {
+		modified = n.CreatedOn
+	}
+	if t, err := time.Parse(time.UnixDate, modified); err == nil {
+		s.W.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+	if rh := request.RangeHeader(ctx.HttpRequest(), etag, modified); rh != "" {
+		return s.StreamRange(rh)
+	}
+	return s.Stream()
+}
+
+// openFile opens the file of n. If it can not be opened an error response
+// is written and ok is false.
+func openFile(ctx context.Context, n *node.Node) (r file.ReaderAt, ok bool) {
+	r, err := n.FileReader()
+	if err != nil {
+		err_msg := "Err@node_Read:Open: " + err.Error()
+		logger.Error(err_msg)
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+		return nil, false
+	}
+	return r, true
+}
+
+// loadBamIndex loads the bai index of the node, created by ?index=bai or
+// by earlier versions of Shock with samtools
+func loadBamIndex(n *node.Node) (idx *index.BamIndex, err error) {
+	if idx, err = index.LoadBamIndex(n.IndexPath() + "/bai.idx"); err != nil {
+		idx, err = index.LoadBamIndex(n.IndexPath() + "/" + filepath.Base(n.FilePath()) + ".bai")
+	}
+	return
+}
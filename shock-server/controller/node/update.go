@@ -6,12 +6,19 @@ import (
 	"github.com/MG-RAST/Shock/shock-server/logger"
 	"github.com/MG-RAST/Shock/shock-server/node"
 	"github.com/MG-RAST/Shock/shock-server/node/file/index"
+	"github.com/MG-RAST/Shock/shock-server/node/patch"
+	"github.com/MG-RAST/Shock/shock-server/quota"
 	"github.com/MG-RAST/Shock/shock-server/request"
 	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/schema"
 	"github.com/MG-RAST/Shock/shock-server/user"
 	"github.com/stretchr/goweb/context"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"os"
+	"strings"
 )
 
 // PUT: /node/{id} -> multipart-form
@@ -29,7 +36,7 @@ func (cr *NodeController) Replace(id string, ctx context.Context) error {
 		u = &user.User{Uuid: ""}
 	}
 
-	n, err := node.Load(id, u.Uuid)
+	n, err := node.Load(id, u.Ids()...)
 	if err != nil {
 		if err.Error() == e.UnAuth {
 			return responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
@@ -44,7 +51,17 @@ func (cr *NodeController) Replace(id string, ctx context.Context) error {
 		}
 	}
 
-	if _, ok := query["index"]; ok {
+	if _, ok := query["store"]; ok {
+		if !u.Admin {
+			return responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		}
+		if err := n.SetStore(query.Get("store")); err != nil {
+			err_msg := "err@node_SetStore: " + id + ":" + err.Error()
+			logger.Error(err_msg)
+			return responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
+		}
+		return responder.RespondWithData(ctx, n)
+	} else if _, ok := query["index"]; ok {
 		if conf.Bool(conf.Conf["perf-log"]) {
 			logger.Perf("START indexing: " + id)
 		}
@@ -53,16 +70,8 @@ func (cr *NodeController) Replace(id string, ctx context.Context) error {
 			return responder.RespondWithError(ctx, http.StatusBadRequest, "node file empty")
 		}
 
-		if query.Get("index") == "bai" {
-			//bam index is created by the command-line tool samtools
-			if ext := n.FileExt(); ext == ".bam" {
-				if err := index.CreateBamIndex(n.FilePath()); err != nil {
-					return responder.RespondWithError(ctx, http.StatusBadRequest, "Error while creating bam index")
-				}
-				return nil
-			} else {
-				return responder.RespondWithError(ctx, http.StatusBadRequest, "Index type bai requires .bam file")
-			}
+		if query.Get("index") == "bai" && n.FileExt() != ".bam" {
+			return responder.RespondWithError(ctx, http.StatusBadRequest, "Index type bai requires .bam file")
 		}
 
 		idxtype := ctx.QueryValue("index")
@@ -71,7 +80,11 @@ func (cr *NodeController) Replace(id string, ctx context.Context) error {
 		}
 
 		newIndexer := index.Indexers[idxtype]
-		f, _ := os.Open(n.FilePath())
+		f, err := n.LocalFile()
+		if err != nil {
+			logger.Error("err " + err.Error())
+			return responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+		}
 		defer f.Close()
 		idxer := newIndexer(f)
 
@@ -82,9 +95,11 @@ func (cr *NodeController) Replace(id string, ctx context.Context) error {
 		}
 
 		idxInfo := node.IdxInfo{
-			Type:        ctx.QueryValue("index"),
-			TotalUnits:  count,
-			AvgUnitSize: n.File.Size / count,
+			Type:       ctx.QueryValue("index"),
+			TotalUnits: count,
+		}
+		if count > 0 {
+			idxInfo.AvgUnitSize = n.File.Size / count
 		}
 
 		if idxtype == "chunkrecord" {
@@ -102,6 +117,19 @@ func (cr *NodeController) Replace(id string, ctx context.Context) error {
 		return responder.RespondOK(ctx)
 
 	} else {
+		// the node was loaded with read rights, changing it requires write
+		if err := n.CheckRight(u, "write"); err != nil {
+			return responder.RespondWithError(ctx, http.StatusUnauthorized, err.Error())
+		}
+		version, ok := ifMatch(ctx.HttpRequest(), n)
+		if !ok {
+			return responder.RespondWithError(ctx, http.StatusPreconditionFailed, e.VersionMismatch)
+		}
+		mediaType, _, _ := mime.ParseMediaType(ctx.HttpRequest().Header.Get("Content-Type"))
+		if mediaType == patch.MergeType || mediaType == patch.PatchType {
+			return patchAttributes(ctx, n, mediaType, version)
+		}
+
 		if conf.Bool(conf.Conf["perf-log"]) {
 			logger.Perf("START PUT data: " + id)
 		}
@@ -112,8 +140,14 @@ func (cr *NodeController) Replace(id string, ctx context.Context) error {
 			return responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
 		}
 
-		err = n.Update(params, files)
-		if err != nil {
+		err = n.Update(params, files, u)
+		if verr, ok := err.(*schema.ValidationError); ok {
+			return respondWithValidationError(ctx, verr)
+		} else if qerr, ok := err.(*quota.ExceededError); ok {
+			return respondWithQuotaError(ctx, qerr)
+		} else if err != nil && err.Error() == e.TokenScope {
+			return responder.RespondWithError(ctx, http.StatusUnauthorized, e.TokenScope)
+		} else if err != nil {
 			errors := []string{e.FileImut, e.AttrImut, "parts cannot be less than 1"}
 			for e := range errors {
 				if err.Error() == errors[e] {
@@ -124,6 +158,7 @@ func (cr *NodeController) Replace(id string, ctx context.Context) error {
 			logger.Error(err_msg)
 			return responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
 		}
+		setETag(ctx, n)
 		responder.RespondWithData(ctx, n)
 		if conf.Bool(conf.Conf["perf-log"]) {
 			logger.Perf("END PUT data: " + id)
@@ -131,3 +166,115 @@ func (cr *NodeController) Replace(id string, ctx context.Context) error {
 	}
 	return nil
 }
+
+// largest accepted attributes patch document
+const maxPatchSize = 16 * 1024 * 1024
+
+// patchAttributes applies the request body as a patch to the node attributes
+func patchAttributes(ctx context.Context, n *node.Node, mediaType string, version string) error {
+	body, err := ioutil.ReadAll(io.LimitReader(ctx.HttpRequest().Body, maxPatchSize+1))
+	if err != nil {
+		return responder.RespondWithError(ctx, http.StatusBadRequest, "err@node_Patch: "+err.Error())
+	}
+	if len(body) > maxPatchSize {
+		return responder.RespondWithError(ctx, http.StatusRequestEntityTooLarge, "patch document too large")
+	}
+	if err = n.PatchAttributes(mediaType, body, version); err != nil {
+		if verr, ok := err.(*schema.ValidationError); ok {
+			return respondWithValidationError(ctx, verr)
+		} else if err.Error() == e.VersionMismatch {
+			return responder.RespondWithError(ctx, http.StatusPreconditionFailed, e.VersionMismatch)
+		}
+		return responder.RespondWithError(ctx, http.StatusBadRequest, "err@node_Patch: "+n.Id+":"+err.Error())
+	}
+	setETag(ctx, n)
+	return responder.RespondWithData(ctx, n)
+}
+
+// respondWithValidationError lists every schema violation as an error
+// message and returns them structured as data
+func respondWithValidationError(ctx context.Context, verr *schema.ValidationError) error {
+	msgs := []string{}
+	for _, e := range verr.Errors {
+		msgs = append(msgs, e.String())
+	}
+	return responder.RespondWithErrorData(ctx, http.StatusBadRequest, msgs, verr.Errors)
+}
+
+// respondWithQuotaError refuses data that would exceed a storage quota,
+// returning the usage of the quota holder as data
+func respondWithQuotaError(ctx context.Context, qerr *quota.ExceededError) error {
+	return responder.RespondWithErrorData(ctx, http.StatusForbidden, []string{qerr.Error()}, qerr.Usage)
+}
+
+// ifMatch checks an If-Match header against the node version. It returns
+// the version the update requires, empty if there is no header or it is *.
+func ifMatch(r *http.Request, n *node.Node) (version string, ok bool) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return "", true
+	}
+	for _, tag := range strings.Split(h, ",") {
+		if strings.Trim(strings.TrimSpace(tag), "\"") == n.Version {
+			return n.Version, true
+		}
+	}
+	return "", false
+}
+
+// setETag sets the ETag response header to the node version
+func setETag(ctx context.Context, n *node.Node) {
+	ctx.HttpResponseWriter().Header().Set("ETag", "\""+n.Version+"\"")
+}
+
+// params that can be applied to many nodes at once
+var bulkUpdateParams = map[string]bool{"tags": true, "linkage": true, "ids": true, "operation": true, "format": true}
+
+// PUT: /node?query&<key>=<value>[&dryrun] -> multipart-form of tags, linkage or format
+func (cr *NodeController) UpdateMany(ctx context.Context) error {
+	u, nodes, err := bulkNodes(ctx)
+	if u == nil {
+		return err
+	}
+	_, dryRun := ctx.HttpRequest().URL.Query()["dryrun"]
+
+	params, files, err := request.ParseMultipartForm(ctx.HttpRequest())
+	if err != nil {
+		err_msg := "err@node_ParseMultipartForm: " + err.Error()
+		logger.Error(err_msg)
+		return responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
+	}
+	if len(files) > 0 {
+		for _, f := range files {
+			os.Remove(f.Path)
+		}
+		return responder.RespondWithError(ctx, http.StatusBadRequest, "bulk updates do not accept files")
+	}
+	if len(params) == 0 {
+		return responder.RespondWithError(ctx, http.StatusBadRequest, "bulk update requires tags, linkage or format")
+	}
+	for key := range params {
+		if !bulkUpdateParams[key] {
+			return responder.RespondWithError(ctx, http.StatusBadRequest, "parameter not supported in bulk updates: "+key)
+		}
+	}
+
+	return responder.RespondWithData(ctx, bulkUpdate(u, nodes, params, dryRun))
+}
+
+// updateNode is replaced in tests
+var updateNode = (*node.Node).Update
+
+// bulkUpdate applies params to the nodes u may write, or only checks that
+// they can be written if dryRun is set
+func bulkUpdate(u *user.User, nodes node.Nodes, params map[string]string, dryRun bool) bulkResponse {
+	res := bulkResponse{DryRun: dryRun, Matched: len(nodes), Results: []bulkResult{}}
+	for _, n := range nodes {
+		err := n.CheckRight(u, "write")
+		if err == nil && !dryRun {
+			err = updateNode(n, params, node.FormFiles{}, u)
+		}
+		res.add(n.Id, err)
+	}
+	return res
+}
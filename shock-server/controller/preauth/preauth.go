@@ -9,6 +9,7 @@ import (
 	"github.com/MG-RAST/Shock/shock-server/request"
 	"github.com/MG-RAST/Shock/shock-server/responder"
 	"github.com/stretchr/goweb/context"
+	"strings"
 )
 
 func PreAuthRequest(ctx context.Context) {
@@ -26,7 +27,15 @@ func PreAuthRequest(ctx context.Context) {
 				if fn, has := p.Options["filename"]; has {
 					filename = fn
 				}
-				streamDownload(ctx, n, filename)
+				compression := p.Options["compression"]
+				if c := ctx.HttpRequest().URL.Query().Get("compression"); c != "" {
+					compression = c
+				}
+				if compression != "" && !request.IsValidCompression(compression) {
+					responder.RespondWithError(ctx, 400, "Invalid compression, must be one of: "+strings.Join(request.Compressions(), ", "))
+					return
+				}
+				streamDownload(ctx, n, filename, compression)
 				preauth.Delete(id)
 				return
 			default:
@@ -41,9 +50,8 @@ func PreAuthRequest(ctx context.Context) {
 	return
 }
 
-func streamDownload(ctx context.Context, n *node.Node, filename string) {
+func streamDownload(ctx context.Context, n *node.Node, filename string, compression string) {
 	nf, err := n.FileReader()
-	defer nf.Close()
 	if err != nil {
 		// File not found or some sort of file read error.
 		// Probably deserves more checking
@@ -52,7 +60,8 @@ func streamDownload(ctx context.Context, n *node.Node, filename string) {
 		responder.RespondWithError(ctx, 500, err_msg)
 		return
 	}
-	s := &request.Streamer{R: []file.SectionReader{nf}, W: ctx.HttpResponseWriter(), ContentType: "application/octet-stream", Filename: filename, Size: n.File.Size, Filter: nil}
+	defer nf.Close()
+	s := &request.Streamer{R: []file.SectionReader{nf}, W: ctx.HttpResponseWriter(), ContentType: "application/octet-stream", Filename: filename, Size: n.File.Size, Filter: nil, Compression: compression}
 	err = s.Stream()
 	if err != nil {
 		// causes "multiple response.WriteHeader calls" error but better than no response
@@ -0,0 +1,103 @@
+// Package schema implements /schema resource
+package schema
+
+import (
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/logger"
+	"github.com/MG-RAST/Shock/shock-server/request"
+	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/schema"
+	"github.com/stretchr/goweb/context"
+	"io"
+	"io/ioutil"
+	"labix.org/v2/mgo"
+	"net/http"
+)
+
+// largest accepted schema document
+const maxSchemaSize = 1024 * 1024
+
+// GET: /schema
+// Lists the registered attribute schemas.
+func SchemaRequest(ctx context.Context) {
+	if ctx.HttpRequest().Method != "GET" {
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	}
+	entries, err := schema.List()
+	if err != nil {
+		err_msg := "err@schema_List: " + err.Error()
+		logger.Error(err_msg)
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+		return
+	}
+	responder.RespondWithData(ctx, entries)
+}
+
+// GET, PUT, DELETE: /schema/{kind}/{name}
+// Anyone can view a schema, only admins can register or remove them.
+func SchemaTypedRequest(ctx context.Context) {
+	kind := ctx.PathValue("kind")
+	name := ctx.PathValue("name")
+	if !schema.IsValidKind(kind) {
+		responder.RespondWithError(ctx, http.StatusBadRequest, "schema kind must be tag or type")
+		return
+	}
+
+	method := ctx.HttpRequest().Method
+	if method == "GET" {
+		entry, err := schema.Get(kind, name)
+		if err == mgo.ErrNotFound {
+			responder.RespondWithError(ctx, http.StatusNotFound, "Schema not found")
+		} else if err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+		} else {
+			responder.RespondWithData(ctx, entry)
+		}
+		return
+	}
+
+	u, err := request.Authenticate(ctx.HttpRequest())
+	if err != nil && err.Error() != e.NoAuth {
+		request.AuthError(err, ctx)
+		return
+	}
+	if u == nil {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.NoAuth)
+		return
+	} else if !u.Admin {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		return
+	}
+
+	switch method {
+	case "PUT", "POST":
+		raw, err := ioutil.ReadAll(io.LimitReader(ctx.HttpRequest().Body, maxSchemaSize+1))
+		if err != nil {
+			responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(raw) > maxSchemaSize {
+			responder.RespondWithError(ctx, http.StatusRequestEntityTooLarge, "schema too large")
+			return
+		}
+		entry, err := schema.Put(kind, name, raw)
+		if err != nil {
+			responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		logger.Info("access", "schema "+kind+":"+name+" registered by "+u.Username)
+		responder.RespondWithData(ctx, entry)
+	case "DELETE":
+		if err := schema.Delete(kind, name); err == mgo.ErrNotFound {
+			responder.RespondWithError(ctx, http.StatusNotFound, "Schema not found")
+		} else if err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+		} else {
+			logger.Info("access", "schema "+kind+":"+name+" removed by "+u.Username)
+			responder.RespondOK(ctx)
+		}
+	default:
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+	}
+}
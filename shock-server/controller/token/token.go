@@ -0,0 +1,127 @@
+// Package token implements /token resource
+package token
+
+import (
+	"github.com/MG-RAST/Shock/shock-server/auth/token"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/logger"
+	"github.com/MG-RAST/Shock/shock-server/request"
+	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/stretchr/goweb/context"
+	"labix.org/v2/mgo"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDays = 30
+	maxDays     = 365
+)
+
+// GET, POST: /token
+// GET lists the tokens of the user. POST issues a token with
+// ?name=<name>&scope=read|write&nodes=<ids>&collections=<ids>&expires_in=<days>,
+// the secret is only returned in this response.
+func TokenRequest(ctx context.Context) {
+	u := request.RequireAuth(ctx)
+	if u == nil {
+		return
+	}
+
+	switch ctx.HttpRequest().Method {
+	case "GET":
+		ts, err := token.List(u.Uuid)
+		if err != nil {
+			err_msg := "err@token_List: " + err.Error()
+			logger.Error(err_msg)
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+			return
+		}
+		responder.RespondWithData(ctx, ts)
+	case "POST":
+		query := ctx.HttpRequest().URL.Query()
+		scope := query.Get("scope")
+		if scope == "" {
+			scope = token.Read
+		}
+		days := defaultDays
+		if v := query.Get("expires_in"); v != "" {
+			var err error
+			if days, err = strconv.Atoi(v); err != nil || days < 1 {
+				responder.RespondWithError(ctx, http.StatusBadRequest, "expires_in must be a positive number of days")
+				return
+			}
+		}
+		if max := maxTokenDays(); days > max {
+			responder.RespondWithError(ctx, http.StatusBadRequest, "expires_in can not exceed "+strconv.Itoa(max)+" days")
+			return
+		}
+		expires := time.Now().AddDate(0, 0, days)
+		t, err := token.New(u.Uuid, query.Get("name"), scope, splitIds(query.Get("nodes")), splitIds(query.Get("collections")), expires)
+		if err != nil {
+			responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		logger.Info("access", "token "+t.Id+" issued to "+u.Username)
+		responder.RespondWithData(ctx, t)
+	default:
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+	}
+}
+
+// GET, DELETE: /token/{id}
+// Owners and admins can view and revoke a token.
+func TokenTypedRequest(ctx context.Context) {
+	u := request.RequireAuth(ctx)
+	if u == nil {
+		return
+	}
+	t, err := token.Load(ctx.PathValue("id"))
+	if err == mgo.ErrNotFound {
+		responder.RespondWithError(ctx, http.StatusNotFound, "Token not found")
+		return
+	} else if err != nil {
+		err_msg := "err@token_Load: " + err.Error()
+		logger.Error(err_msg)
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+		return
+	}
+	if t.Owner != u.Uuid && !u.Admin {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		return
+	}
+
+	switch ctx.HttpRequest().Method {
+	case "GET":
+		responder.RespondWithData(ctx, t)
+	case "DELETE":
+		if err := t.Revoke(); err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logger.Info("access", "token "+t.Id+" revoked by "+u.Username)
+		responder.RespondOK(ctx)
+	default:
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+	}
+}
+
+// maxTokenDays returns the configured longest token lifetime
+func maxTokenDays() int {
+	if n, err := strconv.Atoi(conf.Conf["token_max_days"]); err == nil && n > 0 {
+		return n
+	}
+	return maxDays
+}
+
+func splitIds(s string) (ids []string) {
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return
+}
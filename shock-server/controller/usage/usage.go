@@ -0,0 +1,176 @@
+// Package usage implements the storage usage and quota resources
+// /usage, /user/{uuid}/usage and /group/{name}/usage
+package usage
+
+import (
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/group"
+	"github.com/MG-RAST/Shock/shock-server/logger"
+	"github.com/MG-RAST/Shock/shock-server/quota"
+	"github.com/MG-RAST/Shock/shock-server/request"
+	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"github.com/stretchr/goweb/context"
+	"labix.org/v2/mgo"
+	"net/http"
+	"strconv"
+)
+
+// userUsage is the usage of a user with that of the local groups it is a
+// member of, whose quotas also apply to its nodes
+type userUsage struct {
+	quota.Usage
+	Groups []quota.Usage `json:"groups"`
+}
+
+// GET: /usage
+// Lists the usage of every node owner, largest first. Admin only.
+func UsageRequest(ctx context.Context) {
+	u := request.RequireAuth(ctx)
+	if u == nil {
+		return
+	}
+	if !u.Admin {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		return
+	} else if ctx.HttpRequest().Method != "GET" {
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	}
+	all, err := quota.All()
+	if err != nil {
+		err_msg := "err@usage_All: " + err.Error()
+		logger.Error(err_msg)
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+		return
+	}
+	responder.RespondWithData(ctx, all)
+}
+
+// GET, PUT, DELETE: /user/{uuid}/usage
+// Users can view their own usage, admins that of anyone. Admins set the
+// quota of the user with PUT ?quota_bytes=<size>&quota_nodes=<count> and
+// return it to the configured default with DELETE.
+func UserUsageRequest(ctx context.Context) {
+	uuid := ctx.PathValue("uuid")
+	u := request.RequireAuth(ctx)
+	if u == nil {
+		return
+	}
+	if !u.Admin && u.Uuid != uuid {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		return
+	}
+	if !setLimit(ctx, u, quota.User, uuid) {
+		return
+	}
+	usage, err := quota.UserUsage(uuid)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+	names, err := group.MemberOf(uuid)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+	data := userUsage{Usage: usage, Groups: []quota.Usage{}}
+	for _, name := range names {
+		g, err := group.Load(name)
+		if err != nil {
+			continue
+		}
+		gu, err := quota.GroupUsage(g)
+		if err != nil {
+			respondWithError(ctx, err)
+			return
+		}
+		data.Groups = append(data.Groups, gu)
+	}
+	responder.RespondWithData(ctx, data)
+}
+
+// GET, PUT, DELETE: /group/{name}/usage
+// Members can view the usage of a local group, admins set its quota as
+// for users.
+func GroupUsageRequest(ctx context.Context) {
+	u := request.RequireAuth(ctx)
+	if u == nil {
+		return
+	}
+	g, err := group.Load(ctx.PathValue("name"))
+	if err == mgo.ErrNotFound {
+		responder.RespondWithError(ctx, http.StatusNotFound, "Group not found")
+		return
+	} else if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+	if !g.CanView(u.Uuid, u.Admin) {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		return
+	}
+	if !setLimit(ctx, u, quota.Group, g.Name) {
+		return
+	}
+	usage, err := quota.GroupUsage(g)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+	responder.RespondWithData(ctx, usage)
+}
+
+// setLimit handles PUT and DELETE of a quota. It returns false if an
+// error response was written.
+func setLimit(ctx context.Context, u *user.User, kind string, name string) bool {
+	method := ctx.HttpRequest().Method
+	if method == "GET" {
+		return true
+	} else if method != "PUT" && method != "DELETE" {
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return false
+	} else if !u.Admin {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		return false
+	}
+	if method == "DELETE" {
+		if err := quota.ResetLimit(kind, name); err != nil {
+			respondWithError(ctx, err)
+			return false
+		}
+		logger.Info("access", "quota of "+kind+" "+name+" reset by "+u.Username)
+		return true
+	}
+
+	l, err := quota.GetLimit(kind, name)
+	if err != nil {
+		respondWithError(ctx, err)
+		return false
+	}
+	query := ctx.HttpRequest().URL.Query()
+	if _, ok := query["quota_bytes"]; ok {
+		if l.Bytes, err = quota.ParseSize(query.Get("quota_bytes")); err != nil {
+			responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+			return false
+		}
+	}
+	if _, ok := query["quota_nodes"]; ok {
+		if l.Nodes, err = strconv.Atoi(query.Get("quota_nodes")); err != nil {
+			responder.RespondWithError(ctx, http.StatusBadRequest, "quota_nodes must be an integer")
+			return false
+		}
+	}
+	if err := quota.SetLimit(kind, name, l.Bytes, l.Nodes); err != nil {
+		responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+		return false
+	}
+	logger.Info("access", "quota of "+kind+" "+name+" set by "+u.Username)
+	return true
+}
+
+func respondWithError(ctx context.Context, err error) {
+	err_msg := "err@usage: " + err.Error()
+	logger.Error(err_msg)
+	responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+}
@@ -0,0 +1,8 @@
+package user
+
+// exported for the tests of user_test
+var (
+	CanAccess      = canAccess
+	ModifyUser     = modifyUser
+	FindByUsername = &findByUsername
+)
@@ -0,0 +1,291 @@
+// Package user implements /user resource
+package user
+
+import (
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/auth"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/logger"
+	"github.com/MG-RAST/Shock/shock-server/request"
+	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"github.com/MG-RAST/Shock/shock-server/util"
+	"github.com/stretchr/goweb/context"
+	"labix.org/v2/mgo"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const errExternalUsers = "Users are registered with the external auth provider."
+
+var findByUsername = user.FindByUsername
+
+// localUsers returns true if users log in with the passwords stored by
+// Shock. With an external auth provider only profiles and admin flags of
+// the users it has authenticated can be managed.
+func localUsers() bool {
+	return conf.Conf["basic_auth"] != ""
+}
+
+// GET, POST: /user
+// GET lists all users, admin only. POST creates a user; admins can
+// create users and other admins, anyone can register when the
+// [Anonymous] create-user option is set.
+func UserRequest(ctx context.Context) {
+	switch ctx.HttpRequest().Method {
+	case "GET":
+		u := request.RequireAuth(ctx)
+		if u == nil {
+			return
+		} else if !u.Admin {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+			return
+		}
+		query := ctx.HttpRequest().URL.Query()
+		limit := 25
+		offset := 0
+		if _, ok := query["limit"]; ok {
+			limit = util.ToInt(query.Get("limit"))
+		}
+		if _, ok := query["offset"]; ok {
+			offset = util.ToInt(query.Get("offset"))
+		}
+		users, count, err := user.List(limit, offset)
+		if err != nil {
+			err_msg := "err@user_List: " + err.Error()
+			logger.Error(err_msg)
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+			return
+		}
+		responder.RespondWithPaginatedData(ctx, users, limit, offset, count)
+	case "POST":
+		// registration does not require a user
+		u, err := request.Authenticate(ctx.HttpRequest())
+		if err != nil && err.Error() != e.NoAuth {
+			request.AuthError(err, ctx)
+			return
+		}
+		admin := u != nil && u.Admin
+		if !localUsers() {
+			responder.RespondWithError(ctx, http.StatusBadRequest, errExternalUsers)
+			return
+		} else if !admin && !conf.Bool(conf.Conf["anon-user"]) {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+			return
+		}
+		params, err := parseParams(ctx)
+		if err != nil {
+			responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !user.IsValidUsername(params["username"]) {
+			responder.RespondWithError(ctx, http.StatusBadRequest, user.ErrInvalidUsername.Error())
+			return
+		} else if params["password"] == "" {
+			responder.RespondWithError(ctx, http.StatusBadRequest, "password required")
+			return
+		}
+		isAdmin := false
+		if v, ok := params["shock_admin"]; ok {
+			if isAdmin, err = strconv.ParseBool(v); err != nil {
+				responder.RespondWithError(ctx, http.StatusBadRequest, "shock_admin must be true or false")
+				return
+			} else if isAdmin && !admin {
+				responder.RespondWithError(ctx, http.StatusUnauthorized, "Only admins can create admins.")
+				return
+			}
+		}
+		if _, err := user.FindByUsername(params["username"]); err == nil {
+			responder.RespondWithError(ctx, http.StatusBadRequest, "username already exists")
+			return
+		}
+		nu, err := user.New(params["username"], params["password"], isAdmin)
+		if err != nil {
+			err_msg := "err@user_New: " + err.Error()
+			logger.Error(err_msg)
+			responder.RespondWithError(ctx, http.StatusBadRequest, err_msg)
+			return
+		}
+		nu.Fullname = params["fullname"]
+		nu.Email = params["email"]
+		if err := nu.Update(); err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logger.Info("access", "user "+nu.Username+" created by "+username(u))
+		responder.RespondWithData(ctx, nu)
+	default:
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+	}
+}
+
+// GET, PUT, DELETE: /user/{uuid}
+// Users can view and change their own profile and password, changing the
+// password requires the current one in old_password. Admins can change
+// any user, grant or revoke the admin flag and delete users.
+func UserTypedRequest(ctx context.Context) {
+	uuid := ctx.PathValue("uuid")
+	u := request.RequireAuth(ctx)
+	if u == nil {
+		return
+	} else if !canAccess(u, uuid) {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		return
+	}
+
+	tu, err := user.FindByUuid(uuid)
+	if err == mgo.ErrNotFound {
+		responder.RespondWithError(ctx, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		err_msg := "err@user_FindByUuid: " + err.Error()
+		logger.Error(err_msg)
+		responder.RespondWithError(ctx, http.StatusInternalServerError, err_msg)
+		return
+	}
+
+	switch ctx.HttpRequest().Method {
+	case "GET":
+	case "PUT":
+		params, err := parseParams(ctx)
+		if err != nil {
+			responder.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		if status, err := modifyUser(u, tu, params); err != nil {
+			responder.RespondWithError(ctx, status, err.Error())
+			return
+		}
+		if err := tu.Update(); err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		auth.FlushUser(tu.Uuid)
+		logger.Info("access", "user "+tu.Username+" modified by "+u.Username)
+	case "DELETE":
+		if !u.Admin {
+			responder.RespondWithError(ctx, http.StatusUnauthorized, "Only admins can delete users.")
+			return
+		} else if tu.Uuid == u.Uuid {
+			responder.RespondWithError(ctx, http.StatusBadRequest, "Admins can not delete themselves.")
+			return
+		}
+		if err := tu.Delete(); err != nil {
+			responder.RespondWithError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		auth.FlushUser(tu.Uuid)
+		logger.Info("access", "user "+tu.Username+" deleted by "+u.Username)
+		responder.RespondOK(ctx)
+		return
+	default:
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	}
+	responder.RespondWithData(ctx, tu)
+}
+
+// DELETE: /user/{uuid}/cache
+// Drops the cached credentials of a user and its failed logins, so that
+// changes made with the auth provider apply to the next request and a
+// locked out user can log in again. Admin only.
+func UserCacheRequest(ctx context.Context) {
+	u := request.RequireAuth(ctx)
+	if u == nil {
+		return
+	} else if !u.Admin {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.UnAuth)
+		return
+	} else if ctx.HttpRequest().Method != "DELETE" {
+		responder.RespondWithError(ctx, http.StatusNotImplemented, "This request type is not implemented.")
+		return
+	}
+	uuid := ctx.PathValue("uuid")
+	n := auth.FlushUser(uuid)
+	if tu, err := user.FindByUuid(uuid); err == nil {
+		auth.Unlock(tu.Username)
+	}
+	logger.Info("access", "auth cache of user "+uuid+" flushed by "+u.Username)
+	responder.RespondWithData(ctx, map[string]int{"flushed": n})
+}
+
+// canAccess returns true if u may view and change the user with uuid,
+// admins any user and other users only themselves
+func canAccess(u *user.User, uuid string) bool {
+	return u.Admin || u.Uuid == uuid
+}
+
+// modifyUser applies the changes in params by u to the user tu. It returns
+// the status of the response and an error if they are not allowed or
+// invalid, tu is then partially changed.
+func modifyUser(u *user.User, tu *user.User, params map[string]string) (status int, err error) {
+	if v, ok := params["username"]; ok && v != tu.Username {
+		if !u.Admin {
+			return http.StatusUnauthorized, errors.New("Only admins can change usernames.")
+		} else if !user.IsValidUsername(v) {
+			return http.StatusBadRequest, user.ErrInvalidUsername
+		} else if _, err := findByUsername(v); err == nil {
+			return http.StatusBadRequest, errors.New("username already exists")
+		}
+		tu.Username = v
+	}
+	if v, ok := params["fullname"]; ok {
+		tu.Fullname = v
+	}
+	if v, ok := params["email"]; ok {
+		tu.Email = v
+	}
+	if v, ok := params["password"]; ok {
+		if !localUsers() {
+			return http.StatusBadRequest, errors.New(errExternalUsers)
+		} else if v == "" {
+			return http.StatusBadRequest, errors.New("password can not be empty")
+		}
+		// admins reset passwords, users prove they know theirs
+		if !u.Admin && !tu.CheckPassword(params["old_password"]) {
+			return http.StatusUnauthorized, errors.New("old_password does not match")
+		}
+		if err := tu.SetPassword(v); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	if v, ok := params["shock_admin"]; ok {
+		isAdmin, err := strconv.ParseBool(v)
+		if err != nil {
+			return http.StatusBadRequest, errors.New("shock_admin must be true or false")
+		} else if !u.Admin {
+			return http.StatusUnauthorized, errors.New("Only admins can change the admin flag.")
+		} else if !isAdmin && tu.Uuid == u.Uuid {
+			return http.StatusBadRequest, errors.New("Admins can not revoke their own admin flag.")
+		}
+		tu.Admin = isAdmin
+	}
+	return http.StatusOK, nil
+}
+
+// parseParams returns the fields of a multipart or url encoded form.
+// Passwords should not be sent in the url, where they end up in logs.
+func parseParams(ctx context.Context) (params map[string]string, err error) {
+	r := ctx.HttpRequest()
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		params, _, err = request.ParseMultipartForm(r)
+		return
+	}
+	if err = r.ParseForm(); err != nil {
+		return nil, err
+	}
+	params = map[string]string{}
+	for k := range r.Form {
+		params[k] = r.Form.Get(k)
+	}
+	return
+}
+
+func username(u *user.User) string {
+	if u == nil {
+		return "anonymous"
+	}
+	return u.Username
+}
@@ -0,0 +1,98 @@
+package user_test
+
+import (
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	. "github.com/MG-RAST/Shock/shock-server/controller/user"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"net/http"
+	"testing"
+)
+
+var (
+	admin = &user.User{Uuid: "a", Username: "admin", Admin: true}
+	self  = &user.User{Uuid: "s", Username: "self"}
+	other = &user.User{Uuid: "o", Username: "other"}
+)
+
+func TestCanAccess(t *testing.T) {
+	for _, test := range []struct {
+		u    *user.User
+		uuid string
+		ok   bool
+	}{
+		{admin, "a", true},
+		{admin, "s", true},
+		{self, "s", true},
+		{self, "o", false},
+		{other, "s", false},
+	} {
+		if got := CanAccess(test.u, test.uuid); got != test.ok {
+			t.Errorf("%s on %s: got %v", test.u.Username, test.uuid, got)
+		}
+	}
+}
+
+func TestModifyUser(t *testing.T) {
+	conf.Conf["basic_auth"] = "true"
+	defer delete(conf.Conf, "basic_auth")
+	find := *FindByUsername
+	defer func() { *FindByUsername = find }()
+	*FindByUsername = func(name string) (*user.User, error) {
+		if name == "other" {
+			return other, nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	for _, test := range []struct {
+		u      *user.User
+		params map[string]string
+		status int
+	}{
+		{self, map[string]string{"fullname": "Self", "email": "s@example.com"}, http.StatusOK},
+		{self, map[string]string{"username": "renamed"}, http.StatusUnauthorized},
+		{admin, map[string]string{"username": "renamed"}, http.StatusOK},
+		{admin, map[string]string{"username": "other"}, http.StatusBadRequest},
+		{admin, map[string]string{"username": "bad name!"}, http.StatusBadRequest},
+		{self, map[string]string{"password": "new"}, http.StatusUnauthorized},
+		{self, map[string]string{"password": "new", "old_password": "wrong"}, http.StatusUnauthorized},
+		{self, map[string]string{"password": "new", "old_password": "old"}, http.StatusOK},
+		{self, map[string]string{"password": "", "old_password": "old"}, http.StatusBadRequest},
+		{admin, map[string]string{"password": "new"}, http.StatusOK},
+		{self, map[string]string{"shock_admin": "true"}, http.StatusUnauthorized},
+		{admin, map[string]string{"shock_admin": "yes"}, http.StatusBadRequest},
+		{admin, map[string]string{"shock_admin": "true"}, http.StatusOK},
+	} {
+		tu := &user.User{Uuid: "s", Username: "self"}
+		tu.SetPassword("old")
+		status, err := ModifyUser(test.u, tu, test.params)
+		if status != test.status || (err == nil) != (status == http.StatusOK) {
+			t.Errorf("%s with %v: got %d, %v, want %d", test.u.Username, test.params, status, err, test.status)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if v, ok := test.params["password"]; ok && !tu.CheckPassword(v) {
+			t.Errorf("%s with %v: password not changed", test.u.Username, test.params)
+		}
+		if v, ok := test.params["username"]; ok && tu.Username != v {
+			t.Errorf("%s with %v: got username %s", test.u.Username, test.params, tu.Username)
+		}
+		if _, ok := test.params["shock_admin"]; ok && !tu.Admin {
+			t.Errorf("%s with %v: admin flag not set", test.u.Username, test.params)
+		}
+	}
+
+	// admins can not lock themselves out
+	tu := &user.User{Uuid: "a", Username: "admin", Admin: true}
+	if status, err := ModifyUser(admin, tu, map[string]string{"shock_admin": "false"}); status != http.StatusBadRequest || err == nil || !tu.Admin {
+		t.Errorf("admin revoking own flag: got %d, %v", status, err)
+	}
+
+	delete(conf.Conf, "basic_auth")
+	if status, _ := ModifyUser(admin, &user.User{Uuid: "s"}, map[string]string{"password": "new"}); status != http.StatusBadRequest {
+		t.Errorf("password of external user: got %d", status)
+	}
+}
@@ -0,0 +1,136 @@
+package query
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorKey signs cursor tokens so clients can not change them. Tokens
+// are valid until the server restarts.
+var cursorKey = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+func signCursor(b []byte) []byte {
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+// Cursor marks a position in a sorted node listing. It is passed to
+// clients as a signed, opaque token and continues after the node with
+// Id, whose first sort field had Value. Count carries the total from the
+// first page so later pages do not need to count again.
+type Cursor struct {
+	Order []string    `bson:"o"`
+	Value interface{} `bson:"v"`
+	Id    string      `bson:"i"`
+	Count int         `bson:"c"`
+}
+
+// ParseCursor decodes a cursor token. Tokens not signed by this server,
+// and cursors with an order Sort does not return or a value that is not
+// a single sort key, are invalid.
+func ParseCursor(token string) (c *Cursor, err error) {
+	b, err := base64.URLEncoding.DecodeString(token)
+	if err != nil || len(b) < sha256.Size {
+		return nil, ErrInvalidCursor
+	}
+	b, sig := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	if !hmac.Equal(sig, signCursor(b)) {
+		return nil, ErrInvalidCursor
+	}
+	c = new(Cursor)
+	if err = bson.Unmarshal(b, c); err != nil || c.Id == "" || c.Count < 0 || !validOrder(c.Order) || !isScalar(c.Value) {
+		return nil, ErrInvalidCursor
+	}
+	return
+}
+
+// String returns the cursor token
+func (c *Cursor) String() string {
+	b, _ := bson.Marshal(c)
+	return base64.URLEncoding.EncodeToString(append(b, signCursor(b)...))
+}
+
+// validOrder returns true if order is the result of Sort
+func validOrder(order []string) bool {
+	if len(order) == 0 {
+		return false
+	}
+	field, direction := order[0], "asc"
+	if strings.HasPrefix(field, "-") {
+		field, direction = field[1:], "desc"
+	}
+	for key, f := range dateFields {
+		if f == field {
+			field = key
+		}
+	}
+	keys, err := Sort(field, direction)
+	if err != nil || len(keys) != len(order) {
+		return false
+	}
+	for i := range keys {
+		if keys[i] != order[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isScalar returns true for the values a sort field can hold
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case nil, string, bool, int, int64, float64, time.Time, bson.ObjectId:
+		return true
+	}
+	return false
+}
+
+// Field returns the first sort field and whether it sorts descending
+func (c *Cursor) Field() (field string, desc bool) {
+	field = c.Order[0]
+	if strings.HasPrefix(field, "-") {
+		return field[1:], true
+	}
+	return field, false
+}
+
+// After returns the condition for nodes following the cursor. Sort
+// keys are as returned by Sort: a field then id ascending.
+func (c *Cursor) After() bson.M {
+	field, desc := c.Field()
+	op := "$gt"
+	if desc {
+		op = "$lt"
+	}
+	if field == "id" {
+		return bson.M{"id": bson.M{op: c.Id}}
+	}
+	tie := bson.M{field: c.Value, "id": bson.M{"$gt": c.Id}}
+	// nodes without the field sort before all others
+	if c.Value == nil {
+		if desc {
+			return tie
+		}
+		return bson.M{"$or": []bson.M{tie, {field: bson.M{"$ne": nil}}}}
+	}
+	next := []bson.M{{field: bson.M{op: c.Value}}, tie}
+	if desc {
+		next = append(next, bson.M{field: nil})
+	}
+	return bson.M{"$or": next}
+}
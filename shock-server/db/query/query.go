@@ -1,32 +1,272 @@
-// Package query to parse complex mongodb queries (UNFINISHED)
+// Package query parses the JSON node query language into mongodb queries
+//
+// A query is a JSON object mapping node fields to conditions, for example
+//
+//	{"file.format": "fastq",
+//	 "file.size": {"$gt": 1073741824},
+//	 "created_on": {"$gte": "2014-05-01", "$lt": "2014-06-01"},
+//	 "attributes.project.name": {"$prefix": "X"},
+//	 "$or": [{"tags": {"$in": ["soil", "marine"]}}, {"attributes.biome": {"$exists": true}}]}
+//
+// Only the fields and operators listed here are accepted, so a query can
+// never reach acl, storage or other internal node fields.
 package query
 
-/*
 import (
-	"labix.org/v2/mgo/bson"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"ioutil"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	maxDepth     = 8
+	maxClauses   = 100
+	maxPrefixLen = 256
+)
+
+type fieldType int
+
+const (
+	anyType fieldType = iota
+	dateType
 )
 
-type mQuery struct {
-	Query      *bson.M
-	Projection *map[string]bool
+// fields are the node fields that can be queried. Paths below
+// attributes and file.checksum may be of any depth.
+var fields = map[string]fieldType{
+	"id":                anyType,
+	"version":           anyType,
+	"tags":              anyType,
+	"public":            anyType,
+	"file.name":         anyType,
+	"file.size":         anyType,
+	"file.format":       anyType,
+	"file.virtual":      anyType,
+	"linkage.ids":       anyType,
+	"linkage.type":      anyType,
+	"linkage.operation": anyType,
+	"created_on":        dateType,
+	"last_modified":     dateType,
+}
+
+var prefixes = []string{"attributes.", "file.checksum."}
+
+// dateFields maps date fields, stored as display strings, to the
+// fields holding the same time as a mongodb date.
+var dateFields = map[string]string{
+	"created_on":    "created",
+	"last_modified": "modified",
 }
 
-func Parse(r *io.Reader) (q *mQuery, err error) {
-    body := []byte{}
-    if body, err = ioutil.ReadAll(r); err != nil {
-        return nil, err
-    }
-    return parseBytes(body)
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+var segment = regexp.MustCompile(`^[^$.\x00][^.\x00]*$`)
+
+// Parse reads a JSON query and returns the equivalent mongodb query
+func Parse(r io.Reader) (q bson.M, err error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBytes(body)
 }
 
-func parseBytes(b []bytes) (q *mQuery, err error) {
-    i := interface{}
-    err = json.Unmarshal(b, &i)
-    if err != nil {
-        return nil, err
-    }
+// ParseBytes parses a JSON query
+func ParseBytes(b []byte) (q bson.M, err error) {
+	var doc map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err = d.Decode(&doc); err != nil {
+		return nil, errors.New("query is not a valid JSON object: " + err.Error())
+	}
+	p := &parser{}
+	return p.document(doc, 0)
+}
+
+type parser struct {
+	clauses int
+}
+
+func (p *parser) document(doc map[string]interface{}, depth int) (q bson.M, err error) {
+	if depth > maxDepth {
+		return nil, errors.New("query is nested too deeply")
+	}
+	q = bson.M{}
+	for key, val := range doc {
+		if p.clauses++; p.clauses > maxClauses {
+			return nil, fmt.Errorf("query has more than %d clauses", maxClauses)
+		}
+		switch key {
+		case "$and", "$or", "$nor":
+			list, ok := val.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("%s requires a non-empty array of queries", key)
+			}
+			subs := []bson.M{}
+			for _, item := range list {
+				sub, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%s requires a non-empty array of queries", key)
+				}
+				s, err := p.document(sub, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				subs = append(subs, s)
+			}
+			q[key] = subs
+		default:
+			field, ftype, err := checkField(key)
+			if err != nil {
+				return nil, err
+			}
+			cond, err := condition(key, ftype, val)
+			if err != nil {
+				return nil, err
+			}
+			q[field] = cond
+		}
+	}
+	return
+}
+
+// checkField validates a field path and returns the mongodb field to query
+func checkField(key string) (field string, ftype fieldType, err error) {
+	for _, s := range strings.Split(key, ".") {
+		if !segment.MatchString(s) {
+			return "", anyType, errors.New("invalid field: " + key)
+		}
+	}
+	if t, ok := fields[key]; ok {
+		if t == dateType {
+			return dateFields[key], t, nil
+		}
+		return key, t, nil
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return key, anyType, nil
+		}
+	}
+	return "", anyType, errors.New("field can not be queried: " + key)
+}
+
+func condition(key string, ftype fieldType, val interface{}) (interface{}, error) {
+	ops, isOps := val.(map[string]interface{})
+	if !isOps {
+		return value(key, ftype, val)
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("empty condition for field: " + key)
+	}
+	cond := bson.M{}
+	for op, arg := range ops {
+		var err error
+		switch op {
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+			if op == "$eq" {
+				// $eq is not understood by older mongodb servers
+				if _, ok := ops["$in"]; ok {
+					return nil, errors.New("$eq and $in can not be combined")
+				}
+				op = "$in"
+				arg = []interface{}{arg}
+				cond[op], err = values(key, ftype, arg)
+			} else {
+				cond[op], err = value(key, ftype, arg)
+			}
+		case "$in", "$nin", "$all":
+			cond[op], err = values(key, ftype, arg)
+		case "$exists":
+			b, ok := arg.(bool)
+			if !ok {
+				return nil, errors.New("$exists requires true or false")
+			}
+			cond[op] = b
+		case "$prefix":
+			s, ok := arg.(string)
+			if !ok || s == "" {
+				return nil, errors.New("$prefix requires a non-empty string")
+			} else if len(s) > maxPrefixLen {
+				return nil, fmt.Errorf("$prefix is longer than %d characters", maxPrefixLen)
+			}
+			// mongodb runs regexes with pcre, which can backtrack without
+			// bound, so only literal prefixes are accepted. Anchored
+			// regexes on a literal prefix can also use indexes.
+			cond["$regex"] = "^" + regexp.QuoteMeta(s)
+		case "$options":
+			s, ok := arg.(string)
+			if !ok || strings.Trim(s, "ims") != "" {
+				return nil, errors.New("$options may only contain i, m and s")
+			}
+			cond[op] = s
+		case "$regex":
+			return nil, errors.New("$regex is not supported, use $prefix")
+		default:
+			return nil, fmt.Errorf("unsupported operator %s for field %s", op, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := cond["$options"]; ok {
+		if _, ok := cond["$regex"]; !ok {
+			return nil, errors.New("$options requires $prefix")
+		}
+	}
+	return cond, nil
+}
+
+func values(key string, ftype fieldType, arg interface{}) (interface{}, error) {
+	list, ok := arg.([]interface{})
+	if !ok {
+		return nil, errors.New("expected an array of values for field: " + key)
+	}
+	vals := []interface{}{}
+	for _, v := range list {
+		val, err := value(key, ftype, v)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
 
+// value converts a JSON scalar to its mongodb value
+func value(key string, ftype fieldType, v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, errors.New("invalid number for field: " + key)
+		}
+		return f, nil
+	case string:
+		if ftype == dateType {
+			for _, layout := range dateLayouts {
+				if d, err := time.Parse(layout, t); err == nil {
+					return d, nil
+				}
+			}
+			return nil, errors.New("invalid date for field " + key + ", use YYYY-MM-DD or RFC3339")
+		}
+		return t, nil
+	case bool, nil:
+		if ftype == dateType {
+			return nil, errors.New("invalid date for field " + key + ", use YYYY-MM-DD or RFC3339")
+		}
+		return t, nil
+	}
+	return nil, errors.New("value must be a string, number, boolean or null for field: " + key)
 }
-*/
@@ -1,12 +1,180 @@
 package query_test
 
 import (
-	//"fmt"
-	. "github.com/MG-RAST/Shock/shock-server/query"
+	. "github.com/MG-RAST/Shock/shock-server/db/query"
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
-const testQ = `{{"tags": {"$all": ["metagenome", "soil"]}},{"id": true}}`
+var parseTests = []struct {
+	q    string
+	want bson.M
+}{
+	{`{"file.format": "fastq"}`, bson.M{"file.format": "fastq"}},
+	{`{"file.size": {"$gt": 1073741824}}`, bson.M{"file.size": bson.M{"$gt": int64(1073741824)}}},
+	{`{"attributes.score": {"$gte": 0.5, "$lt": 1}}`, bson.M{"attributes.score": bson.M{"$gte": 0.5, "$lt": int64(1)}}},
+	{`{"attributes.project.name": {"$eq": "X"}}`, bson.M{"attributes.project.name": bson.M{"$in": []interface{}{"X"}}}},
+	{`{"tags": {"$all": ["metagenome", "soil"]}}`, bson.M{"tags": bson.M{"$all": []interface{}{"metagenome", "soil"}}}},
+	{`{"file.checksum.md5": {"$nin": ["a", "b"]}}`, bson.M{"file.checksum.md5": bson.M{"$nin": []interface{}{"a", "b"}}}},
+	{`{"attributes.biome": {"$exists": false}}`, bson.M{"attributes.biome": bson.M{"$exists": false}}},
+	{`{"file.name": {"$prefix": "SRR", "$options": "i"}}`, bson.M{"file.name": bson.M{"$regex": "^SRR", "$options": "i"}}},
+	{`{"file.name": {"$prefix": "a.b"}}`, bson.M{"file.name": bson.M{"$regex": `^a\.b`}}},
+	{`{"created_on": {"$gte": "2014-05-01", "$lt": "2014-06-01T00:00:00Z"}}`, bson.M{"created": bson.M{
+		"$gte": time.Date(2014, 5, 1, 0, 0, 0, 0, time.UTC),
+		"$lt":  time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC),
+	}}},
+	{`{"$or": [{"tags": "soil"}, {"attributes.biome": null}], "public": true}`, bson.M{
+		"$or":    []bson.M{{"tags": "soil"}, {"attributes.biome": nil}},
+		"public": true,
+	}},
+}
+
+var invalidQueries = []string{
+	`[]`,
+	`{"acl.owner": "x"}`,
+	`{"file.path": "/etc/passwd"}`,
+	`{"attributes": {"$exists": true}}`,
+	`{"attributes.$where": "1"}`,
+	`{"attributes..a": 1}`,
+	`{"$where": "sleep(1000)"}`,
+	`{"file.size": {"$where": 1}}`,
+	`{"file.size": {}}`,
+	`{"file.size": {"$gt": [1]}}`,
+	`{"file.size": {"$gt": {"$ne": 1}}}`,
+	`{"tags": ["a", "b"]}`,
+	`{"tags": {"$in": "a"}}`,
+	`{"file.name": {"$regex": "^(a+)+$"}}`,
+	`{"file.name": {"$prefix": "a", "$options": "x"}}`,
+	`{"file.name": {"$prefix": ""}}`,
+	`{"file.name": {"$prefix": "` + strings.Repeat("a", 257) + `"}}`,
+	`{"file.name": {"$options": "i"}}`,
+	`{"attributes.a": {"$eq": 1, "$in": [2]}}`,
+	`{"created_on": {"$gt": "yesterday"}}`,
+	`{"$or": []}`,
+	`{"$and": [1]}`,
+}
 
 func TestParse(t *testing.T) {
+	for _, tt := range parseTests {
+		got, err := ParseBytes([]byte(tt.q))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %#v\nwant %#v", tt.q, got, tt.want)
+		}
+	}
+	for _, q := range invalidQueries {
+		if got, err := ParseBytes([]byte(q)); err == nil {
+			t.Errorf("%s: expected error, got %#v", q, got)
+		}
+	}
+	if _, err := ParseBytes([]byte(`{"file.name": {"$regex": "^a"}}`)); err == nil || !strings.Contains(err.Error(), "$prefix") {
+		t.Errorf("$regex: got %v, want a pointer to $prefix", err)
+	}
+}
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		order, direction string
+		want             []string
+	}{
+		{"created_on", "", []string{"created", "id"}},
+		{"file.size", "desc", []string{"-file.size", "id"}},
+		{"attributes.project.name", "ASC", []string{"attributes.project.name", "id"}},
+		{"id", "desc", []string{"-id"}},
+	}
+	for _, tt := range tests {
+		got, err := Sort(tt.order, tt.direction)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Sort(%q, %q) = %v, %v, want %v", tt.order, tt.direction, got, err, tt.want)
+		}
+	}
+	for _, order := range []string{"acl.owner", "file.path", "$natural", ""} {
+		if _, err := Sort(order, ""); err == nil {
+			t.Errorf("Sort(%q): expected error", order)
+		}
+	}
+	if _, err := Sort("file.size", "up"); err == nil {
+		t.Errorf("Sort direction up: expected error")
+	}
+}
+
+func TestProjection(t *testing.T) {
+	got, err := Projection("id,file.name, file.size,attributes.project,created_on")
+	want := bson.M{"_id": 0, "id": 1, "file.name": 1, "file.size": 1, "attributes.project": 1, "created_on": 1}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Projection = %v, %v, want %v", got, err, want)
+	}
+	if got, err = Projection("attributes,file.checksum"); err != nil || len(got) != 4 {
+		t.Errorf("Projection of whole attributes = %v, %v", got, err)
+	}
+	for _, list := range []string{"acl", "file", "file.path", "revisions", "id,acl.read"} {
+		if _, err := Projection(list); err == nil {
+			t.Errorf("Projection(%q): expected error", list)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	day := time.Date(2014, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		c     Cursor
+		after bson.M
+	}{
+		{Cursor{Order: []string{"id"}, Id: "b"}, bson.M{"id": bson.M{"$gt": "b"}}},
+		{Cursor{Order: []string{"file.size", "id"}, Value: int64(10), Id: "b", Count: 3}, bson.M{"$or": []bson.M{
+			{"file.size": bson.M{"$gt": int64(10)}},
+			{"file.size": int64(10), "id": bson.M{"$gt": "b"}},
+		}}},
+		{Cursor{Order: []string{"-created", "id"}, Value: day, Id: "b"}, bson.M{"$or": []bson.M{
+			{"created": bson.M{"$lt": day}},
+			{"created": day, "id": bson.M{"$gt": "b"}},
+			{"created": nil},
+		}}},
+		{Cursor{Order: []string{"attributes.a", "id"}, Id: "b"}, bson.M{"$or": []bson.M{
+			{"attributes.a": nil, "id": bson.M{"$gt": "b"}},
+			{"attributes.a": bson.M{"$ne": nil}},
+		}}},
+		{Cursor{Order: []string{"-attributes.a", "id"}, Id: "b"}, bson.M{"attributes.a": nil, "id": bson.M{"$gt": "b"}}},
+	}
+	for i, tt := range tests {
+		c, err := ParseCursor(tt.c.String())
+		if err != nil {
+			t.Errorf("%d. ParseCursor: %v", i, err)
+			continue
+		}
+		if c.Value != nil {
+			if tm, ok := c.Value.(time.Time); ok {
+				c.Value = tm.UTC()
+			}
+		}
+		if !reflect.DeepEqual(*c, tt.c) {
+			t.Errorf("%d. cursor round trip: got %#v, want %#v", i, *c, tt.c)
+		}
+		if got := c.After(); !reflect.DeepEqual(got, tt.after) {
+			t.Errorf("%d. After:\ngot  %#v\nwant %#v", i, got, tt.after)
+		}
+	}
+	valid := (&Cursor{Order: []string{"id"}, Id: "b"}).String()
+	tampered := []byte(valid)
+	tampered[2] ^= 1
+	invalid := []string{"", "not a cursor", string(tampered), valid[:len(valid)-4],
+		(&Cursor{Order: []string{"id"}}).String(),
+		(&Cursor{Order: []string{"acl.owner", "id"}, Id: "b"}).String(),
+		(&Cursor{Order: []string{"file.size"}, Id: "b"}).String(),
+		(&Cursor{Order: []string{"created_on", "id"}, Id: "b"}).String(),
+		(&Cursor{Order: []string{"file.size", "id"}, Value: bson.M{"$where": "sleep(1000)"}, Id: "b"}).String(),
+		(&Cursor{Order: []string{"file.size", "id"}, Value: []interface{}{1, 2}, Id: "b"}).String(),
+		(&Cursor{Order: []string{"id"}, Id: "b", Count: -1}).String(),
+	}
+	for _, token := range invalid {
+		if _, err := ParseCursor(token); err == nil {
+			t.Errorf("ParseCursor(%q): expected error", token)
+		}
+	}
 }
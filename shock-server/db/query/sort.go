@@ -0,0 +1,54 @@
+package query
+
+import (
+	"errors"
+	"labix.org/v2/mgo/bson"
+	"strings"
+)
+
+// projectOnly are fields that can be returned whole but not queried
+var projectOnly = map[string]bool{
+	"attributes":    true,
+	"file.checksum": true,
+	"linkage":       true,
+}
+
+// Sort returns the mongodb sort keys for ordering by a queryable field.
+// direction is asc (default) or desc. Nodes are ordered by id within
+// equal values so pages are stable.
+func Sort(order string, direction string) (keys []string, err error) {
+	field, _, err := checkField(order)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(direction) {
+	case "", "asc":
+	case "desc":
+		field = "-" + field
+	default:
+		return nil, errors.New("direction must be asc or desc")
+	}
+	keys = []string{field}
+	if field != "id" && field != "-id" {
+		keys = append(keys, "id")
+	}
+	return
+}
+
+// Projection returns the mongodb selector for a comma separated list of
+// fields. The node id is always included.
+func Projection(list string) (selector bson.M, err error) {
+	selector = bson.M{"_id": 0, "id": 1}
+	for _, f := range strings.Split(list, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		if !projectOnly[f] {
+			if _, _, err = checkField(f); err != nil {
+				return nil, err
+			}
+		}
+		selector[f] = 1
+	}
+	return
+}
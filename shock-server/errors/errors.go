@@ -20,4 +20,8 @@ const (
 	InvalidIndex             = "Invalid Index"
 	InvalidFileTypeForFilter = "Invalid file type for filter"
 	NodeReferenced           = "Node referenced by virtual node"
+	NoParts                  = "Node has no partial upload"
+	VersionMismatch          = "Node version does not match"
+	TokenScope               = "Request not allowed by api token scope"
+	TooManyFailures          = "Too many failed logins, try again later"
 )
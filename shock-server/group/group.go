@@ -0,0 +1,169 @@
+// Package group implements local user groups that can be granted node access
+package group
+
+import (
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/db"
+	"github.com/MG-RAST/Shock/shock-server/node/acl"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"time"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+var ErrInvalidName = errors.New("group name must be 1 to 64 letters, digits, '.', '_' or '-' starting with a letter or digit")
+
+// Group is a named set of users, managed by its owner
+type Group struct {
+	Name    string    `bson:"name" json:"name"`
+	Owner   string    `bson:"owner" json:"owner"`
+	Members []string  `bson:"members" json:"members"`
+	Created time.Time `bson:"created" json:"created_on"`
+}
+
+func collection(session *mgo.Session) *mgo.Collection {
+	return session.DB(conf.Conf["mongodb-database"]).C("Groups")
+}
+
+// Initialize ensures the indexes of the Groups collection
+func Initialize() {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := collection(session)
+	c.EnsureIndex(mgo.Index{Key: []string{"name"}, Unique: true})
+	c.EnsureIndex(mgo.Index{Key: []string{"members"}})
+}
+
+// IsValidName returns true if name can be used for a local group
+func IsValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// New creates a group owned by the user with uuid owner, who is also
+// its first member.
+func New(name string, owner string) (g *Group, err error) {
+	if !IsValidName(name) {
+		return nil, ErrInvalidName
+	}
+	g = &Group{Name: name, Owner: owner, Members: []string{owner}, Created: time.Now()}
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	if err = collection(session).Insert(g); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Load returns the group with name
+func Load(name string) (g *Group, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	g = &Group{}
+	if err = collection(session).Find(bson.M{"name": name}).One(g); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// List returns the groups the user with uuid owns or is a member of,
+// all groups if uuid is empty.
+func List(uuid string) (groups []Group, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	q := bson.M{}
+	if uuid != "" {
+		q = bson.M{"$or": []bson.M{{"owner": uuid}, {"members": uuid}}}
+	}
+	groups = []Group{}
+	err = collection(session).Find(q).Sort("name").All(&groups)
+	return
+}
+
+// MemberOf returns the names of the groups the user with uuid is a member of
+func MemberOf(uuid string) (names []string, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	groups := []Group{}
+	if err = collection(session).Find(bson.M{"members": uuid}).Select(bson.M{"name": 1}).All(&groups); err != nil {
+		return nil, err
+	}
+	names = []string{}
+	for _, g := range groups {
+		names = append(names, g.Name)
+	}
+	return
+}
+
+// CanManage returns true if the user with uuid may change the group
+func (g *Group) CanManage(uuid string, admin bool) bool {
+	return admin || (uuid != "" && uuid == g.Owner)
+}
+
+// CanView returns true if the user with uuid may see the group members
+func (g *Group) CanView(uuid string, admin bool) bool {
+	if g.CanManage(uuid, admin) {
+		return true
+	}
+	for _, m := range g.Members {
+		if m == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// AddMembers adds users by uuid
+func (g *Group) AddMembers(uuids []string) (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	if err = collection(session).Update(bson.M{"name": g.Name}, bson.M{"$addToSet": bson.M{"members": bson.M{"$each": uuids}}}); err != nil {
+		return
+	}
+	return collection(session).Find(bson.M{"name": g.Name}).One(g)
+}
+
+// RemoveMembers removes users by uuid
+func (g *Group) RemoveMembers(uuids []string) (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	if err = collection(session).Update(bson.M{"name": g.Name}, bson.M{"$pullAll": bson.M{"members": uuids}}); err != nil {
+		return
+	}
+	return collection(session).Find(bson.M{"name": g.Name}).One(g)
+}
+
+// Delete removes the group and the acl entries that grant it access to
+// nodes and collections, so a group created later with the same name
+// does not inherit them.
+func (g *Group) Delete() (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	for _, name := range []string{"Nodes", "Collections"} {
+		if err = removeAcls(session.DB(conf.Conf["mongodb-database"]).C(name), acl.GroupId(g.Name)); err != nil {
+			return
+		}
+	}
+	return collection(session).Remove(bson.M{"name": g.Name})
+}
+
+// removeAcls removes the acl entry id from the documents of c
+func removeAcls(c *mgo.Collection, id string) (err error) {
+	docs := []struct {
+		Id  interface{} `bson:"_id"`
+		Acl acl.Acl     `bson:"acl"`
+	}{}
+	q := bson.M{"$or": []bson.M{{"acl.read": id}, {"acl.write": id}, {"acl.delete": id}}}
+	if err = c.Find(q).Select(bson.M{"acl": 1}).All(&docs); err != nil {
+		return
+	}
+	for _, d := range docs {
+		d.Acl.Remove(id)
+		if err = c.UpdateId(d.Id, bson.M{"$set": bson.M{"acl.read": d.Acl.Read, "acl.write": d.Acl.Write, "acl.delete": d.Acl.Delete}}); err != nil && err != mgo.ErrNotFound {
+			return
+		}
+	}
+	return nil
+}
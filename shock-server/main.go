@@ -3,16 +3,30 @@ package main
 import (
 	"fmt"
 	"github.com/MG-RAST/Shock/shock-server/auth"
+	"github.com/MG-RAST/Shock/shock-server/auth/token"
+	"github.com/MG-RAST/Shock/shock-server/collection"
 	"github.com/MG-RAST/Shock/shock-server/conf"
+	ccon "github.com/MG-RAST/Shock/shock-server/controller/collection"
+	gcon "github.com/MG-RAST/Shock/shock-server/controller/group"
 	ncon "github.com/MG-RAST/Shock/shock-server/controller/node"
 	acon "github.com/MG-RAST/Shock/shock-server/controller/node/acl"
 	icon "github.com/MG-RAST/Shock/shock-server/controller/node/index"
+	ptcon "github.com/MG-RAST/Shock/shock-server/controller/node/parts"
+	rcon "github.com/MG-RAST/Shock/shock-server/controller/node/revisions"
 	pcon "github.com/MG-RAST/Shock/shock-server/controller/preauth"
+	scon "github.com/MG-RAST/Shock/shock-server/controller/schema"
+	tcon "github.com/MG-RAST/Shock/shock-server/controller/token"
+	ucon "github.com/MG-RAST/Shock/shock-server/controller/usage"
+	uscon "github.com/MG-RAST/Shock/shock-server/controller/user"
 	"github.com/MG-RAST/Shock/shock-server/db"
+	"github.com/MG-RAST/Shock/shock-server/group"
 	"github.com/MG-RAST/Shock/shock-server/logger"
 	"github.com/MG-RAST/Shock/shock-server/node"
 	"github.com/MG-RAST/Shock/shock-server/preauth"
+	"github.com/MG-RAST/Shock/shock-server/quota"
 	"github.com/MG-RAST/Shock/shock-server/responder"
+	"github.com/MG-RAST/Shock/shock-server/schema"
+	"github.com/MG-RAST/Shock/shock-server/storage"
 	"github.com/MG-RAST/Shock/shock-server/user"
 	"github.com/MG-RAST/Shock/shock-server/util"
 	"github.com/stretchr/goweb"
@@ -75,6 +89,10 @@ func mapRoutes() {
 		return nil
 	})
 
+	goweb.Map("POST", "/node/query", func(ctx context.Context) error {
+		return ncon.QueryRequest(ctx)
+	})
+
 	goweb.Map("/node/{nid}/acl/{type}", func(ctx context.Context) error {
 		acon.AclTypedRequest(ctx)
 		return nil
@@ -85,15 +103,115 @@ func mapRoutes() {
 		return nil
 	})
 
+	goweb.Map("/node/{nid}/parts/{part}", func(ctx context.Context) error {
+		ptcon.PartsTypedRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/node/{nid}/parts/", func(ctx context.Context) error {
+		ptcon.PartsRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/node/{nid}/revisions", func(ctx context.Context) error {
+		rcon.RevisionsRequest(ctx)
+		return nil
+	})
+
 	goweb.Map("/node/{nid}/index/{idxType}", func(ctx context.Context) error {
 		icon.IndexTypedRequest(ctx)
 		return nil
 	})
 
+	goweb.Map("/schema/{kind}/{name}", func(ctx context.Context) error {
+		scon.SchemaTypedRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/schema", func(ctx context.Context) error {
+		scon.SchemaRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/group/{name}/usage", func(ctx context.Context) error {
+		ucon.GroupUsageRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/group/{name}", func(ctx context.Context) error {
+		gcon.GroupTypedRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/group", func(ctx context.Context) error {
+		gcon.GroupRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/collection/{cid}/acl/{type}", func(ctx context.Context) error {
+		ccon.AclRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/collection/{cid}/acl/", func(ctx context.Context) error {
+		ccon.AclRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/collection/{cid}/nodes", func(ctx context.Context) error {
+		ccon.NodesRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/collection/{cid}", func(ctx context.Context) error {
+		ccon.CollectionTypedRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/collection", func(ctx context.Context) error {
+		ccon.CollectionRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/user/{uuid}/usage", func(ctx context.Context) error {
+		ucon.UserUsageRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/user/{uuid}/cache", func(ctx context.Context) error {
+		uscon.UserCacheRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/user/{uuid}", func(ctx context.Context) error {
+		uscon.UserTypedRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/user", func(ctx context.Context) error {
+		uscon.UserRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/token/{id}", func(ctx context.Context) error {
+		tcon.TokenTypedRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/token", func(ctx context.Context) error {
+		tcon.TokenRequest(ctx)
+		return nil
+	})
+
+	goweb.Map("/usage", func(ctx context.Context) error {
+		ucon.UsageRequest(ctx)
+		return nil
+	})
+
 	goweb.Map("/", func(ctx context.Context) error {
 		host := util.ApiUrl(ctx)
 		r := resource{
-			R: []string{"node"},
+			R: []string{"node", "schema", "group", "collection", "user", "token"},
 			U: host + "/",
 			D: host + "/documentation.html",
 			C: conf.Conf["admin-email"],
@@ -126,9 +244,24 @@ func main() {
 		logger.Error(err.Error())
 	}
 	user.Initialize()
+	if err := storage.Initialize(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
 	node.Initialize()
 	preauth.Initialize()
-	auth.Initialize()
+	schema.Initialize()
+	group.Initialize()
+	collection.Initialize()
+	if err := quota.Initialize(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	token.Initialize()
+	if err := auth.Initialize(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
 
 	// print conf
 	printLogo()
@@ -1,6 +1,8 @@
 package acl
 
-import ()
+import (
+	"github.com/MG-RAST/Shock/shock-server/util"
+)
 
 // Node.Acl struct
 type Acl struct {
@@ -12,6 +14,15 @@ type Acl struct {
 
 type Rights map[string]bool
 
+// GroupPrefix marks acl entries that grant access to a group of users
+// instead of a single user uuid, e.g. group:consortium
+const GroupPrefix = "group:"
+
+// GroupId returns the acl entry for the group with name
+func GroupId(name string) string {
+	return GroupPrefix + name
+}
+
 func (a *Acl) SetOwner(uuid string) {
 	a.Owner = uuid
 	return
@@ -43,15 +54,31 @@ func (a *Acl) Set(uuid string, r Rights) {
 	return
 }
 
-func (a *Acl) Check(uuid string) (r Rights) {
+// Remove removes id from all rights. A list it leaves empty would grant
+// everyone access, it is set to the owner instead.
+func (a *Acl) Remove(id string) {
+	for _, list := range []*[]string{&a.Read, &a.Write, &a.Delete} {
+		if len(*list) == 0 {
+			continue
+		}
+		if *list = del(*list, id); len(*list) == 0 {
+			*list = []string{a.Owner}
+		}
+	}
+	return
+}
+
+// Check returns the rights granted to any of ids, a user uuid followed
+// by the group entries of the user.
+func (a *Acl) Check(ids ...string) (r Rights) {
 	r = Rights{"read": false, "write": false, "delete": false}
 	acls := map[string][]string{"read": a.Read, "write": a.Write, "delete": a.Delete}
 	for k, v := range acls {
 		if len(v) == 0 {
 			r[k] = true
 		} else {
-			for _, id := range a.Read {
-				if uuid == id {
+			for _, id := range v {
+				if util.StringInSlice(id, ids) {
 					r[k] = true
 					break
 				}
@@ -0,0 +1,46 @@
+package acl_test
+
+import (
+	. "github.com/MG-RAST/Shock/shock-server/node/acl"
+	"reflect"
+	"testing"
+)
+
+var checkTests = []struct {
+	acl  Acl
+	ids  []string
+	want Rights
+}{
+	{Acl{}, []string{"u1"}, Rights{"read": true, "write": true, "delete": true}},
+	{Acl{Read: []string{"u1"}, Write: []string{"u1"}, Delete: []string{"u1"}}, []string{"u1"}, Rights{"read": true, "write": true, "delete": true}},
+	{Acl{Read: []string{"u1"}, Write: []string{"u1"}, Delete: []string{"u1"}}, []string{"u2"}, Rights{"read": false, "write": false, "delete": false}},
+	{Acl{Read: []string{"u1"}, Write: []string{"u1"}, Delete: []string{"u1"}}, nil, Rights{"read": false, "write": false, "delete": false}},
+	// group entries match any of the ids after the user uuid
+	{Acl{Read: []string{"u1", GroupId("g1")}, Write: []string{"u1"}, Delete: []string{"u1"}}, []string{"u2", GroupId("g1")}, Rights{"read": true, "write": false, "delete": false}},
+	{Acl{Read: []string{"u1", GroupId("g1")}, Write: []string{GroupId("g2")}, Delete: []string{"u1"}}, []string{"u2", GroupId("g0"), GroupId("g2")}, Rights{"read": false, "write": true, "delete": false}},
+	{Acl{Read: []string{GroupId("g1")}, Write: []string{"u1"}, Delete: []string{"u1"}}, []string{"g1"}, Rights{"read": false, "write": false, "delete": false}},
+	{Acl{Read: []string{"u1"}, Write: []string{"u1"}, Delete: []string{"u1"}}, []string{GroupId("u1")}, Rights{"read": false, "write": false, "delete": false}},
+	// an empty list grants the right to everyone
+	{Acl{Read: []string{"u1"}}, []string{"u2"}, Rights{"read": false, "write": true, "delete": true}},
+}
+
+func TestCheck(t *testing.T) {
+	for _, tt := range checkTests {
+		if got := tt.acl.Check(tt.ids...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v.Check(%v) = %v, want %v", tt.acl, tt.ids, got, tt.want)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	g := GroupId("g1")
+	a := Acl{Owner: "u1", Read: []string{"u2", g}, Write: []string{g}, Delete: nil}
+	a.Remove(g)
+	want := Acl{Owner: "u1", Read: []string{"u2"}, Write: []string{"u1"}, Delete: nil}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("got %+v, want %+v", a, want)
+	}
+	if r := a.Check(g); r["read"] || r["write"] {
+		t.Errorf("removed group still has rights %v", r)
+	}
+}
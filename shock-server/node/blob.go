@@ -0,0 +1,303 @@
+package node
+
+import (
+	"code.google.com/p/go-uuid/uuid"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/db"
+	"github.com/MG-RAST/Shock/shock-server/storage"
+	"io"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"math/rand"
+	"os"
+)
+
+// Blob is a data file stored once per distinct content and shared by
+// every node with that content, node.File.Blob holds its id. Refs counts
+// the nodes referencing it. Every stored copy gets a new id, so the data
+// of a blob being removed is never referenced again.
+type Blob struct {
+	Id     string `bson:"id" json:"id"`
+	Md5    string `bson:"md5" json:"md5"`
+	Sha256 string `bson:"sha256" json:"sha256"`
+	Store  string `bson:"store" json:"store"`
+	Size   int64  `bson:"size" json:"size"`
+	Refs   int    `bson:"refs" json:"refs"`
+}
+
+func blobKey(store string, id string) string {
+	key := fmt.Sprintf("blobs/%s/%s/%s", id[0:2], id[2:4], id)
+	if storage.IsLocal(store) {
+		return conf.Conf["data-path"] + "/" + key
+	}
+	return key
+}
+
+func blobCollection(session *mgo.Session) *mgo.Collection {
+	return session.DB(conf.Conf["mongodb-database"]).C("Blobs")
+}
+
+// LoadBlob returns the blob record for md5
+func LoadBlob(md5 string) (b *Blob, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	b = new(Blob)
+	if err = blobCollection(session).Find(bson.M{"md5": md5}).One(b); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// setBlob makes the file at path the node data. If a blob with the
+// same content exists the file is discarded and the blob referenced instead,
+// otherwise the file is stored as a new blob. Content is matched on md5,
+// size and sha256: a blob with the same md5 but another size or sha256 is
+// not the same content, the file is refused rather than linked to it.
+//
+// The unique md5 index of the Blobs collection decides between uploads of
+// the same content, the data is stored before a record is claimed for it
+// so nothing is locked while it is uploaded.
+func (node *Node) setBlob(path string, md5 string, size int64) (err error) {
+	if md5 == "" {
+		return errors.New("blob requires an md5 checksum")
+	}
+	sum, err := sha256File(path)
+	if err != nil {
+		return
+	}
+
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := blobCollection(session)
+
+	// data stored under a new id that no record claims
+	var stored *Blob
+	defer func() {
+		if stored != nil {
+			storage.Get(stored.Store).Delete(blobKey(stored.Store, stored.Id))
+		}
+	}()
+	for {
+		b := Blob{}
+		err = c.Find(bson.M{"md5": md5}).One(&b)
+		if err == nil {
+			if b.Refs <= 0 {
+				// removal of the blob was interrupted, finish it
+				if err = removeBlob(c, b.Id); err != nil {
+					return
+				}
+				continue
+			}
+			if b.Size != size || b.Sha256 != sum {
+				return fmt.Errorf("data does not match the stored blob with md5 %s", md5)
+			}
+			if err = addRef(c, &b); err == mgo.ErrNotFound {
+				// the last reference was dropped meanwhile
+				continue
+			} else if err != nil {
+				return
+			}
+			if stored == nil {
+				os.Remove(path)
+			}
+			node.useBlob(&b)
+			return nil
+		} else if err != mgo.ErrNotFound {
+			return
+		}
+
+		if stored == nil {
+			nb := Blob{Id: md5 + "-" + uuid.New(), Md5: md5, Sha256: sum, Store: storage.DefaultName, Size: size, Refs: 1}
+			if err = storage.Get(nb.Store).Put(blobKey(nb.Store, nb.Id), path); err != nil {
+				return
+			}
+			stored = &nb
+		}
+		if err = c.Insert(stored); err == nil {
+			node.useBlob(stored)
+			stored = nil
+			return nil
+		} else if !mgo.IsDup(err) {
+			return
+		}
+		// another upload of the same content claimed the md5 first
+	}
+}
+
+// useBlob points the node data at blob b
+func (node *Node) useBlob(b *Blob) {
+	node.File.Blob = b.Id
+	node.File.Store = b.Store
+	node.File.Path = ""
+}
+
+// addRef adds a reference to blob b, unless it has none left and is
+// being removed, then the error is mgo.ErrNotFound. b is updated to
+// the current record.
+func addRef(c *mgo.Collection, b *Blob) (err error) {
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"refs": 1}}, ReturnNew: true}
+	_, err = c.Find(bson.M{"id": b.Id, "refs": bson.M{"$gt": 0}}).Apply(change, b)
+	return
+}
+
+// removeBlob removes a blob no node references. The record goes first so
+// the blob can not be referenced again while its data is deleted.
+func removeBlob(c *mgo.Collection, id string) (err error) {
+	b := Blob{}
+	if _, err = c.Find(bson.M{"id": id, "refs": bson.M{"$lte": 0}}).Apply(mgo.Change{Remove: true}, &b); err != nil {
+		if err == mgo.ErrNotFound {
+			// removed by someone else
+			return nil
+		}
+		return
+	}
+	return storage.Get(b.Store).Delete(blobKey(b.Store, b.Id))
+}
+
+// sha256File returns the hex sha256 checksum of the file at path
+func sha256File(path string) (sum string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// refBlob adds a reference to the blob of node n for this node
+func (node *Node) refBlob(n *Node) (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	b := Blob{Id: n.File.Blob}
+	if err = addRef(blobCollection(session), &b); err != nil {
+		if err == mgo.ErrNotFound {
+			return errors.New("data of node " + n.Id + " has been deleted")
+		}
+		return
+	}
+	node.useBlob(&b)
+	return
+}
+
+// releaseBlob drops the node's reference to its blob, removing the
+// blob data once no node references it.
+func (node *Node) releaseBlob() (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := blobCollection(session)
+
+	b := Blob{}
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"refs": -1}}, ReturnNew: true}
+	if _, err = c.Find(bson.M{"id": node.File.Blob}).Apply(change, &b); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return
+	}
+	if b.Refs > 0 {
+		return
+	}
+	return removeBlob(c, b.Id)
+}
+
+// ownsLegacyData is true for nodes whose data file predates the blob store
+func (node *Node) ownsLegacyData() bool {
+	return node.HasFile() && !node.File.Virtual && node.File.Path == "" && node.File.Blob == ""
+}
+
+// adoptBlob moves a pre blob store data file into the blob store,
+// together with any copy_data nodes that point at it by path.
+func (node *Node) adoptBlob() (err error) {
+	md5 := node.File.Checksum["md5"]
+	if md5 == "" {
+		return errors.New("node data has no md5 checksum")
+	}
+	key := node.DataKey()
+	copiedNodes := Nodes{}
+	if _, err = dbFind(bson.M{"file.path": key}, &copiedNodes, nil); err != nil {
+		return
+	}
+
+	from, oldKey := node.Store(), key
+	if !storage.IsLocal(node.File.Store) {
+		// fetch remote data so it can be stored under the blob key
+		if key, err = fetchTemp(from, oldKey); err != nil {
+			return
+		}
+	}
+	if err = node.setBlob(key, md5, node.File.Size); err != nil {
+		return
+	}
+	if err = node.Save(); err != nil {
+		return
+	}
+	for _, n := range copiedNodes {
+		if err = n.refBlob(node); err != nil {
+			return
+		}
+		if err = n.Save(); err != nil {
+			return
+		}
+	}
+	if oldKey != key {
+		return from.Delete(oldKey)
+	}
+	return
+}
+
+// fetchTemp copies the data under key to a temporary local file
+func fetchTemp(d storage.Driver, key string) (path string, err error) {
+	r, err := d.Get(key)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	path = fmt.Sprintf("%s/temp/%d%d", conf.Conf["data-path"], rand.Int(), rand.Int())
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, r); err != nil {
+		os.Remove(path)
+	}
+	return
+}
+
+// moveBlob transfers the data of blob id to the named store and points
+// all referencing nodes at it.
+func moveBlob(id string, name string) (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := blobCollection(session)
+
+	b := Blob{}
+	if err = c.Find(bson.M{"id": id}).One(&b); err != nil {
+		return
+	}
+	if b.Store == name || storage.IsLocal(b.Store) && storage.IsLocal(name) {
+		return
+	}
+	from, to := storage.Get(b.Store), storage.Get(name)
+	tmpPath := fmt.Sprintf("%s/temp/%d%d", conf.Conf["data-path"], rand.Int(), rand.Int())
+	if err = storage.Copy(from, blobKey(b.Store, id), to, blobKey(name, id), tmpPath); err != nil {
+		return
+	}
+	// only if the blob was neither removed nor moved during the copy
+	if err = c.Update(bson.M{"id": id, "store": b.Store}, bson.M{"$set": bson.M{"store": name}}); err != nil {
+		to.Delete(blobKey(name, id))
+		return
+	}
+	nodes := session.DB(conf.Conf["mongodb-database"]).C("Nodes")
+	if _, err = nodes.UpdateAll(bson.M{"file.blob": id}, bson.M{"$set": bson.M{"file.store": name}}); err != nil {
+		return
+	}
+	return from.Delete(blobKey(b.Store, id))
+}
@@ -0,0 +1,112 @@
+package node
+
+import (
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/collection"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/node/acl"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"labix.org/v2/mgo/bson"
+)
+
+// loadCollections returns the collections with ids
+var loadCollections = collection.LoadAll
+
+// Rights returns the rights ids, a user uuid followed by its group acl
+// entries, hold on the node: those granted by the node acl and, unless
+// the node does not inherit, by the acls of its collections.
+func (node *Node) Rights(ids ...string) (r acl.Rights, err error) {
+	r = node.Acl.Check(ids...)
+	if node.NoInherit || len(node.Collections) == 0 {
+		return
+	}
+	cs, err := loadCollections(node.Collections)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cs {
+		for k, v := range c.Rights(ids...) {
+			if v {
+				r[k] = true
+			}
+		}
+	}
+	return
+}
+
+// CheckRight returns an error unless u may perform an operation needing
+// right on the node
+func (node *Node) CheckRight(u *user.User, right string) error {
+	if u.Admin || node.Acl.Owner == u.Uuid {
+		return nil
+	}
+	rights, err := node.Rights(u.Ids()...)
+	if err != nil {
+		return err
+	}
+	if rights[right] {
+		return nil
+	}
+	return errors.New(e.UnAuth)
+}
+
+// ReadableBy returns the conditions, to be combined with $or, selecting
+// the nodes u can read: public nodes, nodes granting read to the user or
+// one of its groups and nodes inheriting read from a collection.
+func ReadableBy(u *user.User) (q []bson.M, err error) {
+	q = []bson.M{bson.M{"acl.read": []string{}}, bson.M{"acl.read": bson.M{"$in": u.Ids()}}, bson.M{"acl.owner": u.Uuid}}
+	cids, err := collection.Readable(u.Ids())
+	if err != nil {
+		return nil, err
+	}
+	if len(cids) > 0 {
+		q = append(q, bson.M{"collections": bson.M{"$in": cids}, "noinherit": bson.M{"$ne": true}})
+	}
+	return
+}
+
+// AddToCollection makes the node a member of collection cid
+func (node *Node) AddToCollection(cid string) (err error) {
+	for _, id := range node.Collections {
+		if id == cid {
+			return
+		}
+	}
+	node.Collections = append(node.Collections, cid)
+	return node.Save()
+}
+
+// RemoveFromCollection ends the membership of the node in collection cid
+func (node *Node) RemoveFromCollection(cid string) (err error) {
+	ids := []string{}
+	for _, id := range node.Collections {
+		if id != cid {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == len(node.Collections) {
+		return
+	}
+	node.Collections = ids
+	return node.Save()
+}
+
+// SetInherit sets whether the node inherits the acls of its collections
+func (node *Node) SetInherit(inherit bool) (err error) {
+	node.NoInherit = !inherit
+	return node.Save()
+}
+
+// ClearCollection removes all nodes from collection cid
+func ClearCollection(cid string) (err error) {
+	nodes := Nodes{}
+	if err = nodes.GetAll(bson.M{"collections": cid}); err != nil {
+		return
+	}
+	for _, n := range nodes {
+		if err = n.RemoveFromCollection(cid); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,63 @@
+package node_test
+
+import (
+	"github.com/MG-RAST/Shock/shock-server/collection"
+	. "github.com/MG-RAST/Shock/shock-server/node"
+	"github.com/MG-RAST/Shock/shock-server/node/acl"
+	"reflect"
+	"testing"
+)
+
+var (
+	none   = acl.Rights{"read": false, "write": false, "delete": false}
+	read   = acl.Rights{"read": true, "write": false, "delete": false}
+	all    = acl.Rights{"read": true, "write": true, "delete": true}
+	shared = acl.Acl{Owner: "u1", Read: []string{"u1"}, Write: []string{"u1"}, Delete: []string{"u1"}}
+)
+
+var rightsTests = []struct {
+	name        string
+	acl         acl.Acl
+	noInherit   bool
+	collections []string
+	ids         []string
+	want        acl.Rights
+}{
+	{"owner", shared, false, nil, []string{"u1"}, all},
+	{"other user", shared, false, nil, []string{"u2"}, none},
+	{"public node", acl.Acl{Owner: "u1"}, false, nil, []string{"u2"}, all},
+	{"inherited read", shared, false, []string{"c1"}, []string{"u2"}, read},
+	{"inherited from any collection", shared, false, []string{"c0", "c1"}, []string{"u2"}, read},
+	{"no inherit", shared, true, []string{"c1"}, []string{"u2"}, none},
+	{"empty collection acl", shared, false, []string{"c0"}, []string{"u2"}, none},
+	{"inherited group", shared, false, []string{"c1"}, []string{"u3", acl.GroupId("g1")}, all},
+	{"collection owner", shared, false, []string{"c1"}, []string{"u4"}, all},
+	{"unknown collection", shared, false, []string{"c9"}, []string{"u2"}, none},
+}
+
+func TestRights(t *testing.T) {
+	collections := map[string]collection.Collection{
+		"c0": {Id: "c0"},
+		"c1": {Id: "c1", Acl: acl.Acl{Owner: "u4", Read: []string{"u4", "u2", acl.GroupId("g1")}, Write: []string{"u4", acl.GroupId("g1")}, Delete: []string{acl.GroupId("g1")}}},
+	}
+	load := *LoadCollections
+	defer func() { *LoadCollections = load }()
+	*LoadCollections = func(ids []string) ([]collection.Collection, error) {
+		cs := []collection.Collection{}
+		for _, id := range ids {
+			if c, ok := collections[id]; ok {
+				cs = append(cs, c)
+			}
+		}
+		return cs, nil
+	}
+	for _, tt := range rightsTests {
+		n := &Node{Acl: tt.acl, NoInherit: tt.noInherit, Collections: tt.collections}
+		got, err := n.Rights(tt.ids...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -4,10 +4,16 @@ import (
 	"errors"
 	"github.com/MG-RAST/Shock/shock-server/conf"
 	"github.com/MG-RAST/Shock/shock-server/db"
+	dbquery "github.com/MG-RAST/Shock/shock-server/db/query"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/quota"
 	"io/ioutil"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Initialize creates a copy of the mongodb connection and then uses that connection to
@@ -18,22 +24,125 @@ func Initialize() {
 	defer session.Close()
 	c := session.DB(conf.Conf["mongodb-database"]).C("Nodes")
 	c.EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
+	c.EnsureIndex(mgo.Index{Key: []string{"collections"}})
+	blobCollection(session).EnsureIndex(mgo.Index{Key: []string{"md5"}, Unique: true})
+	blobCollection(session).EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
+	setQueryDates(c)
+	compactRevisions(c)
 }
 
-func dbDelete(q bson.M) (err error) {
+// compactRevisions drops revisions beyond the configured cap
+func compactRevisions(c *mgo.Collection) {
+	max := maxRevisions()
+	if max == 0 {
+		return
+	}
+	n := struct {
+		Id        string     `bson:"id"`
+		Revisions []Revision `bson:"revisions"`
+	}{}
+	iter := c.Find(bson.M{"revisions." + strconv.Itoa(max): bson.M{"$exists": true}}).Select(bson.M{"id": 1, "revisions": 1}).Iter()
+	for iter.Next(&n) {
+		c.Update(bson.M{"id": n.Id}, bson.M{"$set": bson.M{"revisions": compact(n.Revisions)}})
+		n.Revisions = nil
+	}
+	iter.Close()
+}
+
+// setQueryDates fills the created and modified dates used by queries
+// for nodes saved before they were recorded.
+func setQueryDates(c *mgo.Collection) {
+	n := Node{}
+	iter := c.Find(bson.M{"created": bson.M{"$exists": false}}).Select(bson.M{"id": 1, "created_on": 1, "last_modified": 1}).Iter()
+	for iter.Next(&n) {
+		set := bson.M{}
+		if t, err := time.Parse(time.UnixDate, n.CreatedOn); err == nil {
+			set["created"] = t
+		}
+		if t, err := time.Parse(time.UnixDate, n.LastModified); err == nil {
+			set["modified"] = t
+		}
+		if len(set) > 0 {
+			c.Update(bson.M{"id": n.Id}, bson.M{"$set": set})
+		}
+		n = Node{}
+	}
+	iter.Close()
+}
+
+// the fields of a stored node its quota usage is counted from
+var chargeFields = bson.M{"acl.owner": 1, "file.size": 1, "parts_size": 1}
+
+// recharge moves the quota usage of a node from the stored node old, nil
+// if there was none, to n, nil if it was deleted
+func recharge(old *Node, n *Node) (err error) {
+	if old != nil {
+		if err = quota.Add(old.Acl.Owner, -old.File.Size-old.PartsSize, -1); err != nil {
+			return
+		}
+	}
+	if n != nil {
+		err = quota.Add(n.Acl.Owner, n.File.Size+n.PartsSize, 1)
+	}
+	return
+}
+
+// dbDelete removes the node with id
+func dbDelete(id string) (err error) {
 	session := db.Connection.Session.Copy()
 	defer session.Close()
 	c := session.DB(conf.Conf["mongodb-database"]).C("Nodes")
-	_, err = c.RemoveAll(q)
-	return
+	old := &Node{}
+	if _, err = c.Find(bson.M{"id": id}).Select(chargeFields).Apply(mgo.Change{Remove: true}, old); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return
+	}
+	return recharge(old, nil)
 }
 
 func dbUpsert(n *Node) (err error) {
 	session := db.Connection.Session.Copy()
 	defer session.Close()
 	c := session.DB(conf.Conf["mongodb-database"]).C("Nodes")
-	_, err = c.Upsert(bson.M{"id": n.Id}, &n)
-	return
+	old := &Node{}
+	info, err := c.Find(bson.M{"id": n.Id}).Select(chargeFields).Apply(mgo.Change{Update: n, Upsert: true}, old)
+	if err != nil {
+		return
+	}
+	if info.Updated == 0 {
+		old = nil
+	}
+	return recharge(old, n)
+}
+
+// dbUpdateVersion replaces the node only while the stored node is at
+// version prev, returning VersionMismatch otherwise.
+func dbUpdateVersion(n *Node, prev string) (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := session.DB(conf.Conf["mongodb-database"]).C("Nodes")
+	old := &Node{}
+	if _, err = c.Find(bson.M{"id": n.Id, "version": prev}).Select(chargeFields).Apply(mgo.Change{Update: n}, old); err == mgo.ErrNotFound {
+		return errors.New(e.VersionMismatch)
+	} else if err != nil {
+		return
+	}
+	return recharge(old, n)
+}
+
+// dbIncPartsSize adds to the size of the parts of a partial upload
+// received so far, accounted for in quotas until the file is set.
+func dbIncPartsSize(id string, size int64) (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := session.DB(conf.Conf["mongodb-database"]).C("Nodes")
+	n := &Node{}
+	if _, err = c.Find(bson.M{"id": id}).Select(chargeFields).Apply(mgo.Change{Update: bson.M{"$inc": bson.M{"parts_size": size}}}, n); err != nil {
+		return
+	}
+	return quota.Add(n.Acl.Owner, size, 0)
 }
 
 func dbFind(q bson.M, results *Nodes, options map[string]int) (count int, err error) {
@@ -56,13 +165,90 @@ func dbFind(q bson.M, results *Nodes, options map[string]int) (count int, err er
 	return
 }
 
-func Load(id string, uuid string) (n *Node, err error) {
+// dbFindPage returns one page of the nodes matching q sorted by order
+// (mongodb sort keys, natural order if empty). A selector limits the
+// fields returned, results must then be a slice of bson.M.
+func dbFindPage(q bson.M, results interface{}, selector bson.M, limit int, offset int, order ...string) (count int, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := session.DB(conf.Conf["mongodb-database"]).C("Nodes")
+	query := c.Find(q)
+	if count, err = query.Count(); err != nil {
+		return 0, err
+	}
+	if len(order) > 0 {
+		query = query.Sort(order...)
+	}
+	if selector != nil {
+		query = query.Select(selector)
+	}
+	err = query.Limit(limit).Skip(offset).All(results)
+	return
+}
+
+func dbCount(q bson.M) (int, error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	return session.DB(conf.Conf["mongodb-database"]).C("Nodes").Find(q).Count()
+}
+
+// dbFindAfter returns up to limit nodes matching q that follow cursor c,
+// in the cursor's order. Nothing is skipped or counted so the cost of a
+// page does not grow with its position.
+func dbFindAfter(q bson.M, results interface{}, selector bson.M, limit int, c *dbquery.Cursor) (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	coll := session.DB(conf.Conf["mongodb-database"]).C("Nodes")
+	if c.Id != "" {
+		q = bson.M{"$and": []bson.M{q, c.After()}}
+	}
+	query := coll.Find(q).Sort(c.Order...).Limit(limit)
+	if selector != nil {
+		query = query.Select(selector)
+	}
+	return query.All(results)
+}
+
+// dbCursorAfter returns the cursor following node id, the last node of a page
+func dbCursorAfter(c *dbquery.Cursor, id string) (next *dbquery.Cursor, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	coll := session.DB(conf.Conf["mongodb-database"]).C("Nodes")
+	next = &dbquery.Cursor{Order: c.Order, Id: id, Count: c.Count}
+	field, _ := c.Field()
+	if field == "id" {
+		return
+	}
+	doc := bson.M{}
+	if err = coll.Find(bson.M{"id": id}).Select(bson.M{field: 1}).One(&doc); err != nil {
+		return nil, err
+	}
+	// walk the dotted path to the sort value
+	var v interface{} = doc
+	for _, key := range strings.Split(field, ".") {
+		m, ok := v.(bson.M)
+		if !ok {
+			v = nil
+			break
+		}
+		v = m[key]
+	}
+	next.Value = v
+	return
+}
+
+// Load returns the node with id if it can be read by ids, the uuid of a
+// user followed by its group acl entries, directly or through a collection.
+func Load(id string, ids ...string) (n *Node, err error) {
 	session := db.Connection.Session.Copy()
 	defer session.Close()
 	c := session.DB(conf.Conf["mongodb-database"]).C("Nodes")
 	n = new(Node)
 	if err = c.Find(bson.M{"id": id}).One(&n); err == nil {
-		rights := n.Acl.Check(uuid)
+		rights, err := n.Rights(ids...)
+		if err != nil {
+			return nil, err
+		}
 		if !rights["read"] {
 			return nil, errors.New("User Unauthorized")
 		}
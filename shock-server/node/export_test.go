@@ -0,0 +1,4 @@
+package node
+
+// exported for the tests of node_test
+var LoadCollections = &loadCollections
@@ -0,0 +1,160 @@
+// Package archive decompresses uploaded data files
+package archive
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	Gzip  = "gzip"
+	Bzip2 = "bzip2"
+	Zip   = "zip"
+)
+
+var ErrUnknownFormat = errors.New("unknown compression format, must be one of: gzip, bzip2, zip")
+
+var ErrTooLarge = errors.New("decompressed file exceeds the size limit")
+
+var exts = map[string][]string{
+	Gzip:  {".gz", ".gzip"},
+	Bzip2: {".bz2", ".bzip2"},
+	Zip:   {".zip"},
+}
+
+var magic = map[string][]byte{
+	Gzip:  {0x1f, 0x8b},
+	Bzip2: []byte("BZh"),
+	Zip:   []byte("PK\x03\x04"),
+}
+
+// IsValid returns true if format is a supported compression format
+func IsValid(format string) bool {
+	_, ok := exts[format]
+	return ok
+}
+
+// Detect returns the compression format of r from its leading bytes,
+// or an empty string if the data is not compressed.
+func Detect(r *bufio.Reader) string {
+	head, _ := r.Peek(4)
+	for format, m := range magic {
+		if bytes.HasPrefix(head, m) {
+			return format
+		}
+	}
+	return ""
+}
+
+// TrimExt removes a compressed file extension from name
+func TrimExt(name string) string {
+	for _, list := range exts {
+		for _, ext := range list {
+			if strings.HasSuffix(strings.ToLower(name), ext) {
+				return name[:len(name)-len(ext)]
+			}
+		}
+	}
+	return name
+}
+
+// DecompressFile writes the decompressed content of src to dst. format
+// may be "auto" to detect it from the content. It returns the name of
+// the decompressed file, if the archive records one, and its md5. Content
+// larger than limit bytes, unless limit is 0, fails with ErrTooLarge.
+func DecompressFile(format string, src string, dst string, limit int64) (name string, sum string, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	var r io.Reader
+	br := bufio.NewReader(in)
+	if format == "auto" {
+		if format = Detect(br); format == "" {
+			return "", "", errors.New("file is not compressed with gzip, bzip2 or zip")
+		}
+	}
+	switch format {
+	case Gzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return "", "", err
+		}
+		defer gr.Close()
+		if gr.Name != "" {
+			name = filepath.Base(gr.Name)
+		}
+		r = gr
+	case Bzip2:
+		r = bzip2.NewReader(br)
+	case Zip:
+		fi, err := in.Stat()
+		if err != nil {
+			return "", "", err
+		}
+		zf, err := singleEntry(in, fi.Size())
+		if err != nil {
+			return "", "", err
+		}
+		zr, err := zf.Open()
+		if err != nil {
+			return "", "", err
+		}
+		defer zr.Close()
+		name, r = filepath.Base(zf.Name), zr
+	default:
+		return "", "", ErrUnknownFormat
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	md5h := md5.New()
+	n, err := io.Copy(io.MultiWriter(out, md5h), r)
+	if err == nil && limit > 0 && n > limit {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		os.Remove(dst)
+		return "", "", err
+	}
+	return name, fmt.Sprintf("%x", md5h.Sum(nil)), nil
+}
+
+// singleEntry returns the only file in a zip archive
+func singleEntry(r io.ReaderAt, size int64) (f *zip.File, err error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return
+	}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		if f != nil {
+			return nil, errors.New("zip archive must contain a single file")
+		}
+		f = zf
+	}
+	if f == nil {
+		return nil, errors.New("zip archive is empty")
+	}
+	return
+}
@@ -0,0 +1,127 @@
+package archive_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	. "github.com/MG-RAST/Shock/shock-server/node/file/archive"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var content = []byte("ACGT\n")
+
+// printf 'ACGT\n' | bzip2
+var bzip2Content = []byte{0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x0e, 0xd5, 0x64, 0xfe, 0x00, 0x00, 0x00, 0xc6, 0x00, 0x00, 0x10, 0x28, 0x80, 0x04, 0x00, 0x20, 0x00, 0x21, 0x83, 0x41, 0x9a, 0x02, 0x5c, 0x71, 0x77, 0x24, 0x53, 0x85, 0x09, 0x00, 0xed, 0x56, 0x4f, 0xe0}
+
+func gzipContent(name string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Name = name
+	w.Write(content)
+	w.Close()
+	return b.Bytes()
+}
+
+func zipContent(names ...string) []byte {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, name := range names {
+		f, _ := w.Create(name)
+		f.Write(content)
+	}
+	w.Close()
+	return b.Bytes()
+}
+
+func TestDecompressFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sum := fmt.Sprintf("%x", md5.Sum(content))
+
+	tests := []struct {
+		format string
+		data   []byte
+		name   string
+		ok     bool
+	}{
+		{"gzip", gzipContent(""), "", true},
+		{"auto", gzipContent("reads.fa"), "reads.fa", true},
+		{"gzip", gzipContent("../../reads.fa"), "reads.fa", true},
+		{"bzip2", bzip2Content, "", true},
+		{"auto", bzip2Content, "", true},
+		{"zip", zipContent("dir/reads.fq"), "reads.fq", true},
+		{"auto", zipContent("reads.fq"), "reads.fq", true},
+		{"zip", zipContent("a", "b"), "", false},
+		{"zip", zipContent(), "", false},
+		{"gzip", content, "", false},
+		{"auto", content, "", false},
+		{"xz", content, "", false},
+	}
+	for i, tt := range tests {
+		src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+		if err := ioutil.WriteFile(src, tt.data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		name, s, err := DecompressFile(tt.format, src, dst, 0)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%d. %s: expected error", i, tt.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d. %s: unexpected error: %v", i, tt.format, err)
+			continue
+		}
+		out, _ := ioutil.ReadFile(dst)
+		if !bytes.Equal(out, content) || s != sum || name != tt.name {
+			t.Errorf("%d. %s: got %q md5 %s name %q", i, tt.format, out, s, name)
+		}
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	if err := ioutil.WriteFile(src, gzipContent(""), 0666); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(content))
+	for _, limit := range []int64{size, size + 1, 0} {
+		if _, _, err := DecompressFile("gzip", src, dst, limit); err != nil {
+			t.Errorf("limit %d: unexpected error: %v", limit, err)
+		}
+	}
+	if _, _, err := DecompressFile("gzip", src, dst, size-1); err != ErrTooLarge {
+		t.Errorf("limit %d: got %v, want ErrTooLarge", size-1, err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("output of a decompression over the limit was kept")
+	}
+}
+
+func TestTrimExt(t *testing.T) {
+	tests := map[string]string{
+		"reads.fq.gz":  "reads.fq",
+		"reads.fq.BZ2": "reads.fq",
+		"reads.zip":    "reads",
+		"reads.fq":     "reads.fq",
+	}
+	for in, want := range tests {
+		if got := TrimExt(in); got != want {
+			t.Errorf("TrimExt(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
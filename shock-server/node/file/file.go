@@ -13,6 +13,8 @@ type File struct {
 	Checksum     map[string]string `bson:"checksum" json:"checksum"`
 	Format       string            `bson:"format" json:"format"`
 	Path         string            `bson:"path" json:"-"`
+	Blob         string            `bson:"blob" json:"-"`
+	Store        string            `bson:"store" json:"-"`
 	Virtual      bool              `bson:"virtual" json:"virtual"`
 	VirtualParts []string          `bson:"virtual_parts" json:"virtual_parts"`
 }
@@ -0,0 +1,172 @@
+// Package to read BED annotation files
+package bed
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/seq"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// header lines followed by a feature line with chrom, chromStart and chromEnd
+	Regex = regexp.MustCompile(`\A(?:(?:#|track|browser)[^\n]*\n)*[^\t\n#]+\t\d+\t\d+(?:[\t\r\n]|\z)`)
+)
+
+// Feature is a data line of a BED file
+type Feature struct {
+	Chrom  string
+	Start  int // 0-based
+	End    int // 0-based, exclusive
+	Name   string
+	Fields []string // the score and any following columns
+}
+
+// Parse parses a data line
+func Parse(line []byte) (f *Feature, err error) {
+	fields := strings.Split(strings.TrimRight(string(line), "\r\n"), "\t")
+	if len(fields) < 3 {
+		return nil, errors.New("invalid bed feature: fewer than 3 columns")
+	}
+	f = &Feature{Chrom: fields[0]}
+	if f.Start, err = strconv.Atoi(fields[1]); err != nil || f.Start < 0 {
+		return nil, errors.New("invalid bed feature: bad start " + fields[1])
+	}
+	if f.End, err = strconv.Atoi(fields[2]); err != nil || f.End < f.Start {
+		return nil, errors.New("invalid bed feature: bad end " + fields[2])
+	}
+	if len(fields) > 3 {
+		f.Name = fields[3]
+		f.Fields = fields[4:]
+	}
+	return f, nil
+}
+
+// Interval returns the chromosome and the 0-based, end exclusive range of
+// the feature on a data line
+func Interval(line []byte) (chrom string, begin int, end int, err error) {
+	f, err := Parse(line)
+	if err != nil {
+		return
+	}
+	return f.Chrom, f.Start, f.End, nil
+}
+
+// IsHeader returns true for track, browser, comment and blank lines
+func IsHeader(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	return len(line) == 0 || line[0] == '#' || bytes.HasPrefix(line, []byte("track")) || bytes.HasPrefix(line, []byte("browser"))
+}
+
+// Bed format reader type.
+type Reader struct {
+	f file.SectionReader
+	r *bufio.Reader
+}
+
+// Returns a new Bed format reader using f.
+func NewReader(f file.SectionReader) seq.ReadRewinder {
+	return &Reader{
+		f: f,
+		r: bufio.NewReader(f),
+	}
+}
+
+// Returns a new Bed format reader using a filename.
+func NewReaderName(name string) (r seq.ReadRewinder, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	return NewReader(f), nil
+}
+
+// readLine returns the next data line and the number of bytes read up to
+// and including it
+func (self *Reader) readLine() (line []byte, n int, err error) {
+	for {
+		read, er := self.r.ReadBytes('\n')
+		n += len(read)
+		if !IsHeader(read) {
+			return read, n, nil
+		} else if er != nil {
+			return nil, n, er
+		}
+	}
+}
+
+// Read a single feature and return it or an error. The ID is the name of
+// the feature, or chrom:start-end (1-based) if it has none, Seq the data
+// line.
+func (self *Reader) Read() (sequence *seq.Seq, err error) {
+	line, _, err := self.readLine()
+	if err != nil {
+		return
+	}
+	line = bytes.TrimRight(line, "\r\n")
+	f, err := Parse(line)
+	if err != nil {
+		return nil, err
+	}
+	id := f.Name
+	if id == "" {
+		id = fmt.Sprintf("%s:%d-%d", f.Chrom, f.Start+1, f.End)
+	}
+	return seq.New([]byte(id), line, nil), nil
+}
+
+// Read a single feature and return it or an error. (used for making record index)
+func (self *Reader) ReadRaw(p []byte) (n int, err error) {
+	line, n, err := self.readLine()
+	if err == nil {
+		copy(p, line)
+	}
+	return
+}
+
+// Read a single feature and return read offset for indexing.
+func (self *Reader) GetReadOffset() (n int, err error) {
+	_, n, err = self.readLine()
+	return
+}
+
+// Returns the length of the chunk starting at offSet, ending after the
+// last line end before conf.CHUNK_SIZE. (used for making chunkrecord index)
+func (self *Reader) SeekChunk(offSet int64) (n int64, err error) {
+	winSize := int64(32768)
+	r := io.NewSectionReader(self.f, offSet+conf.CHUNK_SIZE-winSize, winSize)
+	buf := make([]byte, winSize)
+	if n, err := r.Read(buf); err != nil {
+		return int64(n), err
+	}
+	if pos := bytes.LastIndex(buf, []byte("\n")); pos == -1 {
+		indexPos, err := self.SeekChunk(offSet + winSize)
+		return (winSize + indexPos), err
+	} else {
+		return conf.CHUNK_SIZE - winSize + int64(pos) + 1, nil
+	}
+}
+
+// Rewind the reader.
+func (self *Reader) Rewind() (err error) {
+	if s, ok := self.f.(io.Seeker); ok {
+		_, err = s.Seek(0, 0)
+		self.r = bufio.NewReader(self.f)
+	} else {
+		err = errors.New("Not a Seeker")
+	}
+	return
+}
+
+// Format a single feature, its data line
+func Format(s *seq.Seq, w io.Writer) (n int, err error) {
+	return w.Write([]byte(string(s.Seq) + "\n"))
+}
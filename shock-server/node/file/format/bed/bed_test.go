@@ -0,0 +1,49 @@
+package bed_test
+
+import (
+	. "github.com/MG-RAST/Shock/shock-server/node/file/format/bed"
+	"io"
+	"strings"
+	"testing"
+)
+
+var data = `browser position chr7:127471196-127495720
+track name="ItemRGBDemo" itemRgb="On"
+chr7	127471196	127472363	Pos1	0	+
+chr7	127472363	127473530
+chr7	127473530	127474697	Pos3	0	+	127473530	127474697	255,0,0`
+
+func TestRead(t *testing.T) {
+	if !Regex.MatchString(data) {
+		t.Errorf("Regex does not match")
+	}
+	r := NewReader(strings.NewReader(data))
+	ids := []string{}
+	for {
+		s, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, string(s.ID))
+	}
+	if got := strings.Join(ids, ","); got != "Pos1,chr7:127472364-127473530,Pos3" {
+		t.Errorf("got ids %s", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse([]byte("chr7\t127473530\t127474697\tPos3\t0\t+\n"))
+	if err != nil || f.Chrom != "chr7" || f.Start != 127473530 || f.End != 127474697 || f.Name != "Pos3" || len(f.Fields) != 2 {
+		t.Errorf("got %+v, %v", f, err)
+	}
+	for _, line := range []string{"chr7\t10\n", "chr7\t-1\t10\n", "chr7\t10\t5\n", "chr7\tx\t5\n"} {
+		if _, err := Parse([]byte(line)); err == nil {
+			t.Errorf("invalid line %q parsed", line)
+		}
+	}
+	if !IsHeader([]byte("track name=x\n")) || !IsHeader([]byte("\n")) || IsHeader([]byte("chr1\t0\t1\n")) {
+		t.Errorf("IsHeader mismatch")
+	}
+}
@@ -0,0 +1,206 @@
+// Package to read GFF3 annotation files
+package gff
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/seq"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	Regex = regexp.MustCompile(`\A##gff-version\s+3`)
+)
+
+// Feature is a data line of a GFF3 or GTF file
+type Feature struct {
+	Seqid      string
+	Source     string
+	Type       string
+	Start      int // 1-based
+	End        int // 1-based, inclusive
+	Score      string
+	Strand     string
+	Phase      string
+	Attributes string
+}
+
+// Attrs returns the attributes of a GFF3 feature, with their values
+// unescaped
+func (f *Feature) Attrs() map[string]string {
+	attrs := map[string]string{}
+	for _, field := range strings.Split(f.Attributes, ";") {
+		if i := strings.Index(field, "="); i > 0 {
+			value, err := url.QueryUnescape(field[i+1:])
+			if err != nil {
+				value = field[i+1:]
+			}
+			attrs[strings.TrimSpace(field[:i])] = value
+		}
+	}
+	return attrs
+}
+
+// Parse parses a data line of 9 tab separated columns, as shared by GFF3
+// and GTF
+func Parse(line []byte) (f *Feature, err error) {
+	fields := strings.Split(strings.TrimRight(string(line), "\r\n"), "\t")
+	if len(fields) != 9 {
+		return nil, errors.New("invalid feature: not 9 columns")
+	}
+	f = &Feature{Seqid: fields[0], Source: fields[1], Type: fields[2], Score: fields[5], Strand: fields[6], Phase: fields[7], Attributes: fields[8]}
+	if f.Start, err = strconv.Atoi(fields[3]); err != nil || f.Start < 1 {
+		return nil, errors.New("invalid feature: bad start " + fields[3])
+	}
+	if f.End, err = strconv.Atoi(fields[4]); err != nil || f.End < f.Start-1 {
+		return nil, errors.New("invalid feature: bad end " + fields[4])
+	}
+	return f, nil
+}
+
+// Interval returns the sequence and the 0-based, end exclusive range of
+// the feature on a data line
+func Interval(line []byte) (chrom string, begin int, end int, err error) {
+	f, err := Parse(line)
+	if err != nil {
+		return
+	}
+	return f.Seqid, f.Start - 1, f.End, nil
+}
+
+// IsHeader returns true for directives, comments and blank lines
+func IsHeader(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	return len(line) == 0 || line[0] == '#'
+}
+
+// Gff format reader type. Sequences in a ##FASTA section at the end of the
+// file are not read.
+type Reader struct {
+	f   file.SectionReader
+	r   *bufio.Reader
+	eof bool
+}
+
+// Returns a new Gff format reader using f.
+func NewReader(f file.SectionReader) seq.ReadRewinder {
+	return &Reader{
+		f: f,
+		r: bufio.NewReader(f),
+	}
+}
+
+// Returns a new Gff format reader using a filename.
+func NewReaderName(name string) (r seq.ReadRewinder, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	return NewReader(f), nil
+}
+
+// readLine returns the next data line and the number of bytes read up to
+// and including it
+func (self *Reader) readLine() (line []byte, n int, err error) {
+	for {
+		if self.eof {
+			return nil, n, io.EOF
+		}
+		read, er := self.r.ReadBytes('\n')
+		if bytes.HasPrefix(read, []byte("##FASTA")) || (len(read) > 0 && read[0] == '>') {
+			self.eof = true
+			return nil, n, io.EOF
+		}
+		n += len(read)
+		if !IsHeader(read) {
+			return read, n, nil
+		} else if er != nil {
+			return nil, n, er
+		}
+	}
+}
+
+// ReadFeature reads a single feature and returns it with its data line,
+// without the line end.
+func (self *Reader) ReadFeature() (f *Feature, line []byte, err error) {
+	if line, _, err = self.readLine(); err != nil {
+		return
+	}
+	line = bytes.TrimRight(line, "\r\n")
+	if f, err = Parse(line); err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+// Read a single feature and return it or an error. The ID is the ID or
+// Name attribute of the feature, Seq the data line.
+func (self *Reader) Read() (sequence *seq.Seq, err error) {
+	f, line, err := self.ReadFeature()
+	if err != nil {
+		return
+	}
+	attrs := f.Attrs()
+	id := attrs["ID"]
+	if id == "" {
+		id = attrs["Name"]
+	}
+	return seq.New([]byte(id), line, nil), nil
+}
+
+// Read a single feature and return it or an error. (used for making record index)
+func (self *Reader) ReadRaw(p []byte) (n int, err error) {
+	line, n, err := self.readLine()
+	if err == nil {
+		copy(p, line)
+	}
+	return
+}
+
+// Read a single feature and return read offset for indexing.
+func (self *Reader) GetReadOffset() (n int, err error) {
+	_, n, err = self.readLine()
+	return
+}
+
+// Returns the length of the chunk starting at offSet, ending after the
+// last line end before conf.CHUNK_SIZE. (used for making chunkrecord index)
+func (self *Reader) SeekChunk(offSet int64) (n int64, err error) {
+	winSize := int64(32768)
+	r := io.NewSectionReader(self.f, offSet+conf.CHUNK_SIZE-winSize, winSize)
+	buf := make([]byte, winSize)
+	if n, err := r.Read(buf); err != nil {
+		return int64(n), err
+	}
+	if pos := bytes.LastIndex(buf, []byte("\n")); pos == -1 {
+		indexPos, err := self.SeekChunk(offSet + winSize)
+		return (winSize + indexPos), err
+	} else {
+		return conf.CHUNK_SIZE - winSize + int64(pos) + 1, nil
+	}
+}
+
+// Rewind the reader.
+func (self *Reader) Rewind() (err error) {
+	if s, ok := self.f.(io.Seeker); ok {
+		_, err = s.Seek(0, 0)
+		self.r = bufio.NewReader(self.f)
+		self.eof = false
+	} else {
+		err = errors.New("Not a Seeker")
+	}
+	return
+}
+
+// Format a single feature, its data line
+func Format(s *seq.Seq, w io.Writer) (n int, err error) {
+	return w.Write([]byte(string(s.Seq) + "\n"))
+}
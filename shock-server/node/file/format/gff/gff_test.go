@@ -0,0 +1,60 @@
+package gff_test
+
+import (
+	. "github.com/MG-RAST/Shock/shock-server/node/file/format/gff"
+	"io"
+	"strings"
+	"testing"
+)
+
+var data = `##gff-version 3
+##sequence-region ctg123 1 1497228
+ctg123	.	gene	1000	9000	.	+	.	ID=gene00001;Name=EDEN
+ctg123	.	mRNA	1050	9000	.	+	.	ID=mRNA%3B00001;Parent=gene00001
+# comment
+ctg123	.	exon	1300	1500	.	+	.	Name=exon1;Parent=mRNA00001
+###
+##FASTA
+>ctg123
+CGATCGATCGATCGATCG
+`
+
+func TestRead(t *testing.T) {
+	r := NewReader(strings.NewReader(data))
+	for i := 0; i < 2; i++ {
+		ids := []string{}
+		for {
+			s, err := r.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, string(s.ID))
+		}
+		if got := strings.Join(ids, ","); got != "gene00001,mRNA;00001,exon1" {
+			t.Errorf("got ids %s", got)
+		}
+		if err := r.Rewind(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := r.GetReadOffset(); err != nil || n != len("##gff-version 3\n##sequence-region ctg123 1 1497228\nctg123	.	gene	1000	9000	.	+	.	ID=gene00001;Name=EDEN\n") {
+		t.Errorf("got offset %d, %v", n, err)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	chrom, begin, end, err := Interval([]byte("ctg123\t.\tgene\t1000\t9000\t.\t+\t.\tID=gene00001\n"))
+	if err != nil || chrom != "ctg123" || begin != 999 || end != 9000 {
+		t.Errorf("got %s %d %d %v", chrom, begin, end, err)
+	}
+	for _, line := range []string{"ctg123\t.\tgene\t1000\t9000\n", "ctg123\t.\tgene\t0\t9000\t.\t+\t.\t.\n", "ctg123\t.\tgene\t1000\t10\t.\t+\t.\t.\n"} {
+		if _, err := Parse([]byte(line)); err == nil {
+			t.Errorf("invalid line %q parsed", line)
+		}
+	}
+	if !Regex.MatchString(data) || Regex.MatchString("##gff-version 2\n") {
+		t.Errorf("Regex mismatch")
+	}
+}
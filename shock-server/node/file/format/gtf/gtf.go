@@ -0,0 +1,76 @@
+// Package to read GTF (GFF2) annotation files
+package gtf
+
+import (
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/gff"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/seq"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	// comment lines followed by a feature with a gene_id attribute
+	Regex = regexp.MustCompile(`\A(?:#[^\n]*\n)*[^\t\n#]+\t[^\t\n]*\t[^\t\n]+\t\d+\t\d+\t[^\t\n]+\t[+\-.?]\t[012.]\t[^\n]*gene_id "`)
+)
+
+// Attrs returns the attributes of a GTF feature, with the quotes of their
+// values removed
+func Attrs(f *gff.Feature) map[string]string {
+	attrs := map[string]string{}
+	for _, field := range strings.Split(f.Attributes, ";") {
+		field = strings.TrimSpace(field)
+		if i := strings.IndexAny(field, " \t"); i > 0 {
+			attrs[field[:i]] = strings.Trim(strings.TrimSpace(field[i+1:]), `"`)
+		}
+	}
+	return attrs
+}
+
+// Interval returns the sequence and the 0-based, end exclusive range of
+// the feature on a data line
+func Interval(line []byte) (chrom string, begin int, end int, err error) {
+	return gff.Interval(line)
+}
+
+// Gtf format reader type.
+type Reader struct {
+	*gff.Reader
+}
+
+// Returns a new Gtf format reader using f.
+func NewReader(f file.SectionReader) seq.ReadRewinder {
+	return &Reader{gff.NewReader(f).(*gff.Reader)}
+}
+
+// Returns a new Gtf format reader using a filename.
+func NewReaderName(name string) (r seq.ReadRewinder, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	return NewReader(f), nil
+}
+
+// Read a single feature and return it or an error. The ID is the
+// transcript_id of the feature, or its gene_id if it has none, Seq the
+// data line.
+func (self *Reader) Read() (sequence *seq.Seq, err error) {
+	f, line, err := self.ReadFeature()
+	if err != nil {
+		return
+	}
+	attrs := Attrs(f)
+	id := attrs["transcript_id"]
+	if id == "" {
+		id = attrs["gene_id"]
+	}
+	return seq.New([]byte(id), line, nil), nil
+}
+
+// Format a single feature, its data line
+func Format(s *seq.Seq, w io.Writer) (n int, err error) {
+	return gff.Format(s, w)
+}
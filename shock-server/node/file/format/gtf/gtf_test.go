@@ -0,0 +1,38 @@
+package gtf_test
+
+import (
+	. "github.com/MG-RAST/Shock/shock-server/node/file/format/gtf"
+	"io"
+	"strings"
+	"testing"
+)
+
+var data = `#!genome-build GRCh38
+1	havana	gene	11869	14409	.	+	.	gene_id "ENSG00000223972"; gene_name "DDX11L1";
+1	havana	transcript	11869	14409	.	+	.	gene_id "ENSG00000223972"; transcript_id "ENST00000456328";
+1	havana	exon	11869	12227	.	+	.	gene_id "ENSG00000223972"; transcript_id "ENST00000456328"; exon_number "1";
+`
+
+func TestRead(t *testing.T) {
+	if !Regex.MatchString(data) {
+		t.Errorf("Regex does not match")
+	}
+	r := NewReader(strings.NewReader(data))
+	ids := []string{}
+	for {
+		s, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, string(s.ID))
+	}
+	if got := strings.Join(ids, ","); got != "ENSG00000223972,ENST00000456328,ENST00000456328" {
+		t.Errorf("got ids %s", got)
+	}
+	chrom, begin, end, err := Interval([]byte("1\thavana\texon\t11869\t12227\t.\t+\t.\tgene_id \"x\";\n"))
+	if err != nil || chrom != "1" || begin != 11868 || end != 12227 {
+		t.Errorf("got %s %d %d %v", chrom, begin, end, err)
+	}
+}
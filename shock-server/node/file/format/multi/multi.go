@@ -1,29 +1,47 @@
-// Package to read and auto-detect format of fasta & fastq files
+// Package to read and auto-detect format of sequence and annotation files
 package multi
 
 import (
 	"errors"
 	e "github.com/MG-RAST/Shock/shock-server/errors"
 	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/bed"
 	"github.com/MG-RAST/Shock/shock-server/node/file/format/fasta"
 	"github.com/MG-RAST/Shock/shock-server/node/file/format/fastq"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/gff"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/gtf"
 	"github.com/MG-RAST/Shock/shock-server/node/file/format/sam"
 	"github.com/MG-RAST/Shock/shock-server/node/file/format/seq"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/vcf"
 	"io"
 	"regexp"
 )
 
 //the order matters as it determines the order for checking format.
-var validators = map[string]*regexp.Regexp{
-	"fasta": fasta.Regex,
-	"fastq": fastq.Regex,
-	"sam":   sam.Regex,
+//annotation files are checked first, their patterns are anchored at the
+//start of the file while sam matches most text. vcf data lines may also
+//look like bed.
+var validators = []struct {
+	format string
+	re     *regexp.Regexp
+}{
+	{"vcf", vcf.Regex},
+	{"gff", gff.Regex},
+	{"gtf", gtf.Regex},
+	{"bed", bed.Regex},
+	{"fasta", fasta.Regex},
+	{"fastq", fastq.Regex},
+	{"sam", sam.Regex},
 }
 
 var readers = map[string]func(f file.SectionReader) seq.ReadRewinder{
 	"fasta": fasta.NewReader,
 	"fastq": fastq.NewReader,
 	"sam":   sam.NewReader,
+	"bed":   bed.NewReader,
+	"gff":   gff.NewReader,
+	"gtf":   gtf.NewReader,
+	"vcf":   vcf.NewReader,
 }
 
 type Reader struct {
@@ -47,20 +65,30 @@ func (r *Reader) DetermineFormat() error {
 
 	reader := io.NewSectionReader(r.f, 0, 32768)
 	buf := make([]byte, 32768)
-	if _, err := reader.Read(buf); err != nil && err != io.EOF {
+	n, err := reader.Read(buf)
+	if err != nil && err != io.EOF {
 		return err
 	}
+	buf = buf[:n]
 
-	for format, re := range validators {
-		if re.Match(buf) {
-			r.format = format
-			r.r = readers[format](r.f)
+	for _, v := range validators {
+		if v.re.Match(buf) {
+			r.format = v.format
+			r.r = readers[v.format](r.f)
 			return nil
 		}
 	}
 	return errors.New(e.InvalidFileTypeForFilter)
 }
 
+// Type returns the detected format of the file
+func (r *Reader) Type() (string, error) {
+	if err := r.DetermineFormat(); err != nil {
+		return "", err
+	}
+	return r.format, nil
+}
+
 func (r *Reader) Read() (*seq.Seq, error) {
 	if r.r == nil {
 		err := r.DetermineFormat()
@@ -109,6 +137,14 @@ func (r *Reader) Format(s *seq.Seq, w io.Writer) (n int, err error) {
 		return fastq.Format(s, w)
 	case r.format == "sam":
 		return sam.Format(s, w)
+	case r.format == "bed":
+		return bed.Format(s, w)
+	case r.format == "gff":
+		return gff.Format(s, w)
+	case r.format == "gtf":
+		return gtf.Format(s, w)
+	case r.format == "vcf":
+		return vcf.Format(s, w)
 	}
 	return 0, errors.New("unknown sequence format")
 }
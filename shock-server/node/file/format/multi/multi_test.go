@@ -0,0 +1,31 @@
+package multi_test
+
+import (
+	. "github.com/MG-RAST/Shock/shock-server/node/file/format/multi"
+	"strings"
+	"testing"
+)
+
+func TestDetermineFormat(t *testing.T) {
+	for _, test := range []struct {
+		data   string
+		format string
+	}{
+		{">seq1 desc\nACGTACGT\n", "fasta"},
+		{"@seq1\nACGT\n+\nIIII\n", "fastq"},
+		{"@HD\tVN:1.0\tSO:coordinate\nr001\t99\tref\t7\t30\t8M\t=\t37\t39\tTTAGATAA\t*\n", "sam"},
+		{"##gff-version 3\nctg123\t.\tgene\t1000\t9000\t.\t+\t.\tID=gene1\n##FASTA\n>ctg123\nACGT\n", "gff"},
+		{"1\tHAVANA\tgene\t11869\t14409\t.\t+\t.\tgene_id \"ENSG1\";\n", "gtf"},
+		{"track name=test\nchr1\t0\t100\tFeature1\n", "bed"},
+		{"chr1\t0\t100", "bed"},
+		{"##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n20\t14370\t100\tG\tA\t29\tPASS\t.\n", "vcf"},
+	} {
+		format, err := NewReader(strings.NewReader(test.data)).Type()
+		if err != nil || format != test.format {
+			t.Errorf("%q: got %s, %v, want %s", test.data, format, err, test.format)
+		}
+	}
+	if _, err := NewReader(strings.NewReader("\x00\x01")).Type(); err == nil {
+		t.Errorf("binary data detected")
+	}
+}
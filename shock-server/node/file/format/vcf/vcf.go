@@ -0,0 +1,201 @@
+// Package to read VCF variant files
+package vcf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/seq"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	Regex = regexp.MustCompile(`^##fileformat=VCFv\d`)
+)
+
+// Record is a variant, a data line of a VCF file
+type Record struct {
+	Chrom   string
+	Pos     int // 1-based
+	Id      string
+	Ref     string
+	Alt     []string
+	Qual    string
+	Filter  string
+	Info    string
+	Samples []string // FORMAT and the sample columns
+}
+
+// End returns the 1-based, inclusive end of the variant on the
+// reference: the END info field if present, else the end of the ref allele
+func (r *Record) End() int {
+	for _, field := range strings.Split(r.Info, ";") {
+		if strings.HasPrefix(field, "END=") {
+			if end, err := strconv.Atoi(field[4:]); err == nil && end >= r.Pos {
+				return end
+			}
+		}
+	}
+	if len(r.Ref) == 0 {
+		return r.Pos
+	}
+	return r.Pos + len(r.Ref) - 1
+}
+
+// Parse parses a data line
+func Parse(line []byte) (r *Record, err error) {
+	fields := strings.Split(strings.TrimRight(string(line), "\r\n"), "\t")
+	if len(fields) < 8 {
+		return nil, errors.New("invalid vcf record: fewer than 8 columns")
+	}
+	r = &Record{Chrom: fields[0], Id: fields[2], Ref: fields[3], Qual: fields[5], Filter: fields[6], Info: fields[7], Samples: fields[8:]}
+	if r.Pos, err = strconv.Atoi(fields[1]); err != nil || r.Pos < 0 {
+		return nil, errors.New("invalid vcf record: bad position " + fields[1])
+	}
+	if fields[4] != "." {
+		r.Alt = strings.Split(fields[4], ",")
+	}
+	return r, nil
+}
+
+// Interval returns the chromosome and the 0-based, end exclusive range of
+// the variant on a data line, as indexed by tabix
+func Interval(line []byte) (chrom string, begin int, end int, err error) {
+	r, err := Parse(line)
+	if err != nil {
+		return
+	}
+	begin = r.Pos - 1
+	if begin < 0 {
+		begin = 0
+	}
+	end = r.End()
+	if end <= begin {
+		end = begin + 1
+	}
+	return r.Chrom, begin, end, nil
+}
+
+// IsHeader returns true for meta-information, header and blank lines
+func IsHeader(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	return len(line) == 0 || line[0] == '#'
+}
+
+// Vcf format reader type.
+type Reader struct {
+	f file.SectionReader
+	r *bufio.Reader
+}
+
+// Returns a new Vcf format reader using f.
+func NewReader(f file.SectionReader) seq.ReadRewinder {
+	return &Reader{
+		f: f,
+		r: bufio.NewReader(f),
+	}
+}
+
+// Returns a new Vcf format reader using a filename.
+func NewReaderName(name string) (r seq.ReadRewinder, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	return NewReader(f), nil
+}
+
+// readLine returns the next data line and the number of bytes read up to
+// and including it
+func (self *Reader) readLine() (line []byte, n int, err error) {
+	for {
+		read, er := self.r.ReadBytes('\n')
+		n += len(read)
+		if !IsHeader(read) {
+			return read, n, nil
+		} else if er != nil {
+			return nil, n, er
+		}
+	}
+}
+
+// ReadRecord reads a single variant and returns it with its data line,
+// without the line end.
+func (self *Reader) ReadRecord() (r *Record, line []byte, err error) {
+	if line, _, err = self.readLine(); err != nil {
+		return
+	}
+	line = bytes.TrimRight(line, "\r\n")
+	if r, err = Parse(line); err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+// Read a single variant and return it or an error. The ID is the ID of
+// the variant, or chrom:pos if it has none, Seq the data line.
+func (self *Reader) Read() (sequence *seq.Seq, err error) {
+	r, line, err := self.ReadRecord()
+	if err != nil {
+		return
+	}
+	id := r.Id
+	if id == "." || id == "" {
+		id = r.Chrom + ":" + strconv.Itoa(r.Pos)
+	}
+	return seq.New([]byte(id), line, nil), nil
+}
+
+// Read a single variant and return it or an error. (used for making record index)
+func (self *Reader) ReadRaw(p []byte) (n int, err error) {
+	line, n, err := self.readLine()
+	if err == nil {
+		copy(p, line)
+	}
+	return
+}
+
+// Read a single variant and return read offset for indexing.
+func (self *Reader) GetReadOffset() (n int, err error) {
+	_, n, err = self.readLine()
+	return
+}
+
+// Returns the length of the chunk starting at offSet, ending after the
+// last line end before conf.CHUNK_SIZE. (used for making chunkrecord index)
+func (self *Reader) SeekChunk(offSet int64) (n int64, err error) {
+	winSize := int64(32768)
+	r := io.NewSectionReader(self.f, offSet+conf.CHUNK_SIZE-winSize, winSize)
+	buf := make([]byte, winSize)
+	if n, err := r.Read(buf); err != nil {
+		return int64(n), err
+	}
+	if pos := bytes.LastIndex(buf, []byte("\n")); pos == -1 {
+		indexPos, err := self.SeekChunk(offSet + winSize)
+		return (winSize + indexPos), err
+	} else {
+		return conf.CHUNK_SIZE - winSize + int64(pos) + 1, nil
+	}
+}
+
+// Rewind the reader.
+func (self *Reader) Rewind() (err error) {
+	if s, ok := self.f.(io.Seeker); ok {
+		_, err = s.Seek(0, 0)
+		self.r = bufio.NewReader(self.f)
+	} else {
+		err = errors.New("Not a Seeker")
+	}
+	return
+}
+
+// Format a single variant, its data line
+func Format(s *seq.Seq, w io.Writer) (n int, err error) {
+	return w.Write([]byte(string(s.Seq) + "\n"))
+}
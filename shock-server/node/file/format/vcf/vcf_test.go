@@ -0,0 +1,81 @@
+package vcf_test
+
+import (
+	"fmt"
+	. "github.com/MG-RAST/Shock/shock-server/node/file/format/vcf"
+	"io"
+	"strings"
+	"testing"
+)
+
+var data = `##fileformat=VCFv4.2
+##contig=<ID=20,length=62435964>
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO	FORMAT	NA00001	NA00002
+20	14370	rs6054257	G	A	29	PASS	NS=3;DP=14	GT	0|0	1|0
+20	1110696	rs6040355	A	G,T	67	PASS	NS=2	GT	1|2	2|1
+20	1230237	.	T	.	47	PASS	NS=3	GT	0|0	0|0
+20	1234567	microsat1	GTC	G,GTCT	50	PASS	NS=3	GT	0/1	0/2
+20	1300000	.	N	<DEL>	.	PASS	SVTYPE=DEL;END=1300500	GT	0/1	0/0
+`
+
+func TestRead(t *testing.T) {
+	r := NewReader(strings.NewReader(data))
+	for i := 0; i < 2; i++ {
+		ids := []string{}
+		for {
+			s, err := r.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, string(s.ID))
+		}
+		if got := strings.Join(ids, ","); got != "rs6054257,rs6040355,20:1230237,microsat1,20:1300000" {
+			t.Errorf("got ids %s", got)
+		}
+		if err := r.Rewind(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := r.GetReadOffset(); err != nil || n != strings.Index(data, "20\t1110696") {
+		t.Errorf("got offset %d, %v", n, err)
+	}
+	rec, _, err := r.(*Reader).ReadRecord()
+	if err != nil || rec.End() != 1110696 {
+		t.Errorf("got %+v, %v", rec, err)
+	}
+	ends := []int{}
+	for _, line := range strings.Split(data, "\n")[3:8] {
+		rec, err := Parse([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, rec.End())
+	}
+	if got := fmt.Sprint(ends); got != "[14370 1110696 1230237 1234569 1300500]" {
+		t.Errorf("got ends %s", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	r, err := Parse([]byte("20\t1110696\trs6040355\tA\tG,T\t67\tPASS\tNS=2\tGT\t1|2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Chrom != "20" || r.Pos != 1110696 || r.Id != "rs6040355" || strings.Join(r.Alt, ",") != "G,T" || len(r.Samples) != 2 {
+		t.Errorf("got %+v", r)
+	}
+	chrom, begin, end, err := Interval([]byte("20\t1234567\tmicrosat1\tGTC\tG\t50\tPASS\tNS=3\n"))
+	if err != nil || chrom != "20" || begin != 1234566 || end != 1234569 {
+		t.Errorf("Interval: got %s %d %d %v", chrom, begin, end, err)
+	}
+	for _, line := range []string{"20\t14370\trs6054257\tG\tA\n", "20\tx\t.\tG\tA\t29\tPASS\t.\n"} {
+		if _, err := Parse([]byte(line)); err == nil {
+			t.Errorf("invalid line %q parsed", line)
+		}
+	}
+	if !Regex.MatchString(data) || Regex.MatchString("#CHROM\tPOS\n") {
+		t.Errorf("Regex mismatch")
+	}
+}
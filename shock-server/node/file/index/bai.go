@@ -1,62 +1,157 @@
 package index
 
 import (
+	"bufio"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"io"
+	"math/rand"
 	"os"
-	"os/exec"
-	"path/filepath"
 )
 
-type bai struct{}
+// BamIndex is a BAI index of a sorted BAM file
+type BamIndex struct {
+	Refs   []BaiRef
+	NoCoor uint64 // reads without a position
+}
+
+type bai struct {
+	f *os.File
+}
 
+// NewBaiIndexer returns an indexer of the sorted BAM file f
 func NewBaiIndexer(f *os.File) Indexer {
-	return &bai{}
+	return &bai{f: f}
 }
 
-func (i *bai) Create(string) (count int64, err error) {
+// Create builds the BAI index of the file and writes it to file. It
+// returns the number of alignments.
+func (i *bai) Create(file string) (count int64, err error) {
+	idx, count, err := buildBamIndex(i.f)
+	if err != nil {
+		return
+	}
+	err = writeIndexFile(file, idx.write)
 	return
 }
 
 func (i *bai) Close() (err error) {
+	i.f.Close()
 	return
 }
 
-func CreateBamIndex(bamFile string) (err error) {
-	err = exec.Command("samtools", "index", bamFile).Run()
+// writeIndexFile writes an index with write to a temporary file and moves
+// it to file, so that concurrent readers never see a partial index
+func writeIndexFile(file string, write func(io.Writer) error) (err error) {
+	tmpFilePath := fmt.Sprintf("%s/temp/%d%d.idx", conf.Conf["data-path"], rand.Int(), rand.Int())
+	f, err := os.Create(tmpFilePath)
 	if err != nil {
-		return err
+		return
 	}
-
-	baiFile := fmt.Sprintf("%s.bai", bamFile)
-	idxPath := fmt.Sprintf("%s/idx/", filepath.Dir(bamFile))
-
-	err = exec.Command("mv", baiFile, idxPath).Run()
+	w := bufio.NewWriter(f)
+	if err = write(w); err == nil {
+		err = w.Flush()
+	}
+	f.Close()
 	if err != nil {
-		return err
+		os.Remove(tmpFilePath)
+		return
 	}
-
-	return
+	return os.Rename(tmpFilePath, file)
 }
 
-func LoadBamIndex(bamFile string) (err error) {
-	bamFileDir := filepath.Dir(bamFile)
-	bamFileName := filepath.Base(bamFile)
-	targetBai := fmt.Sprintf("%s/%s.bai", bamFileDir, bamFileName)
-	srcBai := fmt.Sprintf("%s/idx/%s.bai", bamFileDir, bamFileName)
-	err = exec.Command("ln", "-s", srcBai, targetBai).Run()
+// buildBamIndex indexes the alignments of r, which must be sorted by
+// position
+func buildBamIndex(r io.ReadSeeker) (idx *BamIndex, count int64, err error) {
+	br := NewBgzfReader(r)
+	h, err := ReadBamHeader(br)
 	if err != nil {
-		return err
+		return
+	}
+	idx = &BamIndex{Refs: make([]BaiRef, len(h.Refs))}
+	lastRef, lastPos := int32(-1), int32(-1)
+	for {
+		begin := br.Offset()
+		rec, er := ReadBamRecord(br)
+		if er == io.EOF {
+			break
+		} else if er != nil {
+			return nil, 0, er
+		}
+		count++
+		if rec.RefId < 0 {
+			idx.NoCoor++
+			lastRef = int32(len(h.Refs))
+			continue
+		} else if int(rec.RefId) >= len(h.Refs) {
+			return nil, 0, errors.New("invalid reference in bam record")
+		}
+		if rec.RefId < lastRef || (rec.RefId == lastRef && rec.Pos < lastPos) {
+			return nil, 0, errors.New("bam file is not sorted by position")
+		}
+		lastRef, lastPos = rec.RefId, rec.Pos
+		if rec.Pos < 0 {
+			// on a reference but without a position, not part of any region
+			idx.NoCoor++
+			continue
+		}
+		idx.Refs[rec.RefId].add(rec.Pos, rec.End(), begin, br.Offset(), rec.Flag&bamUnmapped == 0)
+	}
+	for i := range idx.Refs {
+		idx.Refs[i].finish()
 	}
 	return
 }
 
-func UnLoadBamIndex(bamFile string) (err error) {
-	bamFileDir := filepath.Dir(bamFile)
-	bamFileName := filepath.Base(bamFile)
-	targetBai := fmt.Sprintf("%s/%s.bai", bamFileDir, bamFileName)
-	err = exec.Command("rm", targetBai).Run()
+// LoadBamIndex reads the BAI index file
+func LoadBamIndex(file string) (idx *BamIndex, err error) {
+	f, err := os.Open(file)
 	if err != nil {
-		return err
+		return
 	}
-	return
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic := make([]byte, 4)
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != "BAI\x01" {
+		return nil, errors.New("not a bai index")
+	}
+	var n int32
+	if err = binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return
+	}
+	idx = &BamIndex{}
+	if idx.Refs, err = readRefs(r, n); err != nil {
+		return nil, err
+	}
+	// the count of unplaced reads is optional
+	binary.Read(r, binary.LittleEndian, &idx.NoCoor)
+	return idx, nil
+}
+
+func (idx *BamIndex) write(w io.Writer) (err error) {
+	if _, err = w.Write([]byte("BAI\x01")); err != nil {
+		return
+	}
+	binary.Write(w, binary.LittleEndian, int32(len(idx.Refs)))
+	if err = writeRefs(w, idx.Refs); err != nil {
+		return
+	}
+	return binary.Write(w, binary.LittleEndian, idx.NoCoor)
+}
+
+// Chunks returns the sorted, non overlapping chunks that hold the
+// alignments overlapping region
+func (idx *BamIndex) Chunks(region Region) []Chunk {
+	if region.Ref < 0 || region.Ref >= len(idx.Refs) {
+		return nil
+	}
+	return idx.Refs[region.Ref].chunks(region.Begin, region.End)
+}
+
+// ParseRegion parses a region as in samtools: ref, ref:beg or
+// ref:beg-end with 1-based inclusive positions
+func (h *BamHeader) ParseRegion(s string) (Region, error) {
+	return parseRegion(s, h.RefId)
 }
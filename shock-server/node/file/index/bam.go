@@ -0,0 +1,449 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// flag of unmapped reads
+const bamUnmapped = 0x4
+
+var errNotBam = errors.New("not a bam file")
+
+// largest accepted alignment record, far above the longest reads with
+// their qualities and tags. Sizes in the file are not trusted otherwise,
+// the header is read as far as it goes.
+const maxBamRecord = 1 << 26
+
+// BamHeader is the header of a BAM file
+type BamHeader struct {
+	Text string
+	Refs []BamRef
+	// library of each read group, from the @RG lines of the header text
+	libraries map[string]string
+}
+
+// BamRef is a reference sequence of a BAM file
+type BamRef struct {
+	Name   string
+	Length int32
+}
+
+// BamRecord is an alignment of a BAM file. Coordinates are 0-based.
+type BamRecord struct {
+	RefId     int32
+	Pos       int32
+	Mapq      uint8
+	Flag      uint16
+	NextRefId int32
+	NextPos   int32
+	Tlen      int32
+	Name      string
+	Cigar     []uint32
+	Seq       []byte // 4 bit encoded, two bases per byte
+	LSeq      int
+	Qual      []byte
+	Aux       []byte
+}
+
+// ReadBamHeader reads the header at the start of a BAM file
+func ReadBamHeader(r io.Reader) (h *BamHeader, err error) {
+	magic := make([]byte, 4)
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != "BAM\x01" {
+		return nil, errNotBam
+	}
+	var l int32
+	if err = binary.Read(r, binary.LittleEndian, &l); err != nil || l < 0 {
+		return nil, errNotBam
+	}
+	text, err := readBytes(r, int64(l))
+	if err != nil {
+		return nil, errNotBam
+	}
+	h = &BamHeader{Text: strings.TrimRight(string(text), "\x00"), libraries: map[string]string{}}
+	var n int32
+	if err = binary.Read(r, binary.LittleEndian, &n); err != nil || n < 0 {
+		return nil, errNotBam
+	}
+	h.Refs = []BamRef{}
+	for i := int32(0); i < n; i++ {
+		if err = binary.Read(r, binary.LittleEndian, &l); err != nil || l < 1 {
+			return nil, errNotBam
+		}
+		name, err := readBytes(r, int64(l))
+		if err != nil {
+			return nil, errNotBam
+		}
+		ref := BamRef{Name: string(name[:l-1])}
+		if err = binary.Read(r, binary.LittleEndian, &ref.Length); err != nil {
+			return nil, errNotBam
+		}
+		h.Refs = append(h.Refs, ref)
+	}
+	for _, line := range strings.Split(h.Text, "\n") {
+		if !strings.HasPrefix(line, "@RG\t") {
+			continue
+		}
+		var id, lb string
+		for _, field := range strings.Split(line, "\t")[1:] {
+			if strings.HasPrefix(field, "ID:") {
+				id = field[3:]
+			} else if strings.HasPrefix(field, "LB:") {
+				lb = field[3:]
+			}
+		}
+		h.libraries[id] = lb
+	}
+	return h, nil
+}
+
+// readBytes reads n bytes, the buffer grows with the data read rather
+// than being allocated at the size claimed by the file
+func readBytes(r io.Reader, n int64) (b []byte, err error) {
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, r, n); err != nil {
+		return
+	}
+	return buf.Bytes(), nil
+}
+
+// Sam returns the header as SAM text. Without header text it lists the
+// reference sequences.
+func (h *BamHeader) Sam() string {
+	if h.Text != "" {
+		if strings.HasSuffix(h.Text, "\n") {
+			return h.Text
+		}
+		return h.Text + "\n"
+	}
+	s := ""
+	for _, ref := range h.Refs {
+		s += fmt.Sprintf("@SQ\tSN:%s\tLN:%d\n", ref.Name, ref.Length)
+	}
+	return s
+}
+
+// RefId returns the index of the reference sequence called name, -1 if
+// there is none
+func (h *BamHeader) RefId(name string) int {
+	for i, ref := range h.Refs {
+		if ref.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReadBamRecord reads the next alignment. It returns io.EOF at the end
+// of the file.
+func ReadBamRecord(r io.Reader) (rec *BamRecord, err error) {
+	var size int32
+	if err = binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return
+	}
+	if size < 32 || size > maxBamRecord {
+		return nil, errors.New("invalid bam record")
+	}
+	b := make([]byte, size)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, errors.New("truncated bam record")
+	}
+	le := binary.LittleEndian
+	rec = &BamRecord{
+		RefId:     int32(le.Uint32(b[0:])),
+		Pos:       int32(le.Uint32(b[4:])),
+		Mapq:      b[9],
+		Flag:      le.Uint16(b[14:]),
+		LSeq:      int(int32(le.Uint32(b[16:]))),
+		NextRefId: int32(le.Uint32(b[20:])),
+		NextPos:   int32(le.Uint32(b[24:])),
+		Tlen:      int32(le.Uint32(b[28:])),
+	}
+	lName, nCigar := int(b[8]), int(le.Uint16(b[12:]))
+	p := 32
+	if rec.Pos < -1 || rec.LSeq < 0 || p+lName+4*nCigar+(rec.LSeq+1)/2+rec.LSeq > len(b) {
+		return nil, errors.New("invalid bam record")
+	}
+	rec.Name = strings.TrimRight(string(b[p:p+lName]), "\x00")
+	p += lName
+	rec.Cigar = make([]uint32, nCigar)
+	for i := range rec.Cigar {
+		rec.Cigar[i] = le.Uint32(b[p:])
+		p += 4
+	}
+	rec.Seq = b[p : p+(rec.LSeq+1)/2]
+	p += (rec.LSeq + 1) / 2
+	rec.Qual = b[p : p+rec.LSeq]
+	rec.Aux = b[p+rec.LSeq:]
+	return
+}
+
+// End returns the 0-based exclusive end of the alignment on the
+// reference. Unmapped reads and reads without cigar cover one base.
+func (rec *BamRecord) End() int32 {
+	end := rec.Pos
+	if rec.Flag&bamUnmapped == 0 {
+		for _, c := range rec.Cigar {
+			switch c & 0xf {
+			case 0, 2, 3, 7, 8: // M, D, N, =, X consume the reference
+				end += int32(c >> 4)
+			}
+		}
+	}
+	if end == rec.Pos {
+		end++
+	}
+	return end
+}
+
+// auxSize returns the size of the value of an aux field of type t at the
+// start of b, -1 if it is invalid
+func auxSize(t byte, b []byte) int {
+	switch t {
+	case 'A', 'c', 'C':
+		return 1
+	case 's', 'S':
+		return 2
+	case 'i', 'I', 'f':
+		return 4
+	case 'Z', 'H':
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			return i + 1
+		}
+	case 'B':
+		if len(b) >= 5 {
+			if n := auxSize(b[0], nil); n > 0 && n <= 4 {
+				return 5 + n*int(binary.LittleEndian.Uint32(b[1:]))
+			}
+		}
+	}
+	return -1
+}
+
+// Tag returns the value of the string aux field tag, e.g. RG
+func (rec *BamRecord) Tag(tag string) (string, bool) {
+	for b := rec.Aux; len(b) >= 3; {
+		n := auxSize(b[2], b[3:])
+		if n < 0 || 3+n > len(b) {
+			break
+		}
+		if string(b[:2]) == tag && b[2] == 'Z' {
+			return string(b[3 : 3+n-1]), true
+		}
+		b = b[3+n:]
+	}
+	return "", false
+}
+
+// auxValue formats the value of type t at the start of b as in SAM
+func auxValue(t byte, b []byte) string {
+	le := binary.LittleEndian
+	switch t {
+	case 'A':
+		return string(b[:1])
+	case 'c':
+		return strconv.Itoa(int(int8(b[0])))
+	case 'C':
+		return strconv.Itoa(int(b[0]))
+	case 's':
+		return strconv.Itoa(int(int16(le.Uint16(b))))
+	case 'S':
+		return strconv.Itoa(int(le.Uint16(b)))
+	case 'i':
+		return strconv.Itoa(int(int32(le.Uint32(b))))
+	case 'I':
+		return strconv.FormatUint(uint64(le.Uint32(b)), 10)
+	case 'f':
+		return fmt.Sprintf("%g", math.Float32frombits(le.Uint32(b)))
+	}
+	return ""
+}
+
+// Sam writes the alignment as a line of SAM text
+func (rec *BamRecord) Sam(h *BamHeader) string {
+	refName := func(id int32) string {
+		if id >= 0 && int(id) < len(h.Refs) {
+			return h.Refs[id].Name
+		}
+		return "*"
+	}
+	fields := make([]string, 11, 16)
+	fields[0] = rec.Name
+	fields[1] = strconv.Itoa(int(rec.Flag))
+	fields[2] = refName(rec.RefId)
+	fields[3] = strconv.Itoa(int(rec.Pos) + 1)
+	fields[4] = strconv.Itoa(int(rec.Mapq))
+	fields[5] = "*"
+	if len(rec.Cigar) > 0 {
+		cigar := make([]byte, 0, 4*len(rec.Cigar))
+		for _, c := range rec.Cigar {
+			cigar = strconv.AppendUint(cigar, uint64(c>>4), 10)
+			cigar = append(cigar, "MIDNSHP=X???????"[c&0xf])
+		}
+		fields[5] = string(cigar)
+	}
+	fields[6] = refName(rec.NextRefId)
+	if rec.NextRefId >= 0 && rec.NextRefId == rec.RefId {
+		fields[6] = "="
+	}
+	fields[7] = strconv.Itoa(int(rec.NextPos) + 1)
+	fields[8] = strconv.Itoa(int(rec.Tlen))
+	fields[9], fields[10] = "*", "*"
+	if rec.LSeq > 0 {
+		seq := make([]byte, rec.LSeq)
+		for i := range seq {
+			seq[i] = "=ACMGRSVTWYHKDBN"[rec.Seq[i/2]>>(4*uint(1-i%2))&0xf]
+		}
+		fields[9] = string(seq)
+		if rec.Qual[0] != 0xff {
+			qual := make([]byte, rec.LSeq)
+			for i, q := range rec.Qual {
+				qual[i] = q + 33
+			}
+			fields[10] = string(qual)
+		}
+	}
+	for b := rec.Aux; len(b) >= 3; {
+		t, n := b[2], auxSize(b[2], b[3:])
+		if n < 0 || 3+n > len(b) {
+			break
+		}
+		v := b[3 : 3+n]
+		switch t {
+		case 'A', 'f':
+			fields = append(fields, string(b[:2])+":"+string(t)+":"+auxValue(t, v))
+		case 'Z', 'H':
+			fields = append(fields, string(b[:2])+":"+string(t)+":"+string(v[:n-1]))
+		case 'B':
+			size := auxSize(v[0], nil)
+			s := string(b[:2]) + ":B:" + string(v[0])
+			for i := 5; i+size <= n; i += size {
+				s += "," + auxValue(v[0], v[i:])
+			}
+			fields = append(fields, s)
+		default:
+			fields = append(fields, string(b[:2])+":i:"+auxValue(t, v))
+		}
+		b = b[3+n:]
+	}
+	return strings.Join(fields, "\t") + "\n"
+}
+
+// BamView selects the alignments of a BAM file to write as SAM text, like
+// samtools view
+type BamView struct {
+	Region     string // ref, ref:beg or ref:beg-end, 1-based inclusive
+	Header     bool   // include the header
+	HeaderOnly bool
+	Count      bool   // only write the number of alignments
+	Flag       uint16 // required flag bits
+	MinMapq    int
+	ReadGroup  string
+	Library    string
+}
+
+// Write writes the selected alignments of the BAM file r to w. Region
+// queries need the index of the file. Errors in the query are returned
+// before anything is written.
+func (v *BamView) Write(w io.Writer, r io.ReadSeeker, idx *BamIndex) (err error) {
+	br := NewBgzfReader(r)
+	h, err := ReadBamHeader(br)
+	if err != nil {
+		return
+	}
+	var chunks []Chunk
+	var region Region
+	if v.Region != "" {
+		if idx == nil {
+			return errors.New("region queries require a bai index")
+		}
+		if region, err = h.ParseRegion(v.Region); err != nil {
+			return
+		}
+		chunks = idx.Chunks(region)
+	}
+	if (v.Header || v.HeaderOnly) && !v.Count {
+		if _, err = io.WriteString(w, h.Sam()); err != nil {
+			return
+		}
+	}
+	if v.HeaderOnly {
+		return
+	}
+
+	count := 0
+	write := func(rec *BamRecord) (err error) {
+		if !v.match(h, rec) {
+			return
+		}
+		count++
+		if !v.Count {
+			_, err = io.WriteString(w, rec.Sam(h))
+		}
+		return
+	}
+	if v.Region == "" {
+		for {
+			rec, er := ReadBamRecord(br)
+			if er == io.EOF {
+				break
+			} else if er != nil {
+				return er
+			}
+			if err = write(rec); err != nil {
+				return
+			}
+		}
+	} else {
+	chunks:
+		for _, c := range chunks {
+			if err = br.Seek(c.Begin); err != nil {
+				return
+			}
+			for br.Offset() < c.End {
+				rec, er := ReadBamRecord(br)
+				if er == io.EOF {
+					break chunks
+				} else if er != nil {
+					return er
+				}
+				// alignments are sorted by position
+				if int(rec.RefId) != region.Ref || rec.Pos >= region.End {
+					break chunks
+				}
+				if rec.End() <= region.Begin {
+					continue
+				}
+				if err = write(rec); err != nil {
+					return
+				}
+			}
+		}
+	}
+	if v.Count {
+		_, err = fmt.Fprintf(w, "%d\n", count)
+	}
+	return
+}
+
+func (v *BamView) match(h *BamHeader, rec *BamRecord) bool {
+	if rec.Flag&v.Flag != v.Flag || int(rec.Mapq) < v.MinMapq {
+		return false
+	}
+	if v.ReadGroup != "" || v.Library != "" {
+		rg, _ := rec.Tag("RG")
+		if v.ReadGroup != "" && rg != v.ReadGroup {
+			return false
+		}
+		if v.Library != "" && h.libraries[rg] != v.Library {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,325 @@
+package index_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	. "github.com/MG-RAST/Shock/shock-server/node/file/index"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// bgzfBlock compresses data into a single BGZF block
+func bgzfBlock(data []byte) []byte {
+	var c bytes.Buffer
+	fw, _ := flate.NewWriter(&c, flate.DefaultCompression)
+	fw.Write(data)
+	fw.Close()
+	b := []byte{31, 139, 8, 4, 0, 0, 0, 0, 0, 255, 6, 0, 'B', 'C', 2, 0, 0, 0}
+	b = append(b, c.Bytes()...)
+	b = appendUint32(b, crc32.ChecksumIEEE(data))
+	b = appendUint32(b, uint32(len(data)))
+	binary.LittleEndian.PutUint16(b[16:], uint16(len(b)-1))
+	return b
+}
+
+type testRecord struct {
+	name  string
+	ref   int32
+	pos   int32
+	mapq  uint8
+	flag  uint16
+	cigar []uint32
+	seq   string
+	aux   []byte
+}
+
+func (r testRecord) bytes() []byte {
+	le := binary.LittleEndian
+	b := make([]byte, 32)
+	le.PutUint32(b[0:], uint32(r.ref))
+	le.PutUint32(b[4:], uint32(r.pos))
+	b[8] = byte(len(r.name) + 1)
+	b[9] = r.mapq
+	le.PutUint16(b[12:], uint16(len(r.cigar)))
+	le.PutUint16(b[14:], r.flag)
+	le.PutUint32(b[16:], uint32(len(r.seq)))
+	le.PutUint32(b[20:], ^uint32(0))
+	le.PutUint32(b[24:], ^uint32(0))
+	b = append(b, r.name...)
+	b = append(b, 0)
+	for _, c := range r.cigar {
+		b = appendUint32(b, c)
+	}
+	codes := map[byte]byte{'A': 1, 'C': 2, 'G': 4, 'T': 8, 'N': 15}
+	for i := 0; i < len(r.seq); i += 2 {
+		v := codes[r.seq[i]] << 4
+		if i+1 < len(r.seq) {
+			v |= codes[r.seq[i+1]]
+		}
+		b = append(b, v)
+	}
+	for range r.seq {
+		b = append(b, 40)
+	}
+	b = append(b, r.aux...)
+	return append(appendUint32(nil, uint32(len(b))), b...)
+}
+
+func cigar(n uint32, op uint32) uint32 {
+	return n<<4 | op
+}
+
+var records = []testRecord{
+	{"r1", 0, 100, 30, 0, []uint32{cigar(4, 0)}, "ACGT", append(append([]byte("RGZgrpA\x00NMc\x01XFf"), 0, 0, 0xc0, 0x3f), []byte("XBBs\x02\x00\x00\x00\x01\x00\xfe\xff")...)},
+	{"r2", 0, 20000, 10, 0, []uint32{cigar(10, 0), cigar(5, 2), cigar(10, 0)}, "ACGTACGTACGTACGTACGT", nil},
+	{"r3", 0, 40000, 0, 4, nil, "NN", nil},
+	{"r4", 1, 5, 0, 16, []uint32{cigar(2, 4), cigar(20, 0)}, "", nil},
+	{"r5", -1, -1, 0, 4, nil, "A", nil},
+}
+
+// writeBam writes a BAM file with the test records, each in its own block
+func writeBam(t *testing.T, path string, recs []testRecord) {
+	text := "@HD\tVN:1.0\tSO:coordinate\n@RG\tID:grpA\tLB:libA\n"
+	h := append([]byte("BAM\x01"), appendUint32(nil, uint32(len(text)))...)
+	h = append(h, text...)
+	h = appendUint32(h, 2)
+	for _, ref := range []struct {
+		name string
+		len  uint32
+	}{{"chr1", 100000}, {"chr2", 50000}} {
+		h = appendUint32(h, uint32(len(ref.name)+1))
+		h = append(h, ref.name...)
+		h = append(h, 0)
+		h = appendUint32(h, ref.len)
+	}
+	data := bgzfBlock(h)
+	for _, r := range recs {
+		data = append(data, bgzfBlock(r.bytes())...)
+	}
+	data = append(data, bgzfBlock(nil)...)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setup writes the test BAM file and its index to a temporary directory
+func setup(t *testing.T) (dir string, bam string, idx *BamIndex) {
+	dir, err := ioutil.TempDir("", "bam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Join(dir, "temp"), 0755)
+	conf.Conf["data-path"] = dir
+	bam = filepath.Join(dir, "test.bam")
+	writeBam(t, bam, records)
+	f, err := os.Open(bam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idxer := Indexers["bai"](f)
+	count, err := idxer.Create(filepath.Join(dir, "bai.idx"))
+	idxer.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if count != 5 {
+		t.Errorf("indexed %d alignments, want 5", count)
+	}
+	if idx, err = LoadBamIndex(filepath.Join(dir, "bai.idx")); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func view(t *testing.T, bam string, idx *BamIndex, v *BamView) (string, error) {
+	f, err := os.Open(bam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var out bytes.Buffer
+	err = v.Write(&out, f, idx)
+	return out.String(), err
+}
+
+// names returns the read names of the sam lines in s
+func names(s string) string {
+	n := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		if line != "" && !strings.HasPrefix(line, "@") {
+			n = append(n, strings.Split(line, "\t")[0])
+		}
+	}
+	return strings.Join(n, ",")
+}
+
+func TestBamRegion(t *testing.T) {
+	dir, bam, idx := setup(t)
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		region string
+		reads  string
+	}{
+		{"chr1", "r1,r2,r3"},
+		{"chr1:1-100", ""},
+		{"chr1:101-101", "r1"},
+		{"chr1:104-20001", "r1,r2"},
+		{"chr1:20,025-20,030", "r2"},
+		{"chr1:20026-40000", ""},
+		{"chr1:40001", "r3"},
+		{"chr2", "r4"},
+		{"chr2:27-100", ""},
+	} {
+		out, err := view(t, bam, idx, &BamView{Region: test.region})
+		if err != nil {
+			t.Errorf("region %s: %s", test.region, err)
+		} else if got := names(out); got != test.reads {
+			t.Errorf("region %s: got reads %q, want %q", test.region, got, test.reads)
+		}
+	}
+
+	for _, region := range []string{"chr3", "chr1:0-10", "chr1:20-10", "chr1:x"} {
+		if _, err := view(t, bam, idx, &BamView{Region: region}); err == nil {
+			t.Errorf("region %s: no error", region)
+		}
+	}
+	if _, err := view(t, bam, nil, &BamView{Region: "chr1"}); err == nil {
+		t.Errorf("region query without index: no error")
+	}
+}
+
+func TestBamView(t *testing.T) {
+	dir, bam, idx := setup(t)
+	defer os.RemoveAll(dir)
+
+	out, err := view(t, bam, nil, &BamView{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out, "\n")
+	if want := "r1\t0\tchr1\t101\t30\t4M\t*\t0\t0\tACGT\tIIII\tRG:Z:grpA\tNM:i:1\tXF:f:1.5\tXB:B:s,1,-2"; lines[0] != want {
+		t.Errorf("got sam line\n%q\nwant\n%q", lines[0], want)
+	}
+	if want := "r4\t16\tchr2\t6\t0\t2S20M\t*\t0\t0\t*\t*"; lines[3] != want {
+		t.Errorf("got sam line\n%q\nwant\n%q", lines[3], want)
+	}
+	if want := "r5\t4\t*\t0\t0\t*\t*\t0\t0\tA\tI"; lines[4] != want {
+		t.Errorf("got sam line\n%q\nwant\n%q", lines[4], want)
+	}
+
+	for _, test := range []struct {
+		v   BamView
+		out string
+	}{
+		{BamView{Count: true}, "5\n"},
+		{BamView{Count: true, Region: "chr1"}, "3\n"},
+		{BamView{HeaderOnly: true}, "@HD\tVN:1.0\tSO:coordinate\n@RG\tID:grpA\tLB:libA\n"},
+		{BamView{Flag: 4, Count: true}, "2\n"},
+		{BamView{MinMapq: 20, Count: true}, "1\n"},
+		{BamView{ReadGroup: "grpA", Count: true}, "1\n"},
+		{BamView{Library: "libA", Count: true}, "1\n"},
+		{BamView{Library: "libB", Count: true}, "0\n"},
+	} {
+		out, err := view(t, bam, idx, &test.v)
+		if err != nil {
+			t.Errorf("%+v: %s", test.v, err)
+		} else if out != test.out {
+			t.Errorf("%+v: got %q, want %q", test.v, out, test.out)
+		}
+	}
+
+	out, err = view(t, bam, idx, &BamView{Header: true, Region: "chr2"})
+	if err != nil || !strings.HasPrefix(out, "@HD") || names(out) != "r4" {
+		t.Errorf("header and region: got %q, %v", out, err)
+	}
+}
+
+func TestBaiUnsorted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "temp"), 0755)
+	conf.Conf["data-path"] = dir
+	bam := filepath.Join(dir, "test.bam")
+	writeBam(t, bam, []testRecord{records[1], records[0]})
+	f, err := os.Open(bam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idxer := Indexers["bai"](f)
+	defer idxer.Close()
+	if _, err := idxer.Create(filepath.Join(dir, "bai.idx")); err == nil {
+		t.Errorf("indexing an unsorted bam file: no error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bai.idx")); err == nil {
+		t.Errorf("index of an unsorted bam file was written")
+	}
+}
+
+func TestBaiNoPosition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "temp"), 0755)
+	conf.Conf["data-path"] = dir
+	bam := filepath.Join(dir, "test.bam")
+	// a read on chr1 without a position sorts first
+	unplaced := testRecord{"r0", 0, -1, 0, 4, nil, "A", nil}
+	writeBam(t, bam, append([]testRecord{unplaced}, records...))
+	f, err := os.Open(bam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idxer := Indexers["bai"](f)
+	count, err := idxer.Create(filepath.Join(dir, "bai.idx"))
+	idxer.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if count != 6 {
+		t.Errorf("indexed %d alignments, want 6", count)
+	}
+	idx, err := LoadBamIndex(filepath.Join(dir, "bai.idx"))
+	if err != nil {
+		t.Fatal(err)
+	} else if idx.NoCoor != 2 {
+		t.Errorf("got %d reads without position, want 2", idx.NoCoor)
+	}
+	for region, reads := range map[string]string{"chr1": "r1,r2,r3", "chr1:1-1": "", "chr1:101": "r1,r2,r3"} {
+		out, err := view(t, bam, idx, &BamView{Region: region})
+		if err != nil {
+			t.Errorf("region %s: %s", region, err)
+		} else if got := names(out); got != reads {
+			t.Errorf("region %s: got reads %q, want %q", region, got, reads)
+		}
+	}
+}
+
+func TestBamLimits(t *testing.T) {
+	// a header claiming 2 GB of text
+	h := append([]byte("BAM\x01"), appendUint32(nil, 1<<31-1)...)
+	if _, err := ReadBamHeader(bytes.NewReader(append(h, "@HD"...))); err == nil {
+		t.Errorf("truncated header: no error")
+	}
+	// and a record claiming 2 GB
+	if _, err := ReadBamRecord(bytes.NewReader(appendUint32(nil, 1<<31-1))); err == nil || err.Error() != "invalid bam record" {
+		t.Errorf("oversized record: got %v", err)
+	}
+	rec := testRecord{"r", 0, -2, 0, 0, nil, "A", nil}
+	if _, err := ReadBamRecord(bytes.NewReader(rec.bytes())); err == nil {
+		t.Errorf("negative position: no error")
+	}
+}
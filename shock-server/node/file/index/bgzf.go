@@ -0,0 +1,136 @@
+package index
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+var errNotBgzf = errors.New("not a bgzf file")
+
+// BgzfReader reads a BGZF file, the blocked gzip format of BAM files, and
+// tracks the virtual file offset of the data read: the offset of the
+// compressed block shifted left 16 bits ored with the offset in the
+// uncompressed block.
+type BgzfReader struct {
+	r     io.ReadSeeker
+	block []byte
+	pos   int    // read position in block
+	coff  uint64 // file offset of block
+	next  uint64 // file offset of the following block
+}
+
+// NewBgzfReader returns a reader of the BGZF file r, positioned at its start
+func NewBgzfReader(r io.ReadSeeker) *BgzfReader {
+	return &BgzfReader{r: r}
+}
+
+// Offset returns the virtual offset of the next byte to be read
+func (b *BgzfReader) Offset() uint64 {
+	if b.pos >= len(b.block) {
+		return b.next << 16
+	}
+	return b.coff<<16 | uint64(b.pos)
+}
+
+// Seek positions the reader at virtual offset voff
+func (b *BgzfReader) Seek(voff uint64) (err error) {
+	coff, uoff := voff>>16, int(voff&0xffff)
+	if coff != b.coff || b.block == nil {
+		b.next = coff
+		if err = b.readBlock(); err != nil {
+			return
+		}
+	}
+	if uoff > len(b.block) {
+		return errors.New("invalid bgzf offset")
+	}
+	b.pos = uoff
+	return
+}
+
+func (b *BgzfReader) Read(p []byte) (n int, err error) {
+	for b.pos >= len(b.block) {
+		if err = b.readBlock(); err != nil {
+			return
+		}
+	}
+	n = copy(p, b.block[b.pos:])
+	b.pos += n
+	return
+}
+
+// ReadLine reads a line, including the line end. The last line of the
+// file may lack it; io.EOF is returned after it.
+func (b *BgzfReader) ReadLine() (line []byte, err error) {
+	for {
+		if b.pos >= len(b.block) {
+			if err = b.readBlock(); err != nil {
+				if err == io.EOF && len(line) > 0 {
+					err = nil
+				}
+				return
+			}
+			continue
+		}
+		if i := bytes.IndexByte(b.block[b.pos:], '\n'); i >= 0 {
+			line = append(line, b.block[b.pos:b.pos+i+1]...)
+			b.pos += i + 1
+			return
+		}
+		line = append(line, b.block[b.pos:]...)
+		b.pos = len(b.block)
+	}
+}
+
+// readBlock reads and inflates the block at b.next
+func (b *BgzfReader) readBlock() (err error) {
+	if _, err = b.r.Seek(int64(b.next), 0); err != nil {
+		return
+	}
+	header := make([]byte, 12)
+	if _, err = io.ReadFull(b.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errNotBgzf
+		}
+		return
+	}
+	if header[0] != 31 || header[1] != 139 || header[2] != 8 || header[3]&4 == 0 {
+		return errNotBgzf
+	}
+	extra := make([]byte, binary.LittleEndian.Uint16(header[10:]))
+	if _, err = io.ReadFull(b.r, extra); err != nil {
+		return errNotBgzf
+	}
+	// the BC subfield holds the block size - 1
+	bsize := -1
+	for i := 0; i+4 <= len(extra); {
+		slen := int(binary.LittleEndian.Uint16(extra[i+2:]))
+		if extra[i] == 'B' && extra[i+1] == 'C' && slen == 2 && i+6 <= len(extra) {
+			bsize = int(binary.LittleEndian.Uint16(extra[i+4:])) + 1
+		}
+		i += 4 + slen
+	}
+	rest := bsize - len(header) - len(extra)
+	if bsize < 0 || rest < 8 {
+		return errNotBgzf
+	}
+	data := make([]byte, rest)
+	if _, err = io.ReadFull(b.r, data); err != nil {
+		return errNotBgzf
+	}
+	block, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data[:rest-8])))
+	if err != nil {
+		return
+	}
+	if crc32.ChecksumIEEE(block) != binary.LittleEndian.Uint32(data[rest-8:]) || uint32(len(block)) != binary.LittleEndian.Uint32(data[rest-4:]) {
+		return errors.New("bgzf block checksum mismatch")
+	}
+	b.coff, b.next = b.next, b.next+uint64(bsize)
+	b.block, b.pos = block, 0
+	return
+}
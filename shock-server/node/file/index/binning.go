@@ -0,0 +1,260 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The binning scheme shared by BAI and tabix indexes: the chunks of the
+// records of a reference sequence are listed by the smallest of the
+// nested bins containing them, see the SAM format specification.
+const (
+	// the pseudo bin holding the offsets and record counts of a reference
+	metaBin = 37450
+	// positions covered by the bins
+	maxPos = 1 << 29
+)
+
+// Chunk is a range of virtual offsets of a BGZF file
+type Chunk struct {
+	Begin uint64
+	End   uint64
+}
+
+// BaiRef is the index of a reference sequence: the chunks of the
+// records in each bin and the lowest offset of the records overlapping
+// each 16kb window
+type BaiRef struct {
+	Bins   map[uint32][]Chunk
+	Linear []uint64
+	// offsets and counts for the pseudo bin, while building
+	first, last      uint64
+	mapped, unmapped uint64
+}
+
+// Region is a 0-based, end exclusive range of a reference sequence
+type Region struct {
+	Ref   int
+	Begin int32
+	End   int32
+}
+
+// add indexes the record at offsets begin-end covering beg-end of the
+// reference
+func (ref *BaiRef) add(beg, end int32, begin, next uint64, mapped bool) {
+	if ref.Bins == nil {
+		ref.Bins = map[uint32][]Chunk{}
+		ref.first = begin
+	}
+	ref.last = next
+	if mapped {
+		ref.mapped++
+	} else {
+		ref.unmapped++
+	}
+	bin := reg2bin(beg, end)
+	chunks := ref.Bins[bin]
+	if n := len(chunks); n > 0 && chunks[n-1].End == begin {
+		chunks[n-1].End = next
+	} else {
+		ref.Bins[bin] = append(chunks, Chunk{begin, next})
+	}
+	for w := int(beg >> 14); w <= int((end-1)>>14); w++ {
+		for len(ref.Linear) <= w {
+			ref.Linear = append(ref.Linear, 0)
+		}
+		if ref.Linear[w] == 0 {
+			ref.Linear[w] = begin
+		}
+	}
+}
+
+// finish adds the pseudo bin and fills the gaps of the linear index
+func (ref *BaiRef) finish() {
+	if ref.Bins == nil {
+		ref.Bins = map[uint32][]Chunk{}
+		return
+	}
+	ref.Bins[metaBin] = []Chunk{{ref.first, ref.last}, {ref.mapped, ref.unmapped}}
+	for w := 1; w < len(ref.Linear); w++ {
+		if ref.Linear[w] == 0 {
+			ref.Linear[w] = ref.Linear[w-1]
+		}
+	}
+}
+
+// chunks returns the sorted, non overlapping chunks that hold the records
+// overlapping beg-end
+func (ref *BaiRef) chunks(beg, end int32) (chunks []Chunk) {
+	if beg >= end {
+		return
+	}
+	minOffset := uint64(0)
+	if w := int(beg >> 14); w < len(ref.Linear) {
+		minOffset = ref.Linear[w]
+	}
+	for _, bin := range reg2bins(beg, end) {
+		for _, c := range ref.Bins[bin] {
+			if c.End > minOffset {
+				chunks = append(chunks, c)
+			}
+		}
+	}
+	sort.Sort(byBegin(chunks))
+	merged := []Chunk{}
+	for _, c := range chunks {
+		if n := len(merged); n > 0 && c.Begin <= merged[n-1].End {
+			if c.End > merged[n-1].End {
+				merged[n-1].End = c.End
+			}
+		} else {
+			merged = append(merged, c)
+		}
+	}
+	return merged
+}
+
+type byBegin []Chunk
+
+func (c byBegin) Len() int           { return len(c) }
+func (c byBegin) Less(i, j int) bool { return c[i].Begin < c[j].Begin }
+func (c byBegin) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// writeRefs writes the bins and linear indexes of refs as in BAI files
+func writeRefs(w io.Writer, refs []BaiRef) (err error) {
+	le := binary.LittleEndian
+	for _, ref := range refs {
+		bins := make([]int, 0, len(ref.Bins))
+		for bin := range ref.Bins {
+			bins = append(bins, int(bin))
+		}
+		sort.Ints(bins)
+		binary.Write(w, le, int32(len(bins)))
+		for _, bin := range bins {
+			binary.Write(w, le, uint32(bin))
+			binary.Write(w, le, int32(len(ref.Bins[uint32(bin)])))
+			binary.Write(w, le, ref.Bins[uint32(bin)])
+		}
+		binary.Write(w, le, int32(len(ref.Linear)))
+		if err = binary.Write(w, le, ref.Linear); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readRefs reads n references written by writeRefs
+func readRefs(r io.Reader, n int32) (refs []BaiRef, err error) {
+	le := binary.LittleEndian
+	if n < 0 {
+		return nil, fmt.Errorf("invalid number of references: %d", n)
+	}
+	refs = make([]BaiRef, n)
+	for i := range refs {
+		ref := &refs[i]
+		ref.Bins = map[uint32][]Chunk{}
+		var nBin, nChunk, nIntv int32
+		if err = binary.Read(r, le, &nBin); err != nil {
+			return nil, err
+		}
+		for j := int32(0); j < nBin; j++ {
+			var bin uint32
+			if err = binary.Read(r, le, &bin); err != nil {
+				return nil, err
+			}
+			if err = binary.Read(r, le, &nChunk); err != nil || nChunk < 0 {
+				return nil, fmt.Errorf("invalid index")
+			}
+			chunks := make([]Chunk, nChunk)
+			if err = binary.Read(r, le, chunks); err != nil {
+				return nil, err
+			}
+			ref.Bins[bin] = chunks
+		}
+		if err = binary.Read(r, le, &nIntv); err != nil || nIntv < 0 {
+			return nil, fmt.Errorf("invalid index")
+		}
+		ref.Linear = make([]uint64, nIntv)
+		if err = binary.Read(r, le, ref.Linear); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// reg2bin returns the smallest bin containing the 0-based, end exclusive
+// range beg-end
+func reg2bin(beg, end int32) uint32 {
+	end--
+	switch {
+	case beg>>14 == end>>14:
+		return uint32(((1<<15)-1)/7 + (beg >> 14))
+	case beg>>17 == end>>17:
+		return uint32(((1<<12)-1)/7 + (beg >> 17))
+	case beg>>20 == end>>20:
+		return uint32(((1<<9)-1)/7 + (beg >> 20))
+	case beg>>23 == end>>23:
+		return uint32(((1<<6)-1)/7 + (beg >> 23))
+	case beg>>26 == end>>26:
+		return uint32(((1<<3)-1)/7 + (beg >> 26))
+	}
+	return 0
+}
+
+// reg2bins returns the bins that may hold records overlapping beg-end
+func reg2bins(beg, end int32) []uint32 {
+	end--
+	bins := []uint32{0}
+	for _, l := range []struct {
+		first uint32
+		shift uint
+	}{{1, 26}, {9, 23}, {73, 20}, {585, 17}, {4681, 14}} {
+		for k := l.first + uint32(beg>>l.shift); k <= l.first+uint32(end>>l.shift); k++ {
+			bins = append(bins, k)
+		}
+	}
+	return bins
+}
+
+// parseRegion parses a region as in samtools and tabix: ref, ref:beg or
+// ref:beg-end with 1-based inclusive positions. refId returns the index
+// of a reference name, -1 if it is unknown.
+func parseRegion(s string, refId func(string) int) (region Region, err error) {
+	name, span := s, ""
+	if region.Ref = refId(s); region.Ref < 0 {
+		if i := strings.LastIndex(s, ":"); i >= 0 {
+			name, span = s[:i], s[i+1:]
+		}
+		if region.Ref = refId(name); region.Ref < 0 {
+			return region, fmt.Errorf("unknown reference in region: %s", s)
+		}
+	}
+	region.Begin, region.End = 0, maxPos
+	if span == "" {
+		return
+	}
+	span = strings.Replace(span, ",", "", -1)
+	begin, end := span, ""
+	if i := strings.Index(span, "-"); i >= 0 {
+		begin, end = span[:i], span[i+1:]
+	}
+	b, err := strconv.ParseInt(begin, 10, 32)
+	if err != nil || b < 1 || b > maxPos {
+		return region, fmt.Errorf("invalid region: %s", s)
+	}
+	region.Begin = int32(b - 1)
+	if end != "" {
+		e, err := strconv.ParseInt(end, 10, 32)
+		if err != nil || e < b {
+			return region, fmt.Errorf("invalid region: %s", s)
+		}
+		if e < maxPos {
+			region.End = int32(e)
+		}
+	}
+	return region, nil
+}
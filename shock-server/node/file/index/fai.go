@@ -0,0 +1,312 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/multi"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// width of the sequence lines of extracted regions, as in samtools faidx
+const faiLineWidth = 60
+
+// blocks of this many bases are read at a time
+const faiBlockSize = 1 << 16
+
+// FaiSeq is an entry of a faidx index: the length of a sequence, the file
+// offset of its first base and the bases and bytes per line
+type FaiSeq struct {
+	Name      string
+	Length    int64
+	Offset    int64
+	LineBases int64
+	LineWidth int64
+}
+
+// FaiIndex is a faidx index of a fasta file, written in the text format of
+// samtools .fai files
+type FaiIndex struct {
+	Seqs []FaiSeq
+}
+
+type fai struct {
+	f *os.File
+}
+
+// NewFaiIndexer returns an indexer of the fasta file f
+func NewFaiIndexer(f *os.File) Indexer {
+	return &fai{f: f}
+}
+
+// Create builds the faidx index of the file and writes it to file. It
+// returns the number of sequences.
+func (i *fai) Create(file string) (count int64, err error) {
+	if format, er := multi.NewReader(i.f).Type(); er != nil || format != "fasta" {
+		return 0, errors.New("fai index requires a fasta file")
+	}
+	if _, err = i.f.Seek(0, 0); err != nil {
+		return
+	}
+	idx, err := buildFaiIndex(i.f)
+	if err != nil {
+		return
+	}
+	if err = writeIndexFile(file, idx.write); err != nil {
+		return
+	}
+	return int64(len(idx.Seqs)), nil
+}
+
+func (i *fai) Close() (err error) {
+	i.f.Close()
+	return
+}
+
+// buildFaiIndex reads the fasta file r, all lines of a sequence but the
+// last must have the same length
+func buildFaiIndex(r io.Reader) (idx *FaiIndex, err error) {
+	idx = &FaiIndex{}
+	br := bufio.NewReader(r)
+	names := map[string]bool{}
+	var seq *FaiSeq
+	offset := int64(0)
+	ended := false // a line shorter than the others was read
+	for n := 1; ; n++ {
+		line, er := br.ReadBytes('\n')
+		if len(line) == 0 && er != nil {
+			if er != io.EOF {
+				return nil, er
+			}
+			break
+		}
+		offset += int64(len(line))
+		if line[0] == '>' {
+			fields := strings.Fields(string(line[1:]))
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("line %d: sequence without name", n)
+			} else if names[fields[0]] {
+				return nil, fmt.Errorf("line %d: duplicate sequence name %s", n, fields[0])
+			}
+			names[fields[0]] = true
+			idx.Seqs = append(idx.Seqs, FaiSeq{Name: fields[0], Offset: offset})
+			seq = &idx.Seqs[len(idx.Seqs)-1]
+			ended = false
+			continue
+		}
+		bases := int64(len(bytes.TrimRight(line, "\r\n")))
+		if seq == nil {
+			if bases == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: sequence before the first header", n)
+		}
+		if bases == 0 {
+			ended = true
+			continue
+		}
+		if seq.LineBases == 0 {
+			seq.LineBases, seq.LineWidth = bases, int64(len(line))
+		} else if ended || bases > seq.LineBases || (bases == seq.LineBases && int64(len(line)) != seq.LineWidth && er == nil) {
+			return nil, fmt.Errorf("line %d: different line length in sequence %s", n, seq.Name)
+		}
+		if bases < seq.LineBases {
+			ended = true
+		}
+		seq.Length += bases
+	}
+	return idx, nil
+}
+
+// write writes the index as a samtools .fai file
+func (idx *FaiIndex) write(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	for _, s := range idx.Seqs {
+		fmt.Fprintf(bw, "%s\t%d\t%d\t%d\t%d\n", s.Name, s.Length, s.Offset, s.LineBases, s.LineWidth)
+	}
+	return bw.Flush()
+}
+
+// LoadFaiIndex reads the faidx index file, as written by Create or
+// samtools faidx
+func LoadFaiIndex(file string) (idx *FaiIndex, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	idx = &FaiIndex{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) < 5 {
+			return nil, errors.New("invalid fai index")
+		}
+		seq := FaiSeq{Name: fields[0]}
+		for i, v := range []*int64{&seq.Length, &seq.Offset, &seq.LineBases, &seq.LineWidth} {
+			if *v, err = strconv.ParseInt(fields[i+1], 10, 64); err != nil || *v < 0 {
+				return nil, errors.New("invalid fai index")
+			}
+		}
+		if seq.Length > 0 && (seq.LineBases == 0 || seq.LineWidth < seq.LineBases) {
+			return nil, errors.New("invalid fai index")
+		}
+		idx.Seqs = append(idx.Seqs, seq)
+	}
+	return idx, s.Err()
+}
+
+// faiRegion is a 0-based, end exclusive range of a sequence
+type faiRegion struct {
+	name       string
+	seq        *FaiSeq
+	begin, end int64
+}
+
+// parseRegion parses name, name:begin or name:begin-end with 1-based
+// inclusive positions. The end is limited to the length of the sequence.
+func (idx *FaiIndex) parseRegion(s string) (region faiRegion, err error) {
+	find := func(name string) *FaiSeq {
+		for i := range idx.Seqs {
+			if idx.Seqs[i].Name == name {
+				return &idx.Seqs[i]
+			}
+		}
+		return nil
+	}
+	region.name = s
+	span := ""
+	if region.seq = find(s); region.seq == nil {
+		if i := strings.LastIndex(s, ":"); i >= 0 {
+			region.seq, span = find(s[:i]), s[i+1:]
+		}
+		if region.seq == nil {
+			return region, fmt.Errorf("unknown sequence in region: %s", s)
+		}
+	}
+	region.end = region.seq.Length
+	if span == "" {
+		return
+	}
+	span = strings.Replace(span, ",", "", -1)
+	begin, end := span, ""
+	if i := strings.Index(span, "-"); i >= 0 {
+		begin, end = span[:i], span[i+1:]
+	}
+	b, err := strconv.ParseInt(begin, 10, 64)
+	if err != nil || b < 1 || b > region.seq.Length {
+		return region, fmt.Errorf("invalid region: %s", s)
+	}
+	region.begin = b - 1
+	if end != "" {
+		e, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || e < b {
+			return region, fmt.Errorf("invalid region: %s", s)
+		}
+		if e < region.end {
+			region.end = e
+		}
+	}
+	return region, nil
+}
+
+// complements of the IUPAC nucleotide codes, others are kept
+var complement = func() (c [256]byte) {
+	for i := range c {
+		c[i] = byte(i)
+	}
+	for _, p := range []string{"AT", "CG", "RY", "KM", "BV", "DH"} {
+		for _, q := range []string{p, strings.ToLower(p)} {
+			c[q[0]], c[q[1]] = q[1], q[0]
+		}
+	}
+	c['U'], c['u'] = 'A', 'a'
+	return
+}()
+
+// bases reads the bases begin-end of seq from r
+func (seq *FaiSeq) bases(r io.ReaderAt, begin, end int64) ([]byte, error) {
+	pos := func(i int64) int64 {
+		return seq.Offset + i/seq.LineBases*seq.LineWidth + i%seq.LineBases
+	}
+	buf := make([]byte, pos(end-1)+1-pos(begin))
+	if n, err := r.ReadAt(buf, pos(begin)); err != nil && !(err == io.EOF && n == len(buf)) {
+		return nil, err
+	}
+	b := buf[:0]
+	for _, c := range buf {
+		if c != '\n' && c != '\r' {
+			b = append(b, c)
+		}
+	}
+	if int64(len(b)) != end-begin {
+		return nil, errors.New("fasta file does not match its fai index")
+	}
+	return b, nil
+}
+
+// Write writes the bases of the regions of the indexed fasta file r as
+// fasta, reverse complemented if revcomp is set. Errors in the regions are
+// returned before anything is written.
+func (idx *FaiIndex) Write(w io.Writer, r io.ReaderAt, regions []string, revcomp bool) (err error) {
+	regs := []faiRegion{}
+	for _, s := range regions {
+		reg, err := idx.parseRegion(s)
+		if err != nil {
+			return err
+		}
+		regs = append(regs, reg)
+	}
+	bw := bufio.NewWriter(w)
+	for _, reg := range regs {
+		name := reg.name
+		if revcomp {
+			name += "/rc"
+		}
+		if _, err = bw.WriteString(">" + name + "\n"); err != nil {
+			return
+		}
+		col := 0
+		for done := int64(0); done < reg.end-reg.begin; {
+			n := reg.end - reg.begin - done
+			if n > faiBlockSize {
+				n = faiBlockSize
+			}
+			// reverse complements are read from the end of the region
+			begin := reg.begin + done
+			if revcomp {
+				begin = reg.end - done - n
+			}
+			b, err := reg.seq.bases(r, begin, begin+n)
+			if err != nil {
+				return err
+			}
+			if revcomp {
+				for i, j := 0, len(b)-1; i <= j; i, j = i+1, j-1 {
+					b[i], b[j] = complement[b[j]], complement[b[i]]
+				}
+			}
+			for len(b) > 0 {
+				k := faiLineWidth - col
+				if k > len(b) {
+					k = len(b)
+				}
+				bw.Write(b[:k])
+				b, col = b[k:], col+k
+				if col == faiLineWidth {
+					bw.WriteByte('\n')
+					col = 0
+				}
+			}
+			done += n
+		}
+		if col > 0 {
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
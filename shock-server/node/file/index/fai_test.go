@@ -0,0 +1,116 @@
+package index_test
+
+import (
+	"bytes"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	. "github.com/MG-RAST/Shock/shock-server/node/file/index"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wrap returns s in lines of width characters
+func wrap(s string, width int) string {
+	lines := ""
+	for len(s) > width {
+		lines, s = lines+s[:width]+"\n", s[width:]
+	}
+	if s != "" {
+		lines += s + "\n"
+	}
+	return lines
+}
+
+func revcomp(s string) string {
+	r := strings.NewReplacer("A", "T", "T", "A", "C", "G", "G", "C", "a", "t", "t", "a", "c", "g", "g", "c")
+	b := []byte(r.Replace(s))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+func TestFai(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "temp"), 0755)
+	conf.Conf["data-path"] = dir
+
+	create := func(data string) (int64, error) {
+		path := filepath.Join(dir, "test.fna")
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, _ := os.Open(path)
+		idxer := Indexers["fai"](f)
+		defer idxer.Close()
+		return idxer.Create(filepath.Join(dir, "fai.idx"))
+	}
+
+	long := strings.Repeat("ACGTTGCAaccgt", 7000)
+	seqs := map[string]string{"chr1": "ACGTACGTAACCGGTT", "chr2": long, "chr:3": "acgtn"}
+	data := ">chr1 first\n" + wrap(seqs["chr1"], 5) + ">chr2\n" + wrap(long, 70) + ">chr:3\r\n" + strings.Replace(wrap(seqs["chr:3"], 2), "\n", "\r\n", -1)
+	if count, err := create(data); err != nil || count != 3 {
+		t.Fatalf("got %d, %v", count, err)
+	}
+	text, _ := ioutil.ReadFile(filepath.Join(dir, "fai.idx"))
+	if want := "chr1\t16\t12\t5\t6\n"; !strings.HasPrefix(string(text), want) {
+		t.Errorf("got index %q, want it to start with %q", text, want)
+	}
+	idx, err := LoadFaiIndex(filepath.Join(dir, "fai.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		region string
+		seq    string
+	}{
+		{"chr1", seqs["chr1"]},
+		{"chr1:5", seqs["chr1"][4:]},
+		{"chr1:5-11", seqs["chr1"][4:11]},
+		{"chr1:16-100", "T"},
+		{"chr2:65,000-70,500", long[64999:70500]},
+		{"chr2", long},
+		{"chr:3", seqs["chr:3"]},
+		{"chr:3:2-3", "cg"},
+	} {
+		for _, rc := range []bool{false, true} {
+			var out bytes.Buffer
+			f, _ := os.Open(filepath.Join(dir, "test.fna"))
+			err := idx.Write(&out, f, []string{test.region}, rc)
+			f.Close()
+			want := ">" + test.region + "\n" + wrap(test.seq, 60)
+			if rc {
+				want = ">" + test.region + "/rc\n" + wrap(revcomp(test.seq), 60)
+			}
+			if err != nil {
+				t.Errorf("%s: %s", test.region, err)
+			} else if out.String() != want {
+				t.Errorf("%s, revcomp %v: got %.100q, want %.100q", test.region, rc, out.String(), want)
+			}
+		}
+	}
+	for _, region := range []string{"chr4", "chr1:0-5", "chr1:17", "chr1:5-4", "chr1:x"} {
+		if err := idx.Write(ioutil.Discard, nil, []string{region}, false); err == nil {
+			t.Errorf("%s: no error", region)
+		}
+	}
+
+	for _, data := range []string{
+		">a\nACGT\nAC\nACGT\n",
+		">a\nACGT\nACGTA\n",
+		">a\nACGT\n\nACGT\n",
+		">a\nACGT\n>a\nACGT\n",
+		"@r1\nACGT\n+\nIIII\n",
+	} {
+		if _, err := create(data); err == nil {
+			t.Errorf("%q: indexed", data)
+		}
+	}
+}
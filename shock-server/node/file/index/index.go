@@ -17,6 +17,11 @@ var (
 		"record":      NewRecordIndexer,
 		"size":        NewSizeIndexer,
 		"chunkrecord": NewChunkRecordIndexer,
+		"bai":         NewBaiIndexer,
+		"tabix":       NewTabixIndexer,
+		"interval":    NewIntervalIndexer,
+		"name":        NewNameIndexer,
+		"fai":         NewFaiIndexer,
 	}
 )
 
@@ -33,6 +38,12 @@ type Index interface {
 	Load(string) error
 }
 
+// RangeIndex is an index whose parts can be scattered over the file, as
+// several {offset, length} ranges
+type RangeIndex interface {
+	Ranges(string) ([][]int64, error)
+}
+
 type Idx struct {
 	T      string
 	Idx    [][]int64
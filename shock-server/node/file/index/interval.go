@@ -0,0 +1,267 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/bed"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/gff"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/gtf"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/multi"
+	"io"
+	"os"
+	"sort"
+)
+
+// line layouts of the annotation formats the interval index supports
+var intervalFormats = map[string]struct {
+	isHeader func([]byte) bool
+	interval func([]byte) (string, int, int, error)
+}{
+	"bed": {bed.IsHeader, bed.Interval},
+	"gff": {gff.IsHeader, gff.Interval},
+	"gtf": {gff.IsHeader, gtf.Interval},
+}
+
+type intervalRec struct {
+	Ref    int32
+	Begin  int32 // 0-based
+	End    int32 // exclusive
+	Offset int64
+	Length int64
+}
+
+// IntervalIndex indexes the features of an annotation file sorted by
+// position, so that the byte range of the features in a region can be
+// downloaded as an index part.
+type IntervalIndex struct {
+	Names  []string
+	Recs   []intervalRec
+	first  []int   // index of the first record of each sequence
+	maxEnd []int32 // largest end of the records of a sequence so far
+}
+
+func NewInterval() *IntervalIndex {
+	return &IntervalIndex{}
+}
+
+type interval struct {
+	f *os.File
+}
+
+// NewIntervalIndexer returns an indexer of the sorted bed, gff or gtf file f
+func NewIntervalIndexer(f *os.File) Indexer {
+	return &interval{f: f}
+}
+
+// Create builds the interval index of the file and writes it to file. It
+// returns the number of features.
+func (i *interval) Create(file string) (count int64, err error) {
+	format, err := multi.NewReader(i.f).Type()
+	if err != nil {
+		return
+	}
+	layout, ok := intervalFormats[format]
+	if !ok {
+		return 0, errors.New("interval index requires a bed, gff or gtf file")
+	}
+	if _, err = i.f.Seek(0, 0); err != nil {
+		return
+	}
+	idx := NewInterval()
+	ids := map[string]int{}
+	last, lastBegin := -1, -1
+	r := bufio.NewReader(i.f)
+	offset := int64(0)
+	for n := 1; ; n++ {
+		line, er := r.ReadBytes('\n')
+		if len(line) == 0 && er != nil {
+			if er != io.EOF {
+				return 0, er
+			}
+			break
+		}
+		curr := offset
+		offset += int64(len(line))
+		// gff files may end with the sequences of the features
+		if bytes.HasPrefix(line, []byte("##FASTA")) {
+			break
+		}
+		if layout.isHeader(line) {
+			continue
+		}
+		name, begin, end, er := layout.interval(line)
+		if er != nil {
+			return 0, fmt.Errorf("line %d: %s", n, er.Error())
+		} else if begin >= maxPos {
+			return 0, fmt.Errorf("line %d: position exceeds %d", n, maxPos)
+		} else if end > maxPos {
+			end = maxPos
+		}
+		id, ok := ids[name]
+		if !ok {
+			id = len(idx.Names)
+			ids[name] = id
+			idx.Names = append(idx.Names, name)
+		} else if id != last || begin < lastBegin {
+			return 0, fmt.Errorf("line %d: file is not sorted by position", n)
+		}
+		last, lastBegin = id, begin
+		idx.Recs = append(idx.Recs, intervalRec{Ref: int32(id), Begin: int32(begin), End: int32(end), Offset: curr, Length: int64(len(line))})
+	}
+	if err = writeIndexFile(file, idx.write); err != nil {
+		return
+	}
+	return int64(len(idx.Recs)), nil
+}
+
+func (i *interval) Close() (err error) {
+	i.f.Close()
+	return
+}
+
+// write writes the sequence names followed by the records
+func (idx *IntervalIndex) write(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+	bw.Write([]byte("IVL\x01"))
+	binary.Write(bw, le, int32(len(idx.Names)))
+	for _, name := range idx.Names {
+		binary.Write(bw, le, int32(len(name)))
+		bw.WriteString(name)
+	}
+	binary.Write(bw, le, int64(len(idx.Recs)))
+	for _, rec := range idx.Recs {
+		if err = binary.Write(bw, le, rec); err != nil {
+			return
+		}
+	}
+	return bw.Flush()
+}
+
+func (idx *IntervalIndex) Load(file string) (err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	le := binary.LittleEndian
+	invalid := errors.New("invalid interval index")
+	magic := make([]byte, 4)
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != "IVL\x01" {
+		return invalid
+	}
+	var nNames int32
+	if err = binary.Read(r, le, &nNames); err != nil || nNames < 0 {
+		return invalid
+	}
+	for i := int32(0); i < nNames; i++ {
+		var l int32
+		if err = binary.Read(r, le, &l); err != nil || l < 0 {
+			return invalid
+		}
+		name := make([]byte, l)
+		if _, err = io.ReadFull(r, name); err != nil {
+			return invalid
+		}
+		idx.Names = append(idx.Names, string(name))
+	}
+	var nRecs int64
+	if err = binary.Read(r, le, &nRecs); err != nil || nRecs < 0 {
+		return invalid
+	}
+	for i := int64(0); i < nRecs; i++ {
+		var rec intervalRec
+		if err = binary.Read(r, le, &rec); err != nil || rec.Ref < 0 || int(rec.Ref) >= len(idx.Names) {
+			return invalid
+		}
+		idx.Append([]int64{int64(rec.Ref), int64(rec.Begin), int64(rec.End), rec.Offset, rec.Length})
+	}
+	idx.first = append(idx.first, len(idx.Recs))
+	return nil
+}
+
+func (idx *IntervalIndex) Set(inter map[string]interface{}) {
+	return
+}
+
+func (idx *IntervalIndex) Type() string {
+	return "interval"
+}
+
+// Append adds the record {ref, begin, end, offset, length}, records must
+// be appended grouped by ref and sorted by begin
+func (idx *IntervalIndex) Append(rec []int64) {
+	r := intervalRec{Ref: int32(rec[0]), Begin: int32(rec[1]), End: int32(rec[2]), Offset: rec[3], Length: rec[4]}
+	n := len(idx.Recs)
+	if n == 0 || idx.Recs[n-1].Ref != r.Ref {
+		idx.first = append(idx.first, n)
+		idx.maxEnd = append(idx.maxEnd, r.End)
+	} else if prev := idx.maxEnd[n-1]; prev > r.End {
+		idx.maxEnd = append(idx.maxEnd, prev)
+	} else {
+		idx.maxEnd = append(idx.maxEnd, r.End)
+	}
+	idx.Recs = append(idx.Recs, r)
+}
+
+// refId returns the index of the sequence called name, -1 if there is none
+func (idx *IntervalIndex) refId(name string) int {
+	for i, n := range idx.Names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Ranges returns the byte ranges, {offset, length}, of the features
+// overlapping a region, chrom[:start[-end]] with 1-based positions. Features
+// in between ending before the region are left out, adjacent features are
+// merged into one range. There are no ranges if there are no overlapping
+// features.
+func (idx *IntervalIndex) Ranges(part string) (ranges [][]int64, err error) {
+	reg, err := parseRegion(part, idx.refId)
+	if err != nil {
+		return
+	}
+	// records of the sequence, in order of begin and of maxEnd
+	lo, hi := idx.first[reg.Ref], idx.first[reg.Ref+1]
+	i := lo + sort.Search(hi-lo, func(k int) bool { return idx.maxEnd[lo+k] > reg.Begin })
+	j := lo + sort.Search(hi-lo, func(k int) bool { return idx.Recs[lo+k].Begin >= reg.End })
+	ranges = [][]int64{}
+	if i >= j {
+		return
+	}
+	for _, rec := range idx.Recs[i:j] {
+		if rec.End <= reg.Begin {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1][0]+ranges[n-1][1] == rec.Offset {
+			ranges[n-1][1] += rec.Length
+		} else {
+			ranges = append(ranges, []int64{rec.Offset, rec.Length})
+		}
+	}
+	return
+}
+
+// Part returns the byte range of the features overlapping a region as a
+// single range. It fails if features ending before the region lie in
+// between, Ranges returns the ranges of those regions.
+func (idx *IntervalIndex) Part(part string) (pos int64, length int64, err error) {
+	ranges, err := idx.Ranges(part)
+	if err != nil {
+		return
+	}
+	switch len(ranges) {
+	case 0:
+		return 0, 0, nil
+	case 1:
+		return ranges[0][0], ranges[0][1], nil
+	}
+	return 0, 0, errors.New("features overlapping " + part + " are not contiguous")
+}
@@ -0,0 +1,107 @@
+package index_test
+
+import (
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	. "github.com/MG-RAST/Shock/shock-server/node/file/index"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var gffData = "##gff-version 3\n" +
+	"chr1\t.\tgene\t100\t5000\t.\t+\t.\tID=a\n" +
+	"chr1\t.\texon\t100\t200\t.\t+\t.\tID=b\n" +
+	"chr1\t.\texon\t1000\t1200\t.\t+\t.\tID=c\n" +
+	"chr1\t.\tgene\t8000\t9000\t.\t-\t.\tID=d\n" +
+	"chr2\t.\tgene\t10\t20\t.\t+\t.\tID=e\n" +
+	"##FASTA\n>chr1\nACGT\n"
+
+// intervalPart returns the ids of the features in the ranges of the part
+// of the interval index
+func intervalPart(t *testing.T, data string, idx RangeIndex, part string) (string, error) {
+	ranges, err := idx.Ranges(part)
+	if err != nil {
+		return "", err
+	}
+	ids := []string{}
+	for _, rg := range ranges {
+		for _, line := range strings.Split(strings.TrimSpace(data[rg[0]:rg[0]+rg[1]]), "\n") {
+			fields := strings.Split(line, "\t")
+			ids = append(ids, strings.TrimPrefix(fields[len(fields)-1], "ID="))
+		}
+	}
+	return strings.Join(ids, ","), nil
+}
+
+func TestInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "interval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "temp"), 0755)
+	conf.Conf["data-path"] = dir
+
+	create := func(data string) (int64, error) {
+		path := filepath.Join(dir, "test.gff")
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idxer := Indexers["interval"](f)
+		defer idxer.Close()
+		return idxer.Create(filepath.Join(dir, "interval.idx"))
+	}
+
+	if count, err := create(gffData); err != nil || count != 5 {
+		t.Fatalf("got %d, %v", count, err)
+	}
+	idx := NewInterval()
+	if err := idx.Load(filepath.Join(dir, "interval.idx")); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		part string
+		ids  string
+	}{
+		{"chr1", "a,b,c,d"},
+		{"chr1:150-160", "a,b"},
+		// b ends before the region
+		{"chr1:201-999", "a"},
+		{"chr1:201-1000", "a,c"},
+		{"chr1:300-1000", "a,c"},
+		{"chr1:5001-7999", ""},
+		{"chr1:8500", "d"},
+		{"chr2:1-9", ""},
+		{"chr2:20-20", "e"},
+	} {
+		if ids, err := intervalPart(t, gffData, idx, test.part); err != nil {
+			t.Errorf("part %s: %s", test.part, err)
+		} else if ids != test.ids {
+			t.Errorf("part %s: got %q, want %q", test.part, ids, test.ids)
+		}
+	}
+	for _, part := range []string{"chr3", "1", "chr1:0-10", "chr1:201-1000"} {
+		if _, _, err := idx.Part(part); err == nil {
+			t.Errorf("part %s: no error", part)
+		}
+	}
+	if pos, length, err := idx.Part("chr1:150-160"); err != nil || gffData[pos:pos+length] != strings.Join(strings.Split(gffData, "\n")[1:3], "\n")+"\n" {
+		t.Errorf("part chr1:150-160: got %d, %d, %v", pos, length, err)
+	}
+
+	if _, err := create("##gff-version 3\nchr1\t.\tgene\t100\t500\t.\t+\t.\tID=a\nchr1\t.\tgene\t10\t50\t.\t+\t.\tID=b\n"); err == nil {
+		t.Errorf("indexed unsorted features")
+	}
+	if count, err := create("track name=x\nchr1\t10\t20\tx\nchr1\t15\t30\ty"); err != nil || count != 2 {
+		t.Errorf("bed: got %d, %v", count, err)
+	}
+	if _, err := create(">seq1\nACGT\n"); err == nil {
+		t.Errorf("indexed fasta file")
+	}
+}
@@ -0,0 +1,180 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/multi"
+	"io"
+	"os"
+	"sort"
+)
+
+// nameEntry is an entry of the name index, the byte range of a record and
+// the position of its name in the names section
+type nameEntry struct {
+	Offset  int64
+	Length  int64
+	NameOff int64
+	NameLen int32
+}
+
+const nameEntrySize = 28
+
+type nameIdx struct {
+	f *os.File
+}
+
+// NewNameIndexer returns an indexer of the records of f by their id, the
+// first word of the id of sequence formats
+func NewNameIndexer(f *os.File) Indexer {
+	return &nameIdx{f: f}
+}
+
+// Create reads the ids of the records and writes the name index to file:
+// the entries sorted by name, followed by the names. It returns the number
+// of records.
+func (i *nameIdx) Create(file string) (count int64, err error) {
+	fi, err := i.f.Stat()
+	if err != nil {
+		return
+	}
+	// one reader for the ids and one for the record lengths, read in step
+	ids := multi.NewReader(io.NewSectionReader(i.f, 0, fi.Size()))
+	offsets := multi.NewReader(io.NewSectionReader(i.f, 0, fi.Size()))
+	entries := []nameEntry{}
+	names := []byte{}
+	curr := int64(0)
+	for {
+		n, er := offsets.GetReadOffset()
+		if er != nil {
+			if er != io.EOF {
+				return 0, er
+			}
+			break
+		}
+		s, er := ids.Read()
+		if er != nil {
+			if er == io.EOF {
+				er = errors.New("record without id")
+			}
+			return 0, er
+		}
+		id := s.ID
+		if fields := bytes.Fields(id); len(fields) > 0 {
+			id = fields[0]
+		}
+		entries = append(entries, nameEntry{Offset: curr, Length: int64(n), NameOff: int64(len(names)), NameLen: int32(len(id))})
+		names = append(names, id...)
+		curr += int64(n)
+	}
+	// records with the same name stay in file order
+	sort.Stable(byName{entries, names})
+	err = writeIndexFile(file, func(w io.Writer) (err error) {
+		bw := bufio.NewWriter(w)
+		bw.Write([]byte("NAM\x01"))
+		binary.Write(bw, binary.LittleEndian, int64(len(entries)))
+		for _, e := range entries {
+			if err = binary.Write(bw, binary.LittleEndian, e); err != nil {
+				return
+			}
+		}
+		bw.Write(names)
+		return bw.Flush()
+	})
+	if err != nil {
+		return
+	}
+	return int64(len(entries)), nil
+}
+
+func (i *nameIdx) Close() (err error) {
+	i.f.Close()
+	return
+}
+
+type byName struct {
+	entries []nameEntry
+	names   []byte
+}
+
+func (b byName) Len() int      { return len(b.entries) }
+func (b byName) Swap(i, j int) { b.entries[i], b.entries[j] = b.entries[j], b.entries[i] }
+func (b byName) Less(i, j int) bool {
+	return bytes.Compare(b.name(b.entries[i]), b.name(b.entries[j])) < 0
+}
+func (b byName) name(e nameEntry) []byte {
+	return b.names[e.NameOff : e.NameOff+int64(e.NameLen)]
+}
+
+// NameIndex looks up records in a name index file without loading it,
+// by binary search on the sorted entries.
+type NameIndex struct {
+	f     *os.File
+	count int64
+}
+
+// OpenNameIndex opens the name index file, it must be closed after use
+func OpenNameIndex(file string) (idx *NameIndex, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	header := make([]byte, 12)
+	if _, err = io.ReadFull(f, header); err != nil || string(header[:4]) != "NAM\x01" {
+		f.Close()
+		return nil, errors.New("invalid name index")
+	}
+	return &NameIndex{f: f, count: int64(binary.LittleEndian.Uint64(header[4:]))}, nil
+}
+
+func (idx *NameIndex) Close() error {
+	return idx.f.Close()
+}
+
+// entry reads the ith entry and its name
+func (idx *NameIndex) entry(i int64) (e nameEntry, name []byte, err error) {
+	buf := make([]byte, nameEntrySize)
+	if _, err = idx.f.ReadAt(buf, 12+i*nameEntrySize); err != nil {
+		return
+	}
+	if err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &e); err != nil {
+		return
+	}
+	if e.NameLen < 0 {
+		return e, nil, errors.New("invalid name index")
+	}
+	name = make([]byte, e.NameLen)
+	_, err = idx.f.ReadAt(name, 12+idx.count*nameEntrySize+e.NameOff)
+	return
+}
+
+// Lookup returns the offsets and lengths of the records called id, in
+// file order, or an empty list if there are none
+func (idx *NameIndex) Lookup(id string) (recs [][]int64, err error) {
+	key := []byte(id)
+	// sort.Search cannot return errors, the first one is kept
+	var er error
+	i := sort.Search(int(idx.count), func(k int) bool {
+		_, name, e := idx.entry(int64(k))
+		if e != nil && er == nil {
+			er = e
+		}
+		return bytes.Compare(name, key) >= 0
+	})
+	if er != nil {
+		return nil, er
+	}
+	recs = [][]int64{}
+	for k := int64(i); k < idx.count; k++ {
+		e, name, err := idx.entry(k)
+		if err != nil {
+			return nil, err
+		} else if !bytes.Equal(name, key) {
+			break
+		}
+		recs = append(recs, []int64{e.Offset, e.Length})
+	}
+	return recs, nil
+}
@@ -0,0 +1,71 @@
+package index_test
+
+import (
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	. "github.com/MG-RAST/Shock/shock-server/node/file/index"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "temp"), 0755)
+	conf.Conf["data-path"] = dir
+
+	for _, test := range []struct {
+		data    string
+		count   int64
+		lookups map[string]string
+	}{
+		{">contig2 len=8\nACGTACGT\nAC\n>contig1\nTTTT\n>contig3 dup\nGG\n>contig3\nCC\n", 4,
+			map[string]string{
+				"contig1": ">contig1\nTTTT\n",
+				"contig2": ">contig2 len=8\nACGTACGT\nAC\n",
+				"contig3": ">contig3 dup\nGG\n>contig3\nCC",
+				"contig":  "",
+			}},
+		{"@r2 1:N\nACGT\n+\nIIII\n@r1\nGG\n+\nII\n", 2,
+			map[string]string{
+				"r1": "@r1\nGG\n+\nII\n",
+				"r2": "@r2 1:N\nACGT\n+\nIIII\n",
+				"r3": "",
+			}},
+	} {
+		path := filepath.Join(dir, "test.seq")
+		if err := ioutil.WriteFile(path, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, _ := os.Open(path)
+		idxer := Indexers["name"](f)
+		count, err := idxer.Create(filepath.Join(dir, "name.idx"))
+		idxer.Close()
+		if err != nil || count != test.count {
+			t.Fatalf("got %d, %v", count, err)
+		}
+		idx, err := OpenNameIndex(filepath.Join(dir, "name.idx"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id, want := range test.lookups {
+			recs, err := idx.Lookup(id)
+			if err != nil {
+				t.Errorf("%s: %s", id, err)
+				continue
+			}
+			got := ""
+			for _, rec := range recs {
+				got += test.data[rec[0] : rec[0]+rec[1]]
+			}
+			if got != want {
+				t.Errorf("%s: got %q, want %q", id, got, want)
+			}
+		}
+		idx.Close()
+	}
+}
@@ -0,0 +1,381 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/node/file/format/vcf"
+	"io"
+	"os"
+	"strconv"
+)
+
+// tabix formats and flags
+const (
+	tbxGeneric   = 0
+	tbxVcf       = 2
+	tbxZeroBased = 0x10000
+)
+
+// TabixIndex is a tabix index of a tab separated text file sorted by
+// position, plain or bgzip compressed. For plain files the offsets in the
+// index are byte offsets shifted left 16 bits.
+type TabixIndex struct {
+	Format int32 // tbxGeneric or tbxVcf, ored with tbxZeroBased
+	Seq    int32 // 1-based columns of the sequence name and range, End
+	Begin  int32 // is 0 if the end is derived from the record
+	End    int32
+	Meta   byte  // lines starting with Meta are headers
+	Skip   int32 // number of header lines at the start
+	Names  []string
+	Refs   []BaiRef
+	NoCoor uint64
+}
+
+// column layouts of the formats tabix can index
+var tabixPresets = map[string]TabixIndex{
+	"vcf": {Format: tbxVcf, Seq: 1, Begin: 2, End: 0, Meta: '#'},
+}
+
+type tabix struct {
+	f *os.File
+}
+
+// NewTabixIndexer returns an indexer of the sorted text file f, which
+// may be bgzip compressed
+func NewTabixIndexer(f *os.File) Indexer {
+	return &tabix{f: f}
+}
+
+// Create builds the tabix index of the file and writes it to file. It
+// returns the number of records.
+func (i *tabix) Create(file string) (count int64, err error) {
+	format, err := tabixFormat(i.f)
+	if err != nil {
+		return
+	}
+	idx := tabixPresets[format]
+	if count, err = idx.build(i.f); err != nil {
+		return
+	}
+	err = writeIndexFile(file, idx.write)
+	return
+}
+
+func (i *tabix) Close() (err error) {
+	i.f.Close()
+	return
+}
+
+// tabixFormat returns the preset for the content of r
+func tabixFormat(r io.ReadSeeker) (format string, err error) {
+	if _, err = r.Seek(0, 0); err != nil {
+		return
+	}
+	br := bufio.NewReader(r)
+	var head io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		if head, err = gzip.NewReader(br); err != nil {
+			return
+		}
+	}
+	buf := make([]byte, 32768)
+	n, err := io.ReadFull(head, buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	} else if err != nil {
+		return
+	}
+	if _, err = r.Seek(0, 0); err != nil {
+		return
+	}
+	if vcf.Regex.Match(buf[:n]) {
+		return "vcf", nil
+	}
+	return "", errors.New("tabix index requires a vcf file")
+}
+
+// lineReader reads the lines of a file and the offsets they start at
+type lineReader interface {
+	ReadLine() ([]byte, error)
+	Offset() uint64
+	Seek(uint64) error
+}
+
+// plainReader is a lineReader of an uncompressed file
+type plainReader struct {
+	r   io.ReadSeeker
+	br  *bufio.Reader
+	off int64
+}
+
+func (p *plainReader) ReadLine() (line []byte, err error) {
+	line, err = p.br.ReadBytes('\n')
+	p.off += int64(len(line))
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return
+}
+
+func (p *plainReader) Offset() uint64 {
+	return uint64(p.off) << 16
+}
+
+func (p *plainReader) Seek(voff uint64) (err error) {
+	off := int64(voff >> 16)
+	if _, err = p.r.Seek(off, 0); err != nil {
+		return
+	}
+	p.br.Reset(p.r)
+	p.off = off
+	return
+}
+
+// newLineReader returns a lineReader of r, which may be bgzip compressed
+func newLineReader(r io.ReadSeeker) (lineReader, error) {
+	magic := make([]byte, 2)
+	n, _ := io.ReadFull(r, magic)
+	if _, err := r.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	if n == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return NewBgzfReader(r), nil
+	}
+	return &plainReader{r: r, br: bufio.NewReader(r)}, nil
+}
+
+// isHeader returns true if the nth line, counted from 1, is a header line
+func (idx *TabixIndex) isHeader(n int, line []byte) bool {
+	return n <= int(idx.Skip) || (len(line) > 0 && line[0] == idx.Meta)
+}
+
+// interval returns the sequence name and the 0-based, end exclusive range
+// of the record on a line
+func (idx *TabixIndex) interval(line []byte) (name string, begin int, end int, err error) {
+	if idx.Format&0xffff == tbxVcf {
+		return vcf.Interval(line)
+	}
+	fields := bytes.Split(bytes.TrimRight(line, "\r\n"), []byte("\t"))
+	if int(idx.Seq) > len(fields) || int(idx.Begin) > len(fields) || int(idx.End) > len(fields) {
+		return "", 0, 0, errors.New("too few columns")
+	}
+	name = string(fields[idx.Seq-1])
+	if begin, err = strconv.Atoi(string(fields[idx.Begin-1])); err != nil {
+		return "", 0, 0, errors.New("invalid start position")
+	}
+	if idx.Format&tbxZeroBased == 0 {
+		begin--
+	}
+	// the 1-based inclusive end equals the 0-based exclusive end
+	end = begin + 1
+	if idx.End > 0 {
+		if end, err = strconv.Atoi(string(fields[idx.End-1])); err != nil {
+			return "", 0, 0, errors.New("invalid end position")
+		}
+	}
+	if begin < 0 {
+		return "", 0, 0, errors.New("invalid start position")
+	} else if end <= begin {
+		end = begin + 1
+	}
+	return
+}
+
+// build indexes the records of r, which must be grouped by sequence and
+// sorted by start position
+func (idx *TabixIndex) build(r io.ReadSeeker) (count int64, err error) {
+	lr, err := newLineReader(r)
+	if err != nil {
+		return
+	}
+	ids := map[string]int{}
+	last, lastBegin := -1, -1
+	for n := 1; ; n++ {
+		offset := lr.Offset()
+		line, er := lr.ReadLine()
+		if er == io.EOF {
+			break
+		} else if er != nil {
+			return 0, er
+		}
+		if idx.isHeader(n, line) || len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		name, begin, end, er := idx.interval(line)
+		if er != nil {
+			return 0, fmt.Errorf("line %d: %s", n, er.Error())
+		} else if begin >= maxPos {
+			return 0, fmt.Errorf("line %d: position exceeds %d", n, maxPos)
+		} else if end > maxPos {
+			end = maxPos
+		}
+		id, ok := ids[name]
+		if !ok {
+			id = len(idx.Names)
+			ids[name] = id
+			idx.Names = append(idx.Names, name)
+			idx.Refs = append(idx.Refs, BaiRef{})
+		} else if id != last || begin < lastBegin {
+			return 0, fmt.Errorf("line %d: file is not sorted by position", n)
+		}
+		last, lastBegin = id, begin
+		idx.Refs[id].add(int32(begin), int32(end), offset, lr.Offset(), true)
+		count++
+	}
+	for i := range idx.Refs {
+		idx.Refs[i].finish()
+	}
+	return
+}
+
+// write writes the index in the layout of tabix .tbi files, gzip
+// compressed
+func (idx *TabixIndex) write(w io.Writer) (err error) {
+	gz := gzip.NewWriter(w)
+	le := binary.LittleEndian
+	names := []byte{}
+	for _, name := range idx.Names {
+		names = append(append(names, name...), 0)
+	}
+	gz.Write([]byte("TBI\x01"))
+	for _, v := range []int32{int32(len(idx.Refs)), idx.Format, idx.Seq, idx.Begin, idx.End, int32(idx.Meta), idx.Skip, int32(len(names))} {
+		binary.Write(gz, le, v)
+	}
+	gz.Write(names)
+	if err = writeRefs(gz, idx.Refs); err != nil {
+		return
+	}
+	if err = binary.Write(gz, le, idx.NoCoor); err != nil {
+		return
+	}
+	return gz.Close()
+}
+
+// LoadTabixIndex reads the tabix index file
+func LoadTabixIndex(file string) (idx *TabixIndex, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, errors.New("not a tabix index")
+	}
+	r := bufio.NewReader(gz)
+	le := binary.LittleEndian
+	magic := make([]byte, 4)
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != "TBI\x01" {
+		return nil, errors.New("not a tabix index")
+	}
+	var n, meta, lNames int32
+	idx = &TabixIndex{}
+	for _, v := range []*int32{&n, &idx.Format, &idx.Seq, &idx.Begin, &idx.End, &meta, &idx.Skip, &lNames} {
+		if err = binary.Read(r, le, v); err != nil {
+			return nil, err
+		}
+	}
+	idx.Meta = byte(meta)
+	if lNames < 0 {
+		return nil, errors.New("invalid tabix index")
+	}
+	names := make([]byte, lNames)
+	if _, err = io.ReadFull(r, names); err != nil {
+		return nil, err
+	}
+	for _, name := range bytes.Split(bytes.TrimRight(names, "\x00"), []byte{0}) {
+		if len(name) > 0 {
+			idx.Names = append(idx.Names, string(name))
+		}
+	}
+	if int(n) != len(idx.Names) {
+		return nil, errors.New("invalid tabix index")
+	}
+	if idx.Refs, err = readRefs(r, n); err != nil {
+		return nil, err
+	}
+	binary.Read(r, le, &idx.NoCoor)
+	return idx, nil
+}
+
+// refId returns the index of the sequence called name, -1 if there is none
+func (idx *TabixIndex) refId(name string) int {
+	for i, n := range idx.Names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Write writes the records of the indexed file r overlapping region,
+// preceded by the header lines if header is set. Errors in the region
+// are returned before anything is written.
+func (idx *TabixIndex) Write(w io.Writer, r io.ReadSeeker, region string, header bool) (err error) {
+	reg, err := parseRegion(region, idx.refId)
+	if err != nil {
+		return
+	}
+	lr, err := newLineReader(r)
+	if err != nil {
+		return
+	}
+	writeLine := func(line []byte) (err error) {
+		if _, err = w.Write(line); err == nil && line[len(line)-1] != '\n' {
+			_, err = w.Write([]byte{'\n'})
+		}
+		return
+	}
+	if header {
+		for n := 1; ; n++ {
+			line, er := lr.ReadLine()
+			if er == io.EOF {
+				break
+			} else if er != nil {
+				return er
+			}
+			if !idx.isHeader(n, line) {
+				break
+			}
+			if err = writeLine(line); err != nil {
+				return
+			}
+		}
+	}
+chunks:
+	for _, c := range idx.Refs[reg.Ref].chunks(reg.Begin, reg.End) {
+		if err = lr.Seek(c.Begin); err != nil {
+			return
+		}
+		for lr.Offset() < c.End {
+			line, er := lr.ReadLine()
+			if er == io.EOF {
+				break chunks
+			} else if er != nil {
+				return er
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			name, begin, end, er := idx.interval(line)
+			if er != nil {
+				return er
+			}
+			// records are sorted by position
+			if name != idx.Names[reg.Ref] || begin >= int(reg.End) {
+				break chunks
+			}
+			if end <= int(reg.Begin) {
+				continue
+			}
+			if err = writeLine(line); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
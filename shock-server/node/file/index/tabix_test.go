@@ -0,0 +1,145 @@
+package index_test
+
+import (
+	"bytes"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	. "github.com/MG-RAST/Shock/shock-server/node/file/index"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var vcfHeader = "##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n"
+
+var vcfRecords = []string{
+	"1\t100\ta\tA\tG\t.\tPASS\t.\n",
+	"1\t1500\tb\tACGT\tA\t.\tPASS\t.\n",
+	"1\t1502\tc\tG\tT\t.\tPASS\t.\n",
+	"1\t70000\td\tN\t<DEL>\t.\tPASS\tEND=90000\n",
+	"1\t95000\te\tC\tT\t.\tPASS\t.\n",
+	"2\t10\tf\tT\tC\t.\tPASS\t.\n",
+	"X\t5\tg\tT\tC\t.\tPASS\t.",
+}
+
+// tabixFile writes the test vcf, bgzip compressed with a block per
+// record if bgzip is set, and indexes it
+func tabixFile(t *testing.T, dir string, bgzip bool, records []string) (string, error) {
+	path := filepath.Join(dir, "test.vcf")
+	data := []byte(vcfHeader + strings.Join(records, ""))
+	if bgzip {
+		data = bgzfBlock([]byte(vcfHeader))
+		for _, rec := range records {
+			data = append(data, bgzfBlock([]byte(rec))...)
+		}
+		data = append(data, bgzfBlock(nil)...)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idxer := Indexers["tabix"](f)
+	defer idxer.Close()
+	count, err := idxer.Create(filepath.Join(dir, "tabix.idx"))
+	if err == nil && count != int64(len(records)) {
+		t.Errorf("indexed %d records, want %d", count, len(records))
+	}
+	return path, err
+}
+
+func TestTabix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tabix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "temp"), 0755)
+	conf.Conf["data-path"] = dir
+
+	for _, bgzip := range []bool{false, true} {
+		path, err := tabixFile(t, dir, bgzip, vcfRecords)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx, err := LoadTabixIndex(filepath.Join(dir, "tabix.idx"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range []struct {
+			region string
+			ids    string
+		}{
+			{"1", "a,b,c,d,e"},
+			{"1:100", "a,b,c,d,e"},
+			{"1:101-1499", ""},
+			{"1:1501-1501", "b"},
+			{"1:1502-1502", "b,c"},
+			{"1:1503-1503", "b"},
+			{"1:1504-70000", "d"},
+			{"1:85,000-94,999", "d"},
+			{"1:90001-95000", "e"},
+			{"2", "f"},
+			{"X:1-5", "g"},
+		} {
+			f, _ := os.Open(path)
+			var out bytes.Buffer
+			err := idx.Write(&out, f, test.region, false)
+			f.Close()
+			ids := []string{}
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				if line != "" {
+					ids = append(ids, strings.Split(line, "\t")[2])
+				}
+			}
+			if err != nil {
+				t.Errorf("bgzip %v, region %s: %s", bgzip, test.region, err)
+			} else if got := strings.Join(ids, ","); got != test.ids {
+				t.Errorf("bgzip %v, region %s: got %q, want %q", bgzip, test.region, got, test.ids)
+			}
+		}
+
+		f, _ := os.Open(path)
+		var out bytes.Buffer
+		err = idx.Write(&out, f, "X", true)
+		f.Close()
+		if want := vcfHeader + vcfRecords[6] + "\n"; err != nil || out.String() != want {
+			t.Errorf("bgzip %v, with header: got %q, %v, want %q", bgzip, out.String(), err, want)
+		}
+		for _, region := range []string{"3", "1:0", "1:x-10"} {
+			if err := idx.Write(&out, f, region, false); err == nil {
+				t.Errorf("bgzip %v, region %s: no error", bgzip, region)
+			}
+		}
+	}
+
+	// blank lines inside a chunk, as an index made by another tool may
+	// cover, are skipped
+	path, err := tabixFile(t, dir, false, vcfRecords[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := LoadTabixIndex(filepath.Join(dir, "tabix.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blank := strings.Repeat(" ", len(vcfRecords[1])-1) + "\n"
+	ioutil.WriteFile(path, []byte(vcfHeader+vcfRecords[0]+blank+vcfRecords[2]), 0644)
+	f, _ := os.Open(path)
+	var out bytes.Buffer
+	err = idx.Write(&out, f, "1", false)
+	f.Close()
+	if want := vcfRecords[0] + vcfRecords[2]; err != nil || out.String() != want {
+		t.Errorf("with blank lines: got %q, %v, want %q", out.String(), err, want)
+	}
+
+	if _, err := tabixFile(t, dir, false, []string{vcfRecords[1], vcfRecords[0]}); err == nil {
+		t.Errorf("indexed unsorted records")
+	}
+	if _, err := tabixFile(t, dir, false, []string{vcfRecords[0], vcfRecords[5], vcfRecords[1]}); err == nil {
+		t.Errorf("indexed records not grouped by sequence")
+	}
+}
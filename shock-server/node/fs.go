@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/node/file/archive"
+	"github.com/MG-RAST/Shock/shock-server/quota"
+	"github.com/MG-RAST/Shock/shock-server/storage"
 	"math/rand"
 	"os"
 	"syscall"
@@ -15,7 +18,13 @@ func (node *Node) SetFile(file FormFile) (err error) {
 	if err != nil {
 		return
 	}
-	os.Rename(file.Path, node.FilePath())
+	if err = quota.Reserve(node.Acl.Owner, fileStat.Size(), 0); err != nil {
+		return
+	}
+	defer quota.Unreserve(node.Acl.Owner, fileStat.Size(), 0)
+	if err = node.setBlob(file.Path, file.Checksum["md5"], fileStat.Size()); err != nil {
+		return
+	}
 	node.File.Name = file.Name
 	node.File.Size = fileStat.Size()
 	node.File.Checksum = file.Checksum
@@ -35,7 +44,9 @@ func (node *Node) SetFile(file FormFile) (err error) {
 	return
 }
 
-func (node *Node) SetFileFromPath(path string, action string) (err error) {
+// SetFileFromPath sets the node file from a path on the server. If decompress
+// is set the file is stored decompressed, see FormFile.Decompress.
+func (node *Node) SetFileFromPath(path string, action string, decompress string) (err error) {
 	fileStat, err := os.Stat(path)
 	if err != nil {
 		return
@@ -49,7 +60,19 @@ func (node *Node) SetFileFromPath(path string, action string) (err error) {
 		return errors.New("setting file from path requires action field equal to copy_file, move_file or keep_file")
 	}
 
-	if action == "move_file" {
+	if decompress != "" && action == "keep_file" {
+		return errors.New("decompress is incompatible with the keep_file action")
+	}
+
+	// decompressed files are reserved once their size is known
+	if decompress == "" {
+		if err = quota.Reserve(node.Acl.Owner, node.File.Size, 0); err != nil {
+			return
+		}
+		defer quota.Unreserve(node.Acl.Owner, node.File.Size, 0)
+	}
+
+	if action == "move_file" && decompress == "" {
 		// Determine if device ID of src and target are the same before proceeding.
 		var devID1 uint64
 		var f, _ = os.Open(path)
@@ -77,7 +100,7 @@ func (node *Node) SetFileFromPath(path string, action string) (err error) {
 	}
 
 	var tmpFile *os.File
-	if action == "copy_file" {
+	if action == "copy_file" && decompress == "" {
 		if tmpFile, err = os.Create(tmpPath); err != nil {
 			return err
 		}
@@ -98,32 +121,72 @@ func (node *Node) SetFileFromPath(path string, action string) (err error) {
 			break
 		}
 		md5h.Write(buffer[0:n])
-		if action == "copy_file" {
+		if tmpFile != nil {
 			tmpFile.Write(buffer[0:n])
 		}
 	}
 	node.File.Checksum["md5"] = fmt.Sprintf("%x", md5h.Sum(nil))
 
-	if action == "copy_file" {
-		os.Rename(tmpPath, node.FilePath())
+	if decompress != "" {
+		file := FormFile{Name: node.File.Name, Path: path, Checksum: node.File.Checksum}
+		// the quota of the owner bounds the decompressed size as well
+		limit := quota.MaxDecompressed()
+		var left int64
+		var holder quota.Usage
+		if left, holder, err = quota.Remaining(node.Acl.Owner); err != nil {
+			return
+		} else if left == 0 {
+			return &quota.ExceededError{Usage: holder}
+		} else if left > 0 && (limit == 0 || left < limit) {
+			limit = left
+		}
+		if err = file.decompress(decompress, limit); err != nil {
+			if err == archive.ErrTooLarge && limit == left {
+				return &quota.ExceededError{Usage: holder}
+			}
+			return
+		}
+		var fi os.FileInfo
+		if fi, err = os.Stat(file.Path); err != nil {
+			return
+		}
+		if err = quota.Reserve(node.Acl.Owner, fi.Size(), 0); err != nil {
+			os.Remove(file.Path)
+			return
+		}
+		defer quota.Unreserve(node.Acl.Owner, fi.Size(), 0)
+		if action == "move_file" {
+			os.Remove(path)
+		}
+		node.File.Name = file.Name
+		node.File.Size = fi.Size()
+		err = node.setBlob(file.Path, file.Checksum["md5"], node.File.Size)
+	} else if action == "copy_file" {
+		tmpFile.Close()
+		err = node.setBlob(tmpPath, node.File.Checksum["md5"], node.File.Size)
 	} else if action == "move_file" {
-		os.Rename(path, node.FilePath())
+		err = node.setBlob(path, node.File.Checksum["md5"], node.File.Size)
 	} else {
 		node.File.Path = path
+		node.File.Store = storage.Local
+	}
+	if err != nil {
+		return
 	}
 	err = node.Save()
 	return
 }
 
 func (node *Node) SetFileFromParts(p *partsList, allowEmpty bool) (err error) {
-	out, err := os.Create(fmt.Sprintf("%s/%s.data", node.Path(), node.Id))
+	tmpPath := fmt.Sprintf("%s/temp/%d%d", conf.Conf["data-path"], rand.Int(), rand.Int())
+	out, err := os.Create(tmpPath)
 	if err != nil {
 		return
 	}
 	defer out.Close()
 	md5h := md5.New()
 	for i := 1; i <= p.Count; i++ {
-		filename := fmt.Sprintf("%s/parts/%d", node.Path(), i)
+		filename := node.partPath(i)
 
 		// skip this portion unless either
 		// 1. file exists, or
@@ -145,13 +208,19 @@ func (node *Node) SetFileFromParts(p *partsList, allowEmpty bool) (err error) {
 			part.Close()
 		}
 	}
-	fileStat, err := os.Stat(fmt.Sprintf("%s/%s.data", node.Path(), node.Id))
+	out.Close()
+	fileStat, err := os.Stat(tmpPath)
 	if err != nil {
 		return
 	}
 	node.File.Name = node.Id
 	node.File.Size = fileStat.Size()
 	node.File.Checksum["md5"] = fmt.Sprintf("%x", md5h.Sum(nil))
+	if err = node.setBlob(tmpPath, node.File.Checksum["md5"], node.File.Size); err != nil {
+		return
+	}
+	// the parts are now accounted for as the file
+	node.PartsSize = 0
 	err = node.Save()
 	return
 }
@@ -171,3 +240,35 @@ func (node *Node) Mkdir() (err error) {
 	}
 	return
 }
+
+// Decompress replaces the uploaded file with its decompressed content.
+// format is gzip, bzip2, zip or auto. The md5 of the file as uploaded is
+// kept as the compressed_md5 checksum.
+func (f *FormFile) Decompress(format string) (err error) {
+	src := f.Path
+	if err = f.decompress(format, quota.MaxDecompressed()); err != nil {
+		return
+	}
+	return os.Remove(src)
+}
+
+// decompress points f at a decompressed copy of its file, leaving the
+// original in place. The copy may be at most limit bytes, 0 is unlimited.
+func (f *FormFile) decompress(format string, limit int64) (err error) {
+	if format != "auto" && !archive.IsValid(format) {
+		return archive.ErrUnknownFormat
+	}
+	tmpPath := fmt.Sprintf("%s/temp/%d%d", conf.Conf["data-path"], rand.Int(), rand.Int())
+	name, sum, err := archive.DecompressFile(format, f.Path, tmpPath, limit)
+	if err != nil {
+		return
+	}
+	if name == "" {
+		name = archive.TrimExt(f.Name)
+	}
+	f.Name = name
+	f.Path = tmpPath
+	f.Checksum["compressed_md5"] = f.Checksum["md5"]
+	f.Checksum["md5"] = sum
+	return
+}
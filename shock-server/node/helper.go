@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/storage"
 	"os"
 	"path/filepath"
 )
@@ -52,9 +53,32 @@ func (node *Node) FilePath() string {
 	if node.File.Path != "" {
 		return node.File.Path
 	}
+	if node.File.Blob != "" {
+		return blobKey(storage.Local, node.File.Blob)
+	}
 	return getPath(node.Id) + "/" + node.Id + ".data"
 }
 
+// DataKey is the key of the node data file in its store. Local data
+// is keyed by absolute path, other stores by path relative to the data directory.
+func (node *Node) DataKey() string {
+	if node.File.Path != "" {
+		return node.File.Path
+	}
+	if node.File.Blob != "" {
+		return blobKey(node.File.Store, node.File.Blob)
+	}
+	if storage.IsLocal(node.File.Store) {
+		return node.FilePath()
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%s.data", node.Id[0:2], node.Id[2:4], node.Id[4:6], node.Id, node.Id)
+}
+
+// Store returns the storage driver holding the node data file
+func (node *Node) Store() storage.Driver {
+	return storage.Get(node.File.Store)
+}
+
 // misc
 func (node *Node) setId() {
 	node.Id = uuid.New()
@@ -74,15 +98,6 @@ func (node *Node) ToJson() (s string, err error) {
 	return
 }
 
-func contains(list []string, elem string) bool {
-	for _, t := range list {
-		if t == elem {
-			return true
-		}
-	}
-	return false
-}
-
 func getPath(id string) string {
 	return fmt.Sprintf("%s/%s/%s/%s/%s", conf.Conf["data-path"], id[0:2], id[2:4], id[4:6], id)
 }
@@ -4,15 +4,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/conf"
 	e "github.com/MG-RAST/Shock/shock-server/errors"
 	"github.com/MG-RAST/Shock/shock-server/node/acl"
 	"github.com/MG-RAST/Shock/shock-server/node/file"
 	"github.com/MG-RAST/Shock/shock-server/node/file/index"
+	"github.com/MG-RAST/Shock/shock-server/quota"
+	"github.com/MG-RAST/Shock/shock-server/storage"
 	"github.com/MG-RAST/Shock/shock-server/user"
 	"github.com/MG-RAST/Shock/shock-server/util"
+	"io"
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
+	"math/rand"
 	"os"
+	"time"
 )
 
 type Node struct {
@@ -23,12 +29,17 @@ type Node struct {
 	Public       bool              `bson:"public" json:"public"`
 	Indexes      Indexes           `bson:"indexes" json:"indexes"`
 	Acl          acl.Acl           `bson:"acl" json:"-"`
+	Collections  []string          `bson:"collections" json:"collections"`
+	NoInherit    bool              `bson:"noinherit" json:"-"`
+	PartsSize    int64             `bson:"parts_size" json:"-"`
 	VersionParts map[string]string `bson:"version_parts" json:"-"`
 	Tags         []string          `bson:"tags" json:"tags"`
-	Revisions    []Node            `bson:"revisions" json:"-"`
+	Revisions    []Revision        `bson:"revisions" json:"-"`
 	Linkages     []linkage         `bson:"linkage" json:"linkages"`
 	CreatedOn    string            `bson:"created_on" json:"created_on"`
 	LastModified string            `bson:"last_modified" json:"last_modified"`
+	Created      time.Time         `bson:"created" json:"-"`
+	Modified     time.Time         `bson:"modified" json:"-"`
 }
 
 type linkage struct {
@@ -78,6 +89,18 @@ func LoadFromDisk(id string) (n *Node, err error) {
 	return
 }
 
+// LoadFor returns the node with id if u can read it, within the scope of
+// the api token u authenticated with
+func LoadFor(id string, u *user.User) (n *Node, err error) {
+	if n, err = Load(id, u.Ids()...); err != nil {
+		return nil, err
+	}
+	if !u.Scope.AllowsNode(n.Id, n.Collections) {
+		return nil, errors.New(e.TokenScope)
+	}
+	return n, nil
+}
+
 func CreateNodeUpload(u *user.User, params map[string]string, files FormFiles) (node *Node, err error) {
 	for param := range params {
 		if !util.IsValidParamName(param) {
@@ -95,13 +118,21 @@ func CreateNodeUpload(u *user.User, params map[string]string, files FormFiles) (
 	}
 
 	if _, hasCopyData := params["copy_data"]; hasCopyData {
-		_, err = Load(params["copy_data"], u.Uuid)
+		_, err = LoadFor(params["copy_data"], u)
 		if err != nil {
 			return
 		}
 	}
 
 	node = New()
+	if err = node.checkSchemas(params, files); err != nil {
+		return nil, err
+	}
+	// data is reserved against the quota as it is set
+	if err = quota.Reserve(u.Uuid, 0, 1); err != nil {
+		return nil, err
+	}
+	defer quota.Unreserve(u.Uuid, 0, 1)
 	if u.Uuid != "" {
 		node.Acl.SetOwner(u.Uuid)
 		node.Acl.Set(u.Uuid, acl.Rights{"read": true, "write": true, "delete": true})
@@ -116,7 +147,7 @@ func CreateNodeUpload(u *user.User, params map[string]string, files FormFiles) (
 		return
 	}
 
-	err = node.Update(params, files)
+	err = node.update(params, files, u)
 	if err != nil {
 		return
 	}
@@ -143,13 +174,64 @@ func (node *Node) FileReader() (reader file.ReaderAt, err error) {
 		}
 		return file.MultiReaderAt(readers...), nil
 	}
-	return os.Open(node.FilePath())
+	return node.Store().ReaderAt(node.DataKey())
+}
+
+// LocalFile returns the node data as a file on local disk for indexers
+// that require one. Data held elsewhere is copied to an unlinked
+// temporary file that disappears once closed.
+func (node *Node) LocalFile() (f *os.File, err error) {
+	if !node.File.Virtual && storage.IsLocal(node.File.Store) {
+		return os.Open(node.FilePath())
+	}
+	r, err := node.FileReader()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	tmpPath := fmt.Sprintf("%s/temp/%d%d", conf.Conf["data-path"], rand.Int(), rand.Int())
+	if f, err = os.Create(tmpPath); err != nil {
+		return
+	}
+	os.Remove(tmpPath)
+	if _, err = io.Copy(f, r); err == nil {
+		_, err = f.Seek(0, 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return
+}
+
+// SetStore moves the node data file to the named store. Nodes sharing
+// the data are moved with it.
+func (node *Node) SetStore(name string) (err error) {
+	if !storage.Has(name) {
+		return errors.New("storage driver not configured: " + name)
+	}
+	if !node.HasFile() || node.File.Virtual || node.File.Path != "" {
+		return errors.New("only nodes with stored data can change store")
+	}
+	if node.ownsLegacyData() {
+		if err = node.adoptBlob(); err != nil {
+			return
+		}
+	}
+	if err = moveBlob(node.File.Blob, name); err != nil {
+		return
+	}
+	node.File.Store = name
+	return
 }
 
 // Index functions
 func (node *Node) Index(name string) (idx index.Index, err error) {
 	if index.Has(name) {
 		idx = index.NewVirtual(name, node.FilePath(), node.File.Size, 10240)
+	} else if name == "interval" {
+		idx = index.NewInterval()
+		err = idx.Load(node.IndexPath() + "/" + name + ".idx")
 	} else {
 		idx = index.New()
 		err = idx.Load(node.IndexPath() + "/" + name + ".idx")
@@ -157,47 +239,50 @@ func (node *Node) Index(name string) (idx index.Index, err error) {
 	return
 }
 
-func (node *Node) Delete() (err error) {
-	// check to make sure this node isn't referenced by a vnode
+// VirtualReferences returns the ids of the virtual nodes including this node
+func (node *Node) VirtualReferences() (ids []string, err error) {
 	virtualNodes := Nodes{}
-	if _, err = dbFind(bson.M{"virtual_parts": node.Id}, &virtualNodes, nil); err != nil {
-		return err
+	if _, err = dbFind(bson.M{"file.virtual_parts": node.Id}, &virtualNodes, nil); err != nil {
+		return nil, err
 	}
-	if len(virtualNodes) != 0 {
-		return errors.New(e.NodeReferenced)
+	for _, n := range virtualNodes {
+		ids = append(ids, n.Id)
 	}
+	return
+}
 
-	// Check to see if this node has a data file and if it's referenced by another node.
-	// If it is, we will move the data file to the first node we find, and point all other nodes to that node's path
-	dataFilePath := fmt.Sprintf("%s/%s.data", getPath(node.Id), node.Id)
-	dataFileExists := true
-	if _, ferr := os.Stat(dataFilePath); os.IsNotExist(ferr) {
-		dataFileExists = false
-	}
-	newDataFilePath := ""
-	copiedNodes := Nodes{}
-	if _, err = dbFind(bson.M{"file.path": dataFilePath}, &copiedNodes, nil); err != nil {
+func (node *Node) Delete() (err error) {
+	// check to make sure this node isn't referenced by a vnode
+	if ids, err := node.VirtualReferences(); err != nil {
 		return err
+	} else if len(ids) != 0 {
+		return errors.New(e.NodeReferenced)
 	}
-	if len(copiedNodes) != 0 && dataFileExists {
-		for index, copiedNode := range copiedNodes {
-			if index == 0 {
-				newDataFilePath = fmt.Sprintf("%s/%s.data", getPath(copiedNode.Id), copiedNode.Id)
-				if rerr := os.Rename(dataFilePath, newDataFilePath); rerr != nil {
-					if _, cerr := util.CopyFile(dataFilePath, newDataFilePath); cerr != nil {
-						return errors.New("This node has a data file linked to another node and the data file could not be copied elsewhere to allow for node deletion.")
-					}
-				}
-				copiedNode.File.Path = ""
-				copiedNode.Save()
-			} else {
-				copiedNode.File.Path = newDataFilePath
-				copiedNode.Save()
+
+	// Data files written before the blob store may still be shared by
+	// copy_data nodes through their path, move those into the blob store first
+	if node.ownsLegacyData() {
+		copiedNodes := Nodes{}
+		if _, err = dbFind(bson.M{"file.path": node.DataKey()}, &copiedNodes, nil); err != nil {
+			return err
+		}
+		if len(copiedNodes) > 0 {
+			if err = node.adoptBlob(); err != nil {
+				return err
+			}
+		} else if !storage.IsLocal(node.File.Store) {
+			if err = node.Store().Delete(node.DataKey()); err != nil {
+				return err
 			}
 		}
 	}
+	if node.File.Blob != "" {
+		if err = node.releaseBlob(); err != nil {
+			return err
+		}
+	}
 
-	if err = dbDelete(bson.M{"id": node.Id}); err != nil {
+	if err = dbDelete(node.Id); err != nil {
 		return err
 	}
 	return node.Rmdir()
@@ -1,6 +1,7 @@
 package node
 
 import (
+	dbquery "github.com/MG-RAST/Shock/shock-server/db/query"
 	"labix.org/v2/mgo/bson"
 )
 
@@ -12,7 +13,41 @@ func (n *Nodes) GetAll(q bson.M) (err error) {
 	return
 }
 
-func (n *Nodes) GetPaginated(q bson.M, limit int, offset int) (count int, err error) {
-	count, err = dbFind(q, n, map[string]int{"limit": limit, "offset": offset})
+// GetPaginated loads one page of the nodes matching q. order lists
+// mongodb sort keys, e.g. "-file.size", natural order if none are given.
+func (n *Nodes) GetPaginated(q bson.M, limit int, offset int, order ...string) (count int, err error) {
+	return dbFindPage(q, n, nil, limit, offset, order...)
+}
+
+// GetFields returns one page of the nodes matching q with only the
+// fields of selector, as raw documents.
+func GetFields(q bson.M, selector bson.M, limit int, offset int, order ...string) (docs []bson.M, count int, err error) {
+	docs = []bson.M{}
+	count, err = dbFindPage(q, &docs, selector, limit, offset, order...)
 	return
 }
+
+// GetAfter loads up to limit nodes matching q that follow cursor c. The
+// returned cursor continues after the last node, it is nil at the end.
+func (n *Nodes) GetAfter(q bson.M, limit int, c *dbquery.Cursor) (next *dbquery.Cursor, err error) {
+	if err = dbFindAfter(q, n, nil, limit, c); err != nil || len(*n) < limit || limit == 0 {
+		return nil, err
+	}
+	return dbCursorAfter(c, (*n)[len(*n)-1].Id)
+}
+
+// GetFieldsAfter is GetAfter returning only the fields of selector
+func GetFieldsAfter(q bson.M, selector bson.M, limit int, c *dbquery.Cursor) (docs []bson.M, next *dbquery.Cursor, err error) {
+	docs = []bson.M{}
+	if err = dbFindAfter(q, &docs, selector, limit, c); err != nil || len(docs) < limit || limit == 0 {
+		return docs, nil, err
+	}
+	id, _ := docs[len(docs)-1]["id"].(string)
+	next, err = dbCursorAfter(c, id)
+	return
+}
+
+// Count returns the number of nodes matching q
+func Count(q bson.M) (int, error) {
+	return dbCount(q)
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/quota"
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
 	"os"
@@ -21,6 +22,22 @@ type partsList struct {
 	Parts  []partsFile `json:"parts"`
 }
 
+// PartsStatus is the client view of a partial upload session
+type PartsStatus struct {
+	Count    int        `json:"count"`
+	Length   int        `json:"length"`
+	VarLen   bool       `json:"varlen"`
+	Received []PartInfo `json:"received"`
+	Missing  []int      `json:"missing"`
+}
+
+type PartInfo struct {
+	Part int    `json:"part"`
+	Name string `json:"name"`
+	MD5  string `json:"md5"`
+	Size int64  `json:"size"`
+}
+
 // Parts functions
 func (node *Node) loadParts() (p *partsList, err error) {
 	pf, err := ioutil.ReadFile(node.partsListPath())
@@ -115,7 +132,78 @@ func (node *Node) addVirtualParts(ids []string) (err error) {
 	return
 }
 
-func (node *Node) addPart(n int, file *FormFile) (err error) {
+// HasParts returns true if the node has a partial upload in progress
+func (node *Node) HasParts() bool {
+	return node.partsCount() > 0 || node.isVarLen()
+}
+
+// PartsStatus reports the received parts, with their md5 and size, and
+// the part numbers still missing so that clients can resume an upload.
+func (node *Node) PartsStatus() (s *PartsStatus, err error) {
+	p, err := node.loadParts()
+	if err != nil {
+		return nil, errors.New(e.NoParts)
+	}
+	s = &PartsStatus{Count: p.Count, Length: p.Length, VarLen: p.VarLen, Received: []PartInfo{}, Missing: []int{}}
+	for i, part := range p.Parts {
+		if len(part) == 0 {
+			s.Missing = append(s.Missing, i+1)
+			continue
+		}
+		info := PartInfo{Part: i + 1, Name: part[0]}
+		if len(part) > 1 {
+			info.MD5 = part[1]
+		}
+		if fi, err := os.Stat(node.partPath(i + 1)); err == nil {
+			info.Size = fi.Size()
+		}
+		s.Received = append(s.Received, info)
+	}
+	return
+}
+
+// ReplacePart uploads part n (1 based), overwriting a previous upload of
+// the same part. Used by clients to retry parts that failed verification.
+func (node *Node) ReplacePart(n int, file *FormFile) (err error) {
+	if node.HasFile() {
+		return errors.New(e.FileImut)
+	}
+	if !node.HasParts() {
+		return errors.New(e.NoParts)
+	}
+	if n < 1 {
+		return errors.New("parts cannot be less than 1")
+	}
+	LockMgr.LockPartOp(node.Id)
+	defer LockMgr.UnlockPartOp(node.Id)
+	return node.addPart(n-1, file, true)
+}
+
+// CloseParts finalizes a partial upload. Variable length uploads are
+// merged as is, fixed length uploads require all parts to be present.
+func (node *Node) CloseParts() (err error) {
+	if node.HasFile() {
+		return errors.New(e.FileImut)
+	}
+	LockMgr.LockPartOp(node.Id)
+	defer LockMgr.UnlockPartOp(node.Id)
+	p, err := node.loadParts()
+	if err != nil {
+		return errors.New(e.NoParts)
+	}
+	if p.VarLen {
+		return node.closeVarLenPartial()
+	}
+	if p.Length != p.Count {
+		return fmt.Errorf("missing %d of %d parts", p.Count-p.Length, p.Count)
+	}
+	if err = node.SetFileFromParts(p, false); err != nil {
+		return err
+	}
+	return os.RemoveAll(node.Path() + "/parts/")
+}
+
+func (node *Node) addPart(n int, file *FormFile, replace bool) (err error) {
 	// load
 	p, err := node.loadParts()
 	if err != nil {
@@ -123,13 +211,30 @@ func (node *Node) addPart(n int, file *FormFile) (err error) {
 	}
 
 	if n >= p.Count && !p.VarLen {
-		return errors.New("part number is greater than node length: " + strconv.Itoa(p.Length))
+		return errors.New("part number is greater than node length: " + strconv.Itoa(p.Count))
 	}
 
+	replaced := false
 	if n < p.Count && len(p.Parts[n]) > 0 {
-		return errors.New(e.FileImut)
+		if !replace {
+			return errors.New(e.FileImut)
+		}
+		replaced = true
 	}
 
+	// charge the part, less the part it replaces, to the owner
+	size := int64(0)
+	if fi, err := os.Stat(file.Path); err == nil {
+		size = fi.Size()
+	}
+	if fi, err := os.Stat(node.partPath(n + 1)); err == nil && replaced {
+		size -= fi.Size()
+	}
+	if err = quota.Reserve(node.Acl.Owner, size, 0); err != nil {
+		return err
+	}
+	defer quota.Unreserve(node.Acl.Owner, size, 0)
+
 	// create part
 	part := partsFile{file.Name, file.Checksum["md5"]}
 
@@ -144,11 +249,13 @@ func (node *Node) addPart(n int, file *FormFile) (err error) {
 		p.Length = p.Length + 1
 	} else {
 		p.Parts[n] = part
-		p.Length = p.Length + 1
+		if !replaced {
+			p.Length = p.Length + 1
+		}
 	}
 
 	// put part into data directory
-	if err = os.Rename(file.Path, fmt.Sprintf("%s/parts/%d", node.Path(), n+1)); err != nil {
+	if err = os.Rename(file.Path, node.partPath(n+1)); err != nil {
 		return err
 	}
 
@@ -156,6 +263,10 @@ func (node *Node) addPart(n int, file *FormFile) (err error) {
 	if err = node.writeParts(p); err != nil {
 		return err
 	}
+	node.PartsSize += size
+	if err = dbIncPartsSize(node.Id, size); err != nil {
+		return err
+	}
 
 	// create file if done with non-variable length node
 	if !p.VarLen && p.Length == p.Count {
@@ -188,3 +299,7 @@ func (node *Node) closeVarLenPartial() (err error) {
 func (node *Node) partsListPath() string {
 	return node.Path() + "/parts/parts.json"
 }
+
+func (node *Node) partPath(n int) string {
+	return fmt.Sprintf("%s/parts/%d", node.Path(), n)
+}
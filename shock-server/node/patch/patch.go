@@ -0,0 +1,442 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to decoded JSON values.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// media types of the patch formats
+const (
+	MergeType = "application/merge-patch+json"
+	PatchType = "application/json-patch+json"
+)
+
+var ErrUnknownType = errors.New("unknown patch type, must be " + MergeType + " or " + PatchType)
+
+// Operation is a single JSON Patch operation
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply applies the patch document of media type to doc and returns the
+// patched value. doc can be any value that encodes to JSON and is not
+// modified. Numbers in the result are int64 or float64.
+func Apply(mediaType string, doc interface{}, patch []byte) (interface{}, error) {
+	if mediaType != MergeType && mediaType != PatchType {
+		return nil, ErrUnknownType
+	}
+	doc, err := normalize(doc)
+	if err != nil {
+		return nil, err
+	}
+	switch mediaType {
+	case MergeType:
+		var p interface{}
+		if err := decode(patch, &p); err != nil {
+			return nil, errors.New("invalid merge patch: " + err.Error())
+		}
+		return Merge(doc, p), nil
+	case PatchType:
+		var ops []Operation
+		if err := decode(patch, &ops); err != nil {
+			return nil, errors.New("invalid json patch: " + err.Error())
+		}
+		return ApplyOps(doc, ops)
+	}
+	return nil, nil
+}
+
+// Diff returns the JSON Patch operations that change from into to. Both
+// can be any values that encode to JSON.
+func Diff(from interface{}, to interface{}) (ops []Operation, err error) {
+	if from, err = normalize(from); err != nil {
+		return nil, err
+	}
+	if to, err = normalize(to); err != nil {
+		return nil, err
+	}
+	ops = []Operation{}
+	err = diff(&ops, "", from, to)
+	return
+}
+
+func diff(ops *[]Operation, path string, from interface{}, to interface{}) error {
+	if reflect.DeepEqual(from, to) {
+		return nil
+	}
+	switch f := from.(type) {
+	case map[string]interface{}:
+		if t, ok := to.(map[string]interface{}); ok {
+			keys := []string{}
+			for k := range f {
+				keys = append(keys, k)
+			}
+			for k := range t {
+				if _, ok := f[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				p := path + "/" + escape(k)
+				fv, inFrom := f[k]
+				tv, inTo := t[k]
+				if !inTo {
+					*ops = append(*ops, newOp("remove", p, nil))
+				} else if !inFrom {
+					if err := appendValueOp(ops, "add", p, tv); err != nil {
+						return err
+					}
+				} else if err := diff(ops, p, fv, tv); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case []interface{}:
+		if t, ok := to.([]interface{}); ok {
+			i := 0
+			for ; i < len(f) && i < len(t); i++ {
+				if err := diff(ops, path+"/"+strconv.Itoa(i), f[i], t[i]); err != nil {
+					return err
+				}
+			}
+			for j := len(f) - 1; j >= i; j-- {
+				*ops = append(*ops, newOp("remove", path+"/"+strconv.Itoa(j), nil))
+			}
+			for ; i < len(t); i++ {
+				if err := appendValueOp(ops, "add", path+"/-", t[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return appendValueOp(ops, "replace", path, to)
+}
+
+func appendValueOp(ops *[]Operation, op string, path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	*ops = append(*ops, newOp(op, path, b))
+	return nil
+}
+
+func newOp(op string, path string, v json.RawMessage) Operation {
+	return Operation{Op: op, Path: &path, Value: v}
+}
+
+// escape escapes a member name for use in a JSON pointer
+func escape(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+// normalize returns v as decoded from its JSON encoding with int64 or
+// float64 numbers
+func normalize(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var n interface{}
+	if err = decode(b, &n); err != nil {
+		return nil, err
+	}
+	return number(n), nil
+}
+
+func decode(b []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if d.More() {
+		return errors.New("trailing data")
+	}
+	return nil
+}
+
+// Merge returns target with the merge patch applied. Objects are merged
+// recursively, null removes a member and any other value replaces it.
+func Merge(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return number(patch)
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	merged := make(map[string]interface{}, len(t))
+	for k, v := range t {
+		merged[k] = v
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = Merge(merged[k], v)
+		}
+	}
+	return merged
+}
+
+// ApplyOps applies JSON Patch operations in order to a copy of doc, which
+// must hold values as decoded by Apply. On error no result is returned.
+func ApplyOps(doc interface{}, ops []Operation) (interface{}, error) {
+	doc = clone(doc)
+	for i, op := range ops {
+		var err error
+		if doc, err = op.apply(doc); err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %s", i, op.Op, err.Error())
+		}
+	}
+	return doc, nil
+}
+
+func (op Operation) apply(doc interface{}) (interface{}, error) {
+	if op.Path == nil {
+		return nil, errors.New("missing path")
+	}
+	path, err := pointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, errors.New("missing value")
+		}
+		var v interface{}
+		if err = decode(op.Value, &v); err != nil {
+			return nil, err
+		}
+		v = number(v)
+		switch op.Op {
+		case "add":
+			return add(doc, path, v)
+		case "replace":
+			if doc, _, err = remove(doc, path); err != nil {
+				return nil, err
+			}
+			return add(doc, path, v)
+		}
+		cur, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(cur, v) {
+			return nil, errors.New("test failed at " + *op.Path)
+		}
+		return doc, nil
+	case "remove":
+		doc, _, err = remove(doc, path)
+		return doc, err
+	case "move", "copy":
+		if op.From == nil {
+			return nil, errors.New("missing from")
+		}
+		from, err := pointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if op.Op == "move" {
+			if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+				return nil, errors.New("can not move a value into itself")
+			}
+			doc, v, err = remove(doc, from)
+		} else {
+			v, err = get(doc, from)
+			v = clone(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, v)
+	}
+	return nil, errors.New("unknown op")
+}
+
+// pointer splits a JSON pointer (RFC 6901) into unescaped tokens
+func pointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, errors.New("invalid path " + p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// index returns the array index of token. end allows "-" and len(a).
+func index(token string, a []interface{}, end bool) (int, error) {
+	if token == "-" && end {
+		return len(a), nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, errors.New("invalid array index " + token)
+	}
+	if i > len(a) || (i == len(a) && !end) {
+		return 0, errors.New("array index out of range " + token)
+	}
+	return i, nil
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, t := range path {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[t]
+			if !ok {
+				return nil, errors.New("no member " + t)
+			}
+			doc = v
+		case []interface{}:
+			i, err := index(t, d, false)
+			if err != nil {
+				return nil, err
+			}
+			doc = d[i]
+		default:
+			return nil, errors.New("no member " + t)
+		}
+	}
+	return doc, nil
+}
+
+// add returns doc with v added at path. Containers along the path are
+// modified in place, doc must be a copy owned by the caller.
+func add(doc interface{}, path []string, v interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = v
+		return doc, nil
+	case []interface{}:
+		i, err := index(last, p, true)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, nil)
+		copy(p[i+1:], p[i:])
+		p[i] = v
+		return set(doc, path[:len(path)-1], p)
+	}
+	return nil, errors.New("can not add to a value that is not an object or array")
+}
+
+// remove returns doc without the value at path and the removed value
+func remove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		v, ok := p[last]
+		if !ok {
+			return nil, nil, errors.New("no member " + last)
+		}
+		delete(p, last)
+		return doc, v, nil
+	case []interface{}:
+		i, err := index(last, p, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := p[i]
+		p = append(p[:i:i], p[i+1:]...)
+		doc, err = set(doc, path[:len(path)-1], p)
+		return doc, v, err
+	}
+	return nil, nil, errors.New("no member " + last)
+}
+
+// set replaces the array at path, arrays change identity when resized
+func set(doc interface{}, path []string, a []interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return a, nil
+	}
+	parent, _ := get(doc, path[:len(path)-1])
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = a
+	case []interface{}:
+		i, _ := strconv.Atoi(last)
+		p[i] = a
+	}
+	return doc, nil
+}
+
+// clone returns a deep copy of a decoded JSON value
+func clone(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = clone(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, e := range t {
+			a[i] = clone(e)
+		}
+		return a
+	}
+	return v
+}
+
+// number converts json.Number values from the patch document to the
+// int64 or float64 values stored in attributes.
+func number(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = number(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = number(e)
+		}
+	}
+	return v
+}
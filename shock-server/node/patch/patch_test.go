@@ -0,0 +1,131 @@
+package patch_test
+
+import (
+	"encoding/json"
+	. "github.com/MG-RAST/Shock/shock-server/node/patch"
+	"testing"
+)
+
+var applyTests = []struct {
+	mediaType, doc, patch, want string
+}{
+	// RFC 7396 appendix A
+	{MergeType, `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+	{MergeType, `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+	{MergeType, `{"a":"b"}`, `{"a":null}`, `{}`},
+	{MergeType, `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+	{MergeType, `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+	{MergeType, `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+	{MergeType, `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+	{MergeType, `["a","b"]`, `["c","d"]`, `["c","d"]`},
+	{MergeType, `{"a":"foo"}`, `null`, `null`},
+	{MergeType, `{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+	{MergeType, `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	{MergeType, `null`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	// RFC 6902 appendix A
+	{PatchType, `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+	{PatchType, `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+	{PatchType, `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+	{PatchType, `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+	{PatchType, `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+	{PatchType, `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+	{PatchType, `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+	{PatchType, `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+	{PatchType, `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+	{PatchType, `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+	{PatchType, `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`, `{"~1":10}`},
+	{PatchType, `{"a":{"b":[1]}}`, `[{"op":"copy","from":"/a/b","path":"/c"},{"op":"add","path":"/c/0","value":2}]`, `{"a":{"b":[1]},"c":[2,1]}`},
+	{PatchType, `{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+	{PatchType, `{"a":1}`, `[{"op":"add","path":"/b","value":null}]`, `{"a":1,"b":null}`},
+	{PatchType, `{"score":0.5,"n":3}`, `[{"op":"test","path":"/score","value":0.5},{"op":"test","path":"/n","value":3}]`, `{"score":0.5,"n":3}`},
+}
+
+var invalidPatches = []struct {
+	mediaType, doc, patch string
+}{
+	{"application/json", `{}`, `{}`},
+	{MergeType, `{}`, `{"a":`},
+	{PatchType, `{}`, `{"op":"add","path":"/a","value":1}`},
+	{PatchType, `{}`, `[{"op":"add","path":"/a"}]`},
+	{PatchType, `{}`, `[{"op":"add","value":1}]`},
+	{PatchType, `{}`, `[{"op":"frobnicate","path":"/a","value":1}]`},
+	{PatchType, `{}`, `[{"op":"add","path":"a","value":1}]`},
+	{PatchType, `{}`, `[{"op":"add","path":"/a/b","value":1}]`},
+	{PatchType, `{"baz":"qux"}`, `[{"op":"remove","path":"/foo"}]`},
+	{PatchType, `{"baz":"qux"}`, `[{"op":"replace","path":"/foo","value":1}]`},
+	{PatchType, `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`},
+	{PatchType, `{"n":1}`, `[{"op":"test","path":"/n","value":"1"}]`},
+	{PatchType, `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":1}]`},
+	{PatchType, `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/01","value":1}]`},
+	{PatchType, `{"foo":["bar"]}`, `[{"op":"remove","path":"/foo/-"}]`},
+	{PatchType, `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/c"}]`},
+	{PatchType, `{"a":1}`, `[{"op":"copy","path":"/b"}]`},
+}
+
+func decode(t *testing.T, s string) (v interface{}) {
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return
+}
+
+func TestApply(t *testing.T) {
+	for _, tt := range applyTests {
+		doc := decode(t, tt.doc)
+		got, err := Apply(tt.mediaType, doc, []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", tt.doc, tt.patch, err)
+			continue
+		}
+		b, _ := json.Marshal(got)
+		w, _ := json.Marshal(decode(t, tt.want))
+		if string(b) != string(w) {
+			t.Errorf("%s %s:\ngot  %s\nwant %s", tt.doc, tt.patch, b, w)
+		}
+		if d, _ := json.Marshal(doc); string(d) != string(mustMarshal(decode(t, tt.doc))) {
+			t.Errorf("%s %s: document modified to %s", tt.doc, tt.patch, d)
+		}
+	}
+	for _, tt := range invalidPatches {
+		if got, err := Apply(tt.mediaType, decode(t, tt.doc), []byte(tt.patch)); err == nil {
+			t.Errorf("%s %s: expected error, got %v", tt.doc, tt.patch, got)
+		}
+	}
+}
+
+func mustMarshal(v interface{}) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func TestDiff(t *testing.T) {
+	docs := []string{
+		`{}`,
+		`{"a":1,"b":{"c":[1,2,3]},"d":"x"}`,
+		`{"a":1.5,"b":{"c":[1,3]},"e":null,"a/~":true}`,
+		`{"b":{"c":[1,3,4,{"f":[]}]},"tags":["x"]}`,
+		`[1,{"a":2}]`,
+		`null`,
+	}
+	for _, from := range docs {
+		for _, to := range docs {
+			ops, err := Diff(decode(t, from), decode(t, to))
+			if err != nil {
+				t.Errorf("Diff(%s, %s): %v", from, to, err)
+				continue
+			}
+			if from == to && len(ops) != 0 {
+				t.Errorf("Diff(%s, %s) = %d operations, want none", from, to, len(ops))
+			}
+			p, _ := json.Marshal(ops)
+			got, err := Apply(PatchType, decode(t, from), p)
+			if err != nil {
+				t.Errorf("Diff(%s, %s) = %s: %v", from, to, p, err)
+				continue
+			}
+			if b, w := mustMarshal(got), mustMarshal(decode(t, to)); string(b) != string(w) {
+				t.Errorf("Diff(%s, %s) = %s applies to %s", from, to, p, b)
+			}
+		}
+	}
+}
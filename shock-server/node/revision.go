@@ -0,0 +1,124 @@
+package node
+
+import (
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/node/acl"
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"github.com/MG-RAST/Shock/shock-server/node/patch"
+	"strconv"
+)
+
+// number of revisions kept per node if not configured
+const defaultMaxRevisions = 100
+
+// Revision is the metadata of a node at one of its versions
+type Revision struct {
+	Version      string      `bson:"version" json:"version"`
+	File         file.File   `bson:"file" json:"file"`
+	Attributes   interface{} `bson:"attributes" json:"attributes"`
+	Acl          acl.Acl     `bson:"acl" json:"-"`
+	Tags         []string    `bson:"tags" json:"tags"`
+	Linkages     []linkage   `bson:"linkage" json:"linkages"`
+	CreatedOn    string      `bson:"created_on" json:"created_on"`
+	LastModified string      `bson:"last_modified" json:"last_modified"`
+}
+
+// RevisionView is a revision as shown to a user with the changes from
+// the previous revision as JSON patch operations. The acl is only shown
+// to users allowed to view it.
+type RevisionView struct {
+	Revision
+	Acl     *acl.Acl          `json:"acl,omitempty"`
+	Changes []patch.Operation `json:"changes,omitempty"`
+}
+
+// maxRevisions returns the configured revision cap, 0 is unlimited
+func maxRevisions() int {
+	if conf.Conf["max-revisions"] == "" {
+		return defaultMaxRevisions
+	}
+	if n, err := strconv.Atoi(conf.Conf["max-revisions"]); err == nil && n >= 0 {
+		return n
+	}
+	return defaultMaxRevisions
+}
+
+// compact drops the oldest revisions beyond the cap
+func compact(revs []Revision) []Revision {
+	if max := maxRevisions(); max > 0 && len(revs) > max {
+		return append([]Revision{}, revs[len(revs)-max:]...)
+	}
+	return revs
+}
+
+func (node *Node) revision() Revision {
+	return Revision{node.Version, node.File, node.Attributes, node.Acl, node.Tags, node.Linkages, node.CreatedOn, node.LastModified}
+}
+
+// metadata returns the part of a revision that is compared in diffs
+func (r *Revision) metadata(showAcl bool) map[string]interface{} {
+	m := map[string]interface{}{"file": r.File, "attributes": r.Attributes, "tags": r.Tags, "linkages": r.Linkages}
+	if showAcl {
+		m["acl"] = r.Acl
+	}
+	return m
+}
+
+// Revision returns the revision of the node at version
+func (node *Node) Revision(version string) (*Revision, error) {
+	for i := range node.Revisions {
+		if node.Revisions[i].Version == version {
+			return &node.Revisions[i], nil
+		}
+	}
+	return nil, errors.New(e.MongoDocNotFound)
+}
+
+// History returns the revisions of the node, oldest first, each with its
+// changes from the previous revision.
+func (node *Node) History(showAcl bool) (views []RevisionView, err error) {
+	views = []RevisionView{}
+	for i, r := range node.Revisions {
+		v := RevisionView{Revision: r}
+		if showAcl {
+			v.Acl = &node.Revisions[i].Acl
+		}
+		if i > 0 {
+			if v.Changes, err = patch.Diff(node.Revisions[i-1].metadata(showAcl), r.metadata(showAcl)); err != nil {
+				return nil, err
+			}
+		}
+		views = append(views, v)
+	}
+	return
+}
+
+// RevisionView returns the revision at version with its changes from
+// the previous revision
+func (node *Node) RevisionView(version string, showAcl bool) (*RevisionView, error) {
+	history, err := node.History(showAcl)
+	if err != nil {
+		return nil, err
+	}
+	for i := range history {
+		if history[i].Version == version {
+			return &history[i], nil
+		}
+	}
+	return nil, errors.New(e.MongoDocNotFound)
+}
+
+// DiffRevisions returns the changes between two versions of the node
+func (node *Node) DiffRevisions(from string, to string, showAcl bool) ([]patch.Operation, error) {
+	f, err := node.Revision(from)
+	if err != nil {
+		return nil, err
+	}
+	t, err := node.Revision(to)
+	if err != nil {
+		return nil, err
+	}
+	return patch.Diff(f.metadata(showAcl), t.metadata(showAcl))
+}
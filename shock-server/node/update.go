@@ -7,6 +7,11 @@ import (
 	"fmt"
 	"github.com/MG-RAST/Shock/shock-server/conf"
 	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/node/patch"
+	"github.com/MG-RAST/Shock/shock-server/quota"
+	"github.com/MG-RAST/Shock/shock-server/schema"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"github.com/MG-RAST/Shock/shock-server/util"
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
 	"os"
@@ -16,7 +21,46 @@ import (
 )
 
 //Modification functions
-func (node *Node) Update(params map[string]string, files FormFiles) (err error) {
+func (node *Node) Update(params map[string]string, files FormFiles, u *user.User) (err error) {
+	if err = node.checkSchemas(params, files); err != nil {
+		return
+	}
+	return node.update(params, files, u)
+}
+
+// checkSchemas validates the attributes and tags an update results in
+// against the registered schemas, before anything is changed.
+func (node *Node) checkSchemas(params map[string]string, files FormFiles) (err error) {
+	_, hasTags := params["tags"]
+	attrFile, hasAttr := files["attributes"]
+	if !hasTags && !hasAttr {
+		return nil
+	}
+	attributes := node.Attributes
+	if hasAttr {
+		b, err := ioutil.ReadFile(attrFile.Path)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(b, &attributes); err != nil {
+			return err
+		}
+	}
+	tags := node.Tags
+	if hasTags {
+		tags = append([]string{}, tags...)
+		for _, tag := range strings.Split(params["tags"], ",") {
+			if !util.StringInSlice(tag, tags) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return schema.Validate(tags, attributes)
+}
+
+// update applies the params and files of a request by u to the node, u
+// must be able to read nodes whose data is copied or referenced
+func (node *Node) update(params map[string]string, files FormFiles, u *user.User) (err error) {
 	// Exclusive conditions
 	// 1. has files[upload] (regular upload)
 	// 2. has params[parts] (partial upload support)
@@ -86,6 +130,11 @@ func (node *Node) Update(params map[string]string, files FormFiles) (err error)
 	} else if isVirtualNode {
 		if source, hasSource := params["source"]; hasSource {
 			ids := strings.Split(source, ",")
+			for _, id := range ids {
+				if _, err = LoadFor(id, u); err != nil {
+					return err
+				}
+			}
 			node.addVirtualParts(ids)
 		} else {
 			return errors.New("type virtual requires source parameter")
@@ -101,7 +150,7 @@ func (node *Node) Update(params map[string]string, files FormFiles) (err error)
 		var success = false
 		for _, p := range localpaths {
 			if strings.HasPrefix(params["path"], p) {
-				if err = node.SetFileFromPath(params["path"], params["action"]); err != nil {
+				if err = node.SetFileFromPath(params["path"], params["action"], params["decompress"]); err != nil {
 					return err
 				} else {
 					success = true
@@ -113,7 +162,7 @@ func (node *Node) Update(params map[string]string, files FormFiles) (err error)
 		}
 	} else if isCopyUpload {
 		var n *Node
-		n, err = LoadUnauth(params["copy_data"])
+		n, err = LoadFor(params["copy_data"], u)
 		if err != nil {
 			return err
 		}
@@ -121,6 +170,10 @@ func (node *Node) Update(params map[string]string, files FormFiles) (err error)
 		if n.File.Virtual {
 			return errors.New("copy_data parameter points to a virtual node, invalid operation.")
 		}
+		if err = quota.Reserve(node.Acl.Owner, n.File.Size, 0); err != nil {
+			return err
+		}
+		defer quota.Unreserve(node.Acl.Owner, n.File.Size, 0)
 
 		// Copy node file information
 		node.File.Name = n.File.Name
@@ -128,11 +181,23 @@ func (node *Node) Update(params map[string]string, files FormFiles) (err error)
 		node.File.Checksum = n.File.Checksum
 		node.File.Format = n.File.Format
 
-		if n.File.Path == "" {
-			node.File.Path = fmt.Sprintf("%s/%s.data", getPath(params["copy_data"]), params["copy_data"])
+		if n.ownsLegacyData() {
+			if err = n.adoptBlob(); err != nil {
+				return err
+			}
+		}
+		if n.File.Blob != "" {
+			if err = node.refBlob(n); err != nil {
+				return err
+			}
 		} else {
+			node.File.Store = n.File.Store
 			node.File.Path = n.File.Path
 		}
+		// counted in the quota while still reserved
+		if err = node.Save(); err != nil {
+			return err
+		}
 	}
 
 	// set attributes from file
@@ -145,28 +210,28 @@ func (node *Node) Update(params map[string]string, files FormFiles) (err error)
 	}
 
 	// handle part file
-	LockMgr.LockPartOp()
+	LockMgr.LockPartOp(node.Id)
 	parts_count := node.partsCount()
 	if parts_count > 0 || node.isVarLen() {
 		for key, file := range files {
 			if node.HasFile() {
-				LockMgr.UnlockPartOp()
+				LockMgr.UnlockPartOp(node.Id)
 				return errors.New(e.FileImut)
 			}
 			keyn, errf := strconv.Atoi(key)
 			if errf == nil && (keyn <= parts_count || node.isVarLen()) {
-				err = node.addPart(keyn-1, &file)
+				err = node.addPart(keyn-1, &file, false)
 				if err != nil {
-					LockMgr.UnlockPartOp()
+					LockMgr.UnlockPartOp(node.Id)
 					return err
 				}
 			} else {
-				LockMgr.UnlockPartOp()
+				LockMgr.UnlockPartOp(node.Id)
 				return errors.New("invalid file parameter")
 			}
 		}
 	}
-	LockMgr.UnlockPartOp()
+	LockMgr.UnlockPartOp(node.Id)
 
 	// update relatives
 	if _, hasRelation := params["linkage"]; hasRelation {
@@ -212,39 +277,90 @@ func (node *Node) Update(params map[string]string, files FormFiles) (err error)
 }
 
 func (node *Node) Save() (err error) {
-	node.UpdateVersion()
-	if len(node.Revisions) == 0 || node.Revisions[len(node.Revisions)-1].Version != node.Version {
-		n := Node{node.Id, node.Version, node.File, node.Attributes, node.Public, node.Indexes, node.Acl, node.VersionParts, node.Tags, nil, node.Linkages, node.CreatedOn, node.LastModified}
-		node.Revisions = append(node.Revisions, n)
-	}
+	return node.save("")
+}
+
+// save stores the node. If prev is set the node is only stored while the
+// database still holds version prev.
+func (node *Node) save(prev string) (err error) {
+	now := time.Now()
 	if node.CreatedOn == "" {
-		node.CreatedOn = time.Now().Format(time.UnixDate)
+		node.CreatedOn = now.Format(time.UnixDate)
+		node.Created = now
 	} else {
-		node.LastModified = time.Now().Format(time.UnixDate)
+		node.LastModified = now.Format(time.UnixDate)
+		node.Modified = now
+	}
+	node.UpdateVersion()
+	if len(node.Revisions) == 0 || node.Revisions[len(node.Revisions)-1].Version != node.Version {
+		node.Revisions = compact(append(node.Revisions, node.revision()))
 	}
 
-	bsonPath := fmt.Sprintf("%s/%s.bson", node.Path(), node.Id)
-	os.Remove(bsonPath)
 	nbson, err := bson.Marshal(node)
 	if err != nil {
 		return
 	}
-	err = ioutil.WriteFile(bsonPath, nbson, 0644)
-	if err != nil {
-		return
+	if prev != "" {
+		err = dbUpdateVersion(node, prev)
+	} else {
+		err = dbUpsert(node)
 	}
-	err = dbUpsert(node)
 	if err != nil {
 		return
 	}
+	bsonPath := fmt.Sprintf("%s/%s.bson", node.Path(), node.Id)
+	os.Remove(bsonPath)
+	err = ioutil.WriteFile(bsonPath, nbson, 0644)
 	return
 }
 
+// number of times a patch is reapplied when the node changes concurrently
+const patchRetries = 3
+
+// PatchAttributes applies a JSON merge patch or JSON patch, selected by
+// mediaType, to the node attributes. If version is set the node must be
+// at that version, otherwise a patch that races with another update is
+// reapplied to the newer attributes.
+func (node *Node) PatchAttributes(mediaType string, p []byte, version string) (err error) {
+	for try := 1; ; try++ {
+		if version != "" && version != node.Version {
+			return errors.New(e.VersionMismatch)
+		}
+		attr, err := patch.Apply(mediaType, node.Attributes, p)
+		if err != nil {
+			return err
+		}
+		if err = schema.Validate(node.Tags, attr); err != nil {
+			return err
+		}
+		prev := node.Version
+		node.Attributes = attr
+		err = node.save(prev)
+		if err == nil || err.Error() != e.VersionMismatch || version != "" || try == patchRetries {
+			return err
+		}
+		n, err := LoadUnauth(node.Id)
+		if err != nil {
+			return err
+		}
+		*node = *n
+	}
+}
+
 func (node *Node) UpdateVersion() (err error) {
 	parts := make(map[string]string)
 	h := md5.New()
 	version := node.Id
-	for name, value := range map[string]interface{}{"file_ver": node.File, "attributes_ver": node.Attributes, "acl_ver": node.Acl} {
+	tags := node.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	// parts are hashed in a fixed order so equal nodes have equal versions
+	for _, p := range []struct {
+		name  string
+		value interface{}
+	}{{"file_ver", node.File}, {"attributes_ver", node.Attributes}, {"acl_ver", node.Acl}, {"tags_ver", tags}} {
+		name, value := p.name, p.value
 		m, er := json.Marshal(value)
 		if er != nil {
 			return
@@ -277,7 +393,7 @@ func (node *Node) UpdateLinkages(ltype string, ids string, operation string) (er
 func (node *Node) UpdateDataTags(types string) (err error) {
 	tagslist := strings.Split(types, ",")
 	for _, newtag := range tagslist {
-		if contains(node.Tags, newtag) {
+		if util.StringInSlice(newtag, node.Tags) {
 			continue
 		}
 		node.Tags = append(node.Tags, newtag)
@@ -1,6 +1,8 @@
 package node
 
-import ()
+import (
+	"sync"
+)
 
 type mappy map[string]bool
 
@@ -17,20 +19,46 @@ var (
 	LockMgr = NewLocker()
 )
 
+// Locker serializes part operations per node. Uploads to different
+// nodes do not block each other.
 type Locker struct {
-	partLock chan bool //semaphore for checkout (mutual exclusion between different clients)
+	mutex    sync.Mutex
+	partLock map[string]*nodeLock //semaphore for checkout (mutual exclusion between different clients)
+}
+
+type nodeLock struct {
+	lock    chan bool
+	waiting int
 }
 
 func NewLocker() *Locker {
 	return &Locker{
-		partLock: make(chan bool, 1), //non-blocking buffered channel
+		partLock: map[string]*nodeLock{},
 	}
 }
 
-func (l *Locker) LockPartOp() {
-	l.partLock <- true
+func (l *Locker) LockPartOp(id string) {
+	l.mutex.Lock()
+	nl, has := l.partLock[id]
+	if !has {
+		nl = &nodeLock{lock: make(chan bool, 1)} //non-blocking buffered channel
+		l.partLock[id] = nl
+	}
+	nl.waiting += 1
+	l.mutex.Unlock()
+	nl.lock <- true
 }
 
-func (l *Locker) UnlockPartOp() {
-	<-l.partLock
+func (l *Locker) UnlockPartOp(id string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	nl, has := l.partLock[id]
+	if !has {
+		return
+	}
+	<-nl.lock
+	nl.waiting -= 1
+	if nl.waiting == 0 {
+		delete(l.partLock, id)
+	}
 }
@@ -0,0 +1,59 @@
+package quota
+
+import "sync"
+
+// Counter is the usage counter of a user as held by the test stores
+type Counter struct {
+	Bytes, Nodes, ReservedBytes, ReservedNodes int64
+}
+
+// Stub replaces the stores of limits, group members and counters, limits
+// are keyed by kind and name, e.g. "group:g1". It returns a function
+// restoring them.
+func Stub(limits map[string]Limit, groups map[string][]string, counters map[string]*Counter) (restore func()) {
+	var mu sync.Mutex
+	gl, mo, gm, ic, lc := getLimit, memberOf, groupMembers, incCounter, loadCounters
+	getLimit = func(kind string, name string) (Limit, error) {
+		return limits[kind+":"+name], nil
+	}
+	memberOf = func(uuid string) (names []string, err error) {
+		for name, members := range groups {
+			for _, m := range members {
+				if m == uuid {
+					names = append(names, name)
+				}
+			}
+		}
+		return
+	}
+	groupMembers = func(name string) ([]string, error) {
+		return groups[name], nil
+	}
+	incCounter = func(uuid string, inc counter) error {
+		mu.Lock()
+		defer mu.Unlock()
+		c, ok := counters[uuid]
+		if !ok {
+			c = &Counter{}
+			counters[uuid] = c
+		}
+		c.Bytes += inc.Bytes
+		c.Nodes += int64(inc.Nodes)
+		c.ReservedBytes += inc.ReservedBytes
+		c.ReservedNodes += int64(inc.ReservedNodes)
+		return nil
+	}
+	loadCounters = func(uuids []string) (cs []counter, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, uuid := range uuids {
+			if c, ok := counters[uuid]; ok {
+				cs = append(cs, counter{Name: uuid, Bytes: c.Bytes, Nodes: int(c.Nodes), ReservedBytes: c.ReservedBytes, ReservedNodes: int(c.ReservedNodes)})
+			}
+		}
+		return
+	}
+	return func() {
+		getLimit, memberOf, groupMembers, incCounter, loadCounters = gl, mo, gm, ic, lc
+	}
+}
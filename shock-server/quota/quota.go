@@ -0,0 +1,416 @@
+// Package quota accounts for the data stored by node owners and enforces
+// storage quotas per user and per local group
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/db"
+	"github.com/MG-RAST/Shock/shock-server/group"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strconv"
+	"strings"
+)
+
+// kinds of quota holder
+const (
+	User  = "user"
+	Group = "group"
+)
+
+// defaultMaxDecompressed bounds decompressed uploads unless configured
+const defaultMaxDecompressed = 100 << 30
+
+// Limit is the quota of a user or group, 0 is unlimited
+type Limit struct {
+	Kind  string `bson:"kind" json:"-"`
+	Name  string `bson:"name" json:"-"`
+	Bytes int64  `bson:"bytes" json:"quota_bytes"`
+	Nodes int    `bson:"nodes" json:"quota_nodes"`
+}
+
+// Usage is the data stored by a user, the owner of the nodes, or by the
+// members of a group. Bytes count the files of the nodes and their
+// partial uploads, data shared through copy_data is counted for each node.
+type Usage struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+	Nodes int    `json:"nodes"`
+	Limit
+	// data of uploads in progress
+	reservedBytes int64
+	reservedNodes int
+}
+
+// ExceededError is returned when storing data would exceed a quota
+type ExceededError struct {
+	Usage Usage
+}
+
+func (q *ExceededError) Error() string {
+	return fmt.Sprintf("storage quota of %s %s exceeded: %d of %d bytes, %d of %d nodes used",
+		q.Usage.Kind, q.Usage.Name, q.Usage.Bytes, q.Usage.Limit.Bytes, q.Usage.Nodes, q.Usage.Limit.Nodes)
+}
+
+func collection(session *mgo.Session) *mgo.Collection {
+	return session.DB(conf.Conf["mongodb-database"]).C("Quotas")
+}
+
+func nodes(session *mgo.Session) *mgo.Collection {
+	return session.DB(conf.Conf["mongodb-database"]).C("Nodes")
+}
+
+// Initialize checks the configured default quotas and sets up the usage
+// counters. Reservations are cleared, so uploads in progress on other
+// servers sharing the database may briefly exceed their quota.
+func Initialize() (err error) {
+	if _, err = ParseSize(conf.Conf["quota-user-bytes"]); err != nil {
+		return fmt.Errorf("[Quota] user_bytes: %s", err.Error())
+	}
+	if n := conf.Conf["quota-user-nodes"]; n != "" {
+		if _, err = strconv.Atoi(n); err != nil {
+			return fmt.Errorf("[Quota] user_nodes: invalid number: %s", n)
+		}
+	}
+	if _, err = ParseSize(conf.Conf["quota-max-decompressed"]); err != nil {
+		return fmt.Errorf("[Quota] max_decompressed: %s", err.Error())
+	}
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	collection(session).EnsureIndex(mgo.Index{Key: []string{"kind", "name"}, Unique: true})
+	nodes(session).EnsureIndex(mgo.Index{Key: []string{"acl.owner"}})
+	return initCounters(session)
+}
+
+// defaultLimit returns the configured quota for kind. Groups have no
+// default: their owners add members without consent, so only a quota an
+// admin sets on a group applies to its members.
+func defaultLimit(kind string) (l Limit) {
+	if kind != User {
+		return
+	}
+	l.Bytes, _ = ParseSize(conf.Conf["quota-user-bytes"])
+	l.Nodes, _ = strconv.Atoi(conf.Conf["quota-user-nodes"])
+	return
+}
+
+// GetLimit returns the quota set for a user or group, the configured
+// default of users if none is set.
+func GetLimit(kind string, name string) (l Limit, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	if err = collection(session).Find(bson.M{"kind": kind, "name": name}).One(&l); err == mgo.ErrNotFound {
+		return defaultLimit(kind), nil
+	}
+	return
+}
+
+// SetLimit sets the quota of a user or group
+func SetLimit(kind string, name string, bytes int64, count int) (err error) {
+	if bytes < 0 || count < 0 {
+		return errors.New("quota can not be negative")
+	}
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	_, err = collection(session).Upsert(bson.M{"kind": kind, "name": name}, Limit{Kind: kind, Name: name, Bytes: bytes, Nodes: count})
+	return
+}
+
+// ResetLimit returns a user or group to the configured default quota
+func ResetLimit(kind string, name string) (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	if err = collection(session).Remove(bson.M{"kind": kind, "name": name}); err == mgo.ErrNotFound {
+		return nil
+	}
+	return
+}
+
+// counter is the data stored by a node owner, kept up to date as nodes
+// are saved and deleted, and the data being stored by uploads in progress
+type counter struct {
+	Name          string `bson:"name"`
+	Bytes         int64  `bson:"bytes"`
+	Nodes         int    `bson:"nodes"`
+	ReservedBytes int64  `bson:"reserved_bytes"`
+	ReservedNodes int    `bson:"reserved_nodes"`
+}
+
+// the stores of limits, group memberships and counters, replaced in tests
+var (
+	getLimit     = GetLimit
+	memberOf     = group.MemberOf
+	groupMembers = func(name string) ([]string, error) {
+		g, err := group.Load(name)
+		if err != nil {
+			return nil, err
+		}
+		return g.Members, nil
+	}
+	incCounter   = dbIncCounter
+	loadCounters = dbLoadCounters
+)
+
+func counters(session *mgo.Session) *mgo.Collection {
+	return session.DB(conf.Conf["mongodb-database"]).C("QuotaUsage")
+}
+
+// dbIncCounter adds the fields of inc to the counter of the user with uuid
+func dbIncCounter(uuid string, inc counter) (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	_, err = counters(session).Upsert(bson.M{"name": uuid}, bson.M{"$inc": bson.M{
+		"bytes": inc.Bytes, "nodes": inc.Nodes, "reserved_bytes": inc.ReservedBytes, "reserved_nodes": inc.ReservedNodes,
+	}})
+	return
+}
+
+// dbLoadCounters returns the counters of the users with uuids
+func dbLoadCounters(uuids []string) (cs []counter, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	cs = []counter{}
+	err = counters(session).Find(bson.M{"name": bson.M{"$in": uuids}}).All(&cs)
+	return
+}
+
+// initCounters adds the counters of node owners that have none, from the
+// nodes they own, and clears reservations of uploads cut off by a restart
+func initCounters(session *mgo.Session) (err error) {
+	c := counters(session)
+	if err = c.EnsureIndex(mgo.Index{Key: []string{"name"}, Unique: true}); err != nil {
+		return
+	}
+	t := []counter{}
+	err = nodes(session).Pipe([]bson.M{
+		{"$match": bson.M{"acl.owner": bson.M{"$ne": ""}}},
+		{"$group": bson.M{
+			"_id":   "$acl.owner",
+			"bytes": bson.M{"$sum": bson.M{"$add": []interface{}{bson.M{"$ifNull": []interface{}{"$file.size", 0}}, bson.M{"$ifNull": []interface{}{"$parts_size", 0}}}}},
+			"nodes": bson.M{"$sum": 1},
+		}},
+		{"$project": bson.M{"_id": 0, "name": "$_id", "bytes": 1, "nodes": 1}},
+	}).All(&t)
+	if err != nil {
+		return
+	}
+	for _, o := range t {
+		if err = c.Insert(&o); err != nil && !mgo.IsDup(err) {
+			return
+		}
+	}
+	_, err = c.UpdateAll(bson.M{}, bson.M{"$set": bson.M{"reserved_bytes": 0, "reserved_nodes": 0}})
+	return
+}
+
+func usage(kind string, name string, owners []string) (u Usage, err error) {
+	u = Usage{Kind: kind, Name: name}
+	if u.Limit, err = getLimit(kind, name); err != nil {
+		return
+	}
+	cs, err := loadCounters(owners)
+	if err != nil {
+		return
+	}
+	for _, c := range cs {
+		u.add(c)
+	}
+	return
+}
+
+func (u *Usage) add(c counter) {
+	u.Bytes += c.Bytes
+	u.Nodes += c.Nodes
+	u.reservedBytes += c.ReservedBytes
+	u.reservedNodes += c.ReservedNodes
+}
+
+// exceeded returns true if the usage, with the data being stored, is over
+// a limit that storing bytes more data in count more nodes depends on
+func (u *Usage) exceeded(bytes int64, count int) bool {
+	return (u.Limit.Bytes > 0 && bytes > 0 && u.Bytes+u.reservedBytes > u.Limit.Bytes) ||
+		(u.Limit.Nodes > 0 && count > 0 && u.Nodes+u.reservedNodes > u.Limit.Nodes)
+}
+
+// UserUsage returns the data stored by the user with uuid
+func UserUsage(uuid string) (Usage, error) {
+	return usage(User, uuid, []string{uuid})
+}
+
+// GroupUsage returns the data stored by the members of group g
+func GroupUsage(g *group.Group) (Usage, error) {
+	return usage(Group, g.Name, g.Members)
+}
+
+// All returns the usage of every node owner, largest first
+func All() (us []Usage, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	cs := []counter{}
+	if err = counters(session).Find(bson.M{"nodes": bson.M{"$gt": 0}}).Sort("-bytes").All(&cs); err != nil {
+		return
+	}
+	us = []Usage{}
+	for _, c := range cs {
+		u := Usage{Kind: User, Name: c.Name, Bytes: c.Bytes, Nodes: c.Nodes}
+		if u.Limit, err = getLimit(User, c.Name); err != nil {
+			return nil, err
+		}
+		us = append(us, u)
+	}
+	return
+}
+
+// holders returns the usage of the user with uuid and of the local groups
+// it is a member of, for those with a quota
+func holders(uuid string) (us []Usage, err error) {
+	us = []Usage{}
+	l, err := getLimit(User, uuid)
+	if err != nil {
+		return
+	}
+	if l.Bytes > 0 || l.Nodes > 0 {
+		u, err := usage(User, uuid, []string{uuid})
+		if err != nil {
+			return nil, err
+		}
+		us = append(us, u)
+	}
+	names, err := memberOf(uuid)
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		if l, err = getLimit(Group, name); err != nil {
+			return
+		} else if l.Bytes == 0 && l.Nodes == 0 {
+			continue
+		}
+		members, err := groupMembers(name)
+		if err != nil {
+			return nil, err
+		}
+		u, err := usage(Group, name, members)
+		if err != nil {
+			return nil, err
+		}
+		us = append(us, u)
+	}
+	return
+}
+
+// Add records that the user with uuid stores bytes more data in count
+// more nodes, both may be negative. It is called as nodes are saved and
+// deleted, quotas are enforced by Reserve before data is stored.
+func Add(uuid string, bytes int64, count int) (err error) {
+	if uuid == "" || (bytes == 0 && count == 0) {
+		return nil
+	}
+	return incCounter(uuid, counter{Bytes: bytes, Nodes: count})
+}
+
+// Reserve claims bytes of data in count nodes for the user with uuid
+// before they are stored. It returns an *ExceededError if that would
+// exceed the quota of the user or the quota an admin set on a local group
+// it is a member of. The claim is counted first and checked after, so
+// concurrent uploads can not pass the quota together. A successful
+// reservation must be returned with Unreserve once the data is stored,
+// or not, the stored node is counted when it is saved. Public nodes have
+// no quota.
+func Reserve(uuid string, bytes int64, count int) (err error) {
+	if uuid == "" || (bytes <= 0 && count <= 0) {
+		return nil
+	}
+	if err = incCounter(uuid, counter{ReservedBytes: bytes, ReservedNodes: count}); err != nil {
+		return
+	}
+	us, err := holders(uuid)
+	if err == nil {
+		for _, u := range us {
+			if u.exceeded(bytes, count) {
+				err = &ExceededError{Usage: u}
+				break
+			}
+		}
+	}
+	if err != nil {
+		Unreserve(uuid, bytes, count)
+	}
+	return
+}
+
+// Unreserve returns a reservation made with Reserve
+func Unreserve(uuid string, bytes int64, count int) (err error) {
+	if uuid == "" || (bytes <= 0 && count <= 0) {
+		return nil
+	}
+	return incCounter(uuid, counter{ReservedBytes: -bytes, ReservedNodes: -count})
+}
+
+// Remaining returns the bytes the user with uuid can still store under
+// the quotas that apply to it, -1 if no byte quota applies, and the usage
+// of the quota holder that limits it.
+func Remaining(uuid string) (bytes int64, holder Usage, err error) {
+	bytes = -1
+	if uuid == "" {
+		return
+	}
+	us, err := holders(uuid)
+	if err != nil {
+		return
+	}
+	for _, u := range us {
+		if u.Limit.Bytes == 0 {
+			continue
+		}
+		left := u.Limit.Bytes - u.Bytes - u.reservedBytes
+		if left < 0 {
+			left = 0
+		}
+		if bytes < 0 || left < bytes {
+			bytes, holder = left, u
+		}
+	}
+	return
+}
+
+// MaxDecompressed returns the largest size in bytes an upload may be
+// decompressed to, 0 if unlimited
+func MaxDecompressed() int64 {
+	if conf.Conf["quota-max-decompressed"] == "" {
+		return defaultMaxDecompressed
+	}
+	n, _ := ParseSize(conf.Conf["quota-max-decompressed"])
+	return n
+}
+
+var units = []string{"K", "M", "G", "T", "P"}
+
+// ParseSize parses a size in bytes with an optional binary unit, e.g.
+// 500M or 2TB. An empty size is 0.
+func ParseSize(s string) (n int64, err error) {
+	orig := s
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := float64(1)
+	for i, u := range units {
+		if strings.HasSuffix(s, u) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u))
+			for j := 0; j <= i; j++ {
+				mult *= 1024
+			}
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, errors.New("invalid size: " + orig)
+	}
+	return int64(f * mult), nil
+}
@@ -0,0 +1,142 @@
+package quota_test
+
+import (
+	. "github.com/MG-RAST/Shock/shock-server/quota"
+	"sync"
+	"testing"
+)
+
+var sizeTests = []struct {
+	in   string
+	want int64
+	ok   bool
+}{
+	{"", 0, true},
+	{"0", 0, true},
+	{"1048576", 1048576, true},
+	{"10K", 10240, true},
+	{"500M", 500 * 1024 * 1024, true},
+	{"1.5g", 1536 * 1024 * 1024, true},
+	{"2TB", 2 << 40, true},
+	{"1 GiB", 1 << 30, true},
+	{"1P", 1 << 50, true},
+	{"12B", 12, true},
+	{"G", 0, false},
+	{"-1G", 0, false},
+	{"ten", 0, false},
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range sizeTests {
+		got, err := ParseSize(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseSize(%q): error %v, want ok %v", tt.in, err, tt.ok)
+		} else if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	limits := map[string]Limit{
+		"user:u1":  {Bytes: 100, Nodes: 2},
+		"group:g1": {Bytes: 200},
+	}
+	groups := map[string][]string{"g1": {"u1", "u2"}, "g2": {"u2", "u3"}}
+	counters := map[string]*Counter{"u1": {Bytes: 50, Nodes: 1}, "u2": {Bytes: 60, Nodes: 5}}
+	defer Stub(limits, groups, counters)()
+
+	for _, tt := range []struct {
+		uuid  string
+		bytes int64
+		count int
+		ok    bool
+	}{
+		{"u1", 50, 0, true},
+		{"u1", 51, 0, false},
+		{"u1", 0, 1, true},
+		{"u1", 0, 2, false},
+		{"u2", 90, 10, true}, // u2 has only the group quota
+		{"u2", 91, 0, false},
+		{"u3", 1 << 40, 100, true}, // only the group of u3 without quota
+		{"", 1 << 40, 100, true},   // public nodes
+		{"u1", -10, 0, true},
+	} {
+		err := Reserve(tt.uuid, tt.bytes, tt.count)
+		if _, exceeded := err.(*ExceededError); err != nil && !exceeded {
+			t.Fatalf("%+v: %v", tt, err)
+		} else if exceeded == tt.ok {
+			t.Errorf("%+v: got %v", tt, err)
+		}
+		if err == nil {
+			Unreserve(tt.uuid, tt.bytes, tt.count)
+		}
+		if c := counters["u1"]; c.ReservedBytes != 0 || c.ReservedNodes != 0 {
+			t.Fatalf("%+v: reservation left: %+v", tt, c)
+		}
+	}
+
+	// the usage of the limiting holder is reported
+	err := Reserve("u2", 91, 0)
+	if qerr, ok := err.(*ExceededError); !ok || qerr.Usage.Kind != Group || qerr.Usage.Bytes != 110 {
+		t.Errorf("got %v", err)
+	}
+
+	// reservations count until they are returned
+	if err := Reserve("u1", 30, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reserve("u2", 61, 0); err == nil {
+		t.Errorf("reserved in use: no error")
+	}
+	if left, u, err := Remaining("u1"); err != nil || left != 20 || u.Kind != User {
+		t.Errorf("remaining: got %d %+v %v", left, u, err)
+	}
+	if left, u, err := Remaining("u2"); err != nil || left != 60 || u.Kind != Group {
+		t.Errorf("remaining: got %d %+v %v", left, u, err)
+	}
+	Unreserve("u1", 30, 1)
+	if left, _, _ := Remaining("u1"); left != 50 {
+		t.Errorf("remaining: got %d, want 50", left)
+	}
+	if left, _, _ := Remaining("u3"); left != -1 {
+		t.Errorf("remaining without quota: got %d, want -1", left)
+	}
+
+	// stored data is counted by Add
+	Add("u1", 40, 1)
+	if u, err := UserUsage("u1"); err != nil || u.Bytes != 90 || u.Nodes != 2 {
+		t.Errorf("usage: got %+v %v", u, err)
+	}
+	if err := Reserve("u1", 0, 1); err == nil {
+		t.Errorf("node quota: no error")
+	}
+}
+
+func TestReserveConcurrent(t *testing.T) {
+	counters := map[string]*Counter{}
+	defer Stub(map[string]Limit{"user:u1": {Bytes: 1000}}, nil, counters)()
+
+	// uploads of 300 bytes at once, at most 3 fit
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if Reserve("u1", 300, 0) == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved > 3 {
+		t.Errorf("%d reservations of 300 bytes within 1000", reserved)
+	}
+	if c := counters["u1"]; c.ReservedBytes != int64(reserved)*300 {
+		t.Errorf("got %d bytes reserved, want %d", c.ReservedBytes, reserved*300)
+	}
+}
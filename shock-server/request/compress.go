@@ -0,0 +1,106 @@
+package request
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// compressor produces a compressed download with the given filename
+// suffix, cmd is the external command it runs if any
+type compressor struct {
+	ext    string
+	cmd    string
+	writer func(w io.Writer) (io.WriteCloser, error)
+}
+
+// bzip2 and zstd have no writer in the standard library and are
+// run as external commands.
+var compressors = map[string]compressor{
+	"gzip": {".gz", "", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}},
+	"bzip2": {".bz2", "bzip2", commandWriter("bzip2", "-c")},
+	"zstd":  {".zst", "zstd", commandWriter("zstd", "-c", "-q")},
+}
+
+// formats whose command is not installed are not offered
+func init() {
+	for format, c := range compressors {
+		if _, err := exec.LookPath(c.cmd); c.cmd != "" && err != nil {
+			delete(compressors, format)
+		}
+	}
+}
+
+// IsValidCompression returns true if downloads can be compressed with format
+func IsValidCompression(format string) bool {
+	_, ok := compressors[format]
+	return ok
+}
+
+// Compressions returns the formats downloads can be compressed with
+func Compressions() (formats []string) {
+	for _, format := range []string{"gzip", "bzip2", "zstd"} {
+		if IsValidCompression(format) {
+			formats = append(formats, format)
+		}
+	}
+	return
+}
+
+// streamCompressed streams the readers, after filtering, through the
+// compressor of s.Compression. The compressed size is unknown in advance.
+func (s *Streamer) streamCompressed() (err error) {
+	c, ok := compressors[s.Compression]
+	if !ok {
+		return fmt.Errorf("unsupported compression: %s", s.Compression)
+	}
+	cw, err := c.writer(s.W)
+	if err != nil {
+		return
+	}
+	s.W.Header().Set("Content-Type", s.ContentType)
+	s.W.Header().Set("Content-Disposition", fmt.Sprintf(" attachment; filename=%s%s", s.Filename, c.ext))
+	for _, sr := range s.R {
+		var rs io.Reader = sr
+		if s.Filter != nil {
+			rs = s.Filter(sr)
+		}
+		if _, err = io.Copy(cw, rs); err != nil {
+			cw.Close()
+			return
+		}
+	}
+	return cw.Close()
+}
+
+// cmdWriter feeds written data to a command whose output goes to the wrapped writer
+type cmdWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func commandWriter(name string, args ...string) func(w io.Writer) (io.WriteCloser, error) {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		cmd := exec.Command(name, args...)
+		cmd.Stdout = w
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err = cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &cmdWriter{stdin, cmd}, nil
+	}
+}
+
+func (c *cmdWriter) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		c.cmd.Wait()
+		return err
+	}
+	return c.cmd.Wait()
+}
@@ -0,0 +1,103 @@
+package request_test
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"github.com/MG-RAST/Shock/shock-server/node/file/index"
+	. "github.com/MG-RAST/Shock/shock-server/request"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestStreamCompressed(t *testing.T) {
+	data := strings.Repeat("@r1\nACGT\n+\nIIII\n", 100)
+	tests := []struct {
+		compression string
+		suffix      string
+		reader      func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", ".gz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"bzip2", ".bz2", func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil }},
+		{"zstd", ".zst", func(r io.Reader) (io.Reader, error) {
+			cmd := exec.Command("zstd", "-d", "-c", "-q")
+			cmd.Stdin = r
+			out, err := cmd.Output()
+			return bytes.NewReader(out), err
+		}},
+	}
+	for _, tt := range tests {
+		if tt.compression != "gzip" {
+			if _, err := exec.LookPath(tt.compression); err != nil {
+				if IsValidCompression(tt.compression) {
+					t.Errorf("%s not installed but offered", tt.compression)
+				}
+				t.Logf("%s not installed, skipping", tt.compression)
+				continue
+			}
+		}
+		if !IsValidCompression(tt.compression) {
+			t.Errorf("%s installed but not offered", tt.compression)
+			continue
+		}
+		w := httptest.NewRecorder()
+		half := int64(len(data) / 2)
+		r := strings.NewReader(data)
+		s := &Streamer{
+			R:           []file.SectionReader{io.NewSectionReader(r, 0, half), io.NewSectionReader(r, half, int64(len(data))-half)},
+			W:           w,
+			ContentType: "application/octet-stream",
+			Filename:    "reads.fq",
+			Size:        int64(len(data)),
+			Compression: tt.compression,
+		}
+		if err := s.Stream(); err != nil {
+			t.Fatalf("%s: %v", tt.compression, err)
+		}
+		if cd := w.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, "reads.fq"+tt.suffix) {
+			t.Errorf("%s: Content-Disposition %q", tt.compression, cd)
+		}
+		if w.Header().Get("Content-Length") != "" {
+			t.Errorf("%s: unexpected Content-Length", tt.compression)
+		}
+		dr, err := tt.reader(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.compression, err)
+		}
+		out, err := ioutil.ReadAll(dr)
+		if err != nil || string(out) != data {
+			t.Errorf("%s: decompressed output does not match, err %v", tt.compression, err)
+		}
+	}
+}
+
+func TestStreamFaiCompressed(t *testing.T) {
+	data := ">s\nACGTACGT\n"
+	idx := &index.FaiIndex{Seqs: []index.FaiSeq{{Name: "s", Length: 8, Offset: 3, LineBases: 8, LineWidth: 9}}}
+	w := httptest.NewRecorder()
+	s := &Streamer{W: w, ContentType: "text/plain", Filename: "seqs.fa", Compression: "gzip"}
+	if err := s.StreamFai(strings.NewReader(data), idx, []string{"s:2-5"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, "seqs.fa.gz") {
+		t.Errorf("Content-Disposition %q", cd)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := ioutil.ReadAll(gr); err != nil || string(out) != ">s:2-5\nCGTA\n" {
+		t.Errorf("got %q, %v", out, err)
+	}
+
+	w = httptest.NewRecorder()
+	s = &Streamer{W: w, ContentType: "text/plain", Filename: "seqs.fa", Compression: "gzip"}
+	if err := s.StreamFai(strings.NewReader(data), idx, []string{"t"}, false); err == nil || w.Body.Len() > 0 {
+		t.Errorf("unknown region: got %v and %d bytes", err, w.Body.Len())
+	}
+}
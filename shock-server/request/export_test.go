@@ -0,0 +1,7 @@
+package request
+
+// exported for the tests of request_test
+var (
+	CheckScope      = checkScope
+	NodeCollections = &nodeCollections
+)
@@ -0,0 +1,212 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnsatisfiableRange = errors.New("requested range not satisfiable")
+
+// ByteRange is a single satisfiable byte range of a file
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+func (r ByteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a Range header string as per RFC 7233. Ranges
+// that start beyond size are dropped, an error is returned if none are left.
+func ParseRange(s string, size int64) (ranges []ByteRange, err error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errors.New("invalid range")
+	}
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return nil, errors.New("invalid range")
+		}
+		start, end := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+		var r ByteRange
+		if start == "" {
+			// suffix range: last N bytes
+			n, er := strconv.ParseInt(end, 10, 64)
+			if er != nil || n < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if n == 0 {
+				noOverlap = true
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.Start = size - n
+			r.Length = n
+		} else {
+			i, er := strconv.ParseInt(start, 10, 64)
+			if er != nil || i < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if i >= size {
+				noOverlap = true
+				continue
+			}
+			r.Start = i
+			if end == "" {
+				r.Length = size - r.Start
+			} else {
+				i, er := strconv.ParseInt(end, 10, 64)
+				if er != nil || r.Start > i {
+					return nil, errors.New("invalid range")
+				}
+				if i >= size {
+					i = size - 1
+				}
+				r.Length = i - r.Start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if noOverlap && len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// RangeHeader returns the Range header of the request if it should be
+// honored. An If-Range precondition that does not match the current etag
+// or modification date (time.UnixDate formated) causes the full file to be sent.
+func RangeHeader(r *http.Request, etag string, modified string) string {
+	rh := r.Header.Get("Range")
+	if rh == "" {
+		return ""
+	}
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return rh
+	}
+	if strings.HasPrefix(ir, "\"") || strings.HasPrefix(ir, "W/") {
+		if ir == etag {
+			return rh
+		}
+		return ""
+	}
+	lm, err := time.Parse(time.UnixDate, modified)
+	if err != nil {
+		return ""
+	}
+	if t, err := http.ParseTime(ir); err == nil && !lm.Truncate(time.Second).After(t) {
+		return rh
+	}
+	return ""
+}
+
+// StreamRange writes the byte ranges in rangeHeader as a 206 partial content response.
+// A single range is sent as is, multiple ranges as multipart/byteranges.
+func (s *Streamer) StreamRange(rangeHeader string) (err error) {
+	ranges, err := ParseRange(rangeHeader, s.Size)
+	if err != nil {
+		if err == ErrUnsatisfiableRange {
+			s.W.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", s.Size))
+			s.W.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return nil
+		}
+		// malformed range headers are ignored
+		return s.Stream()
+	}
+	if len(ranges) == 0 {
+		return s.Stream()
+	}
+	ra := newSectionsReaderAt(s.R, s.Size)
+
+	s.W.Header().Set("Content-Disposition", fmt.Sprintf(" attachment; filename=%s", s.Filename))
+	if len(ranges) == 1 {
+		r := ranges[0]
+		s.W.Header().Set("Content-Type", s.ContentType)
+		s.W.Header().Set("Content-Range", r.contentRange(s.Size))
+		s.W.Header().Set("Content-Length", fmt.Sprint(r.Length))
+		s.W.WriteHeader(http.StatusPartialContent)
+		_, err = io.Copy(s.W, io.NewSectionReader(ra, r.Start, r.Length))
+		return
+	}
+
+	mw := multipart.NewWriter(s.W)
+	s.W.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	s.W.WriteHeader(http.StatusPartialContent)
+	for _, r := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Range": {r.contentRange(s.Size)},
+			"Content-Type":  {s.ContentType},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(part, io.NewSectionReader(ra, r.Start, r.Length)); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// sectionsReaderAt presents the Streamer readers as one contiguous ReaderAt.
+// Readers that report their own size (io.SectionReader) can be concatenated,
+// a lone reader without a size is assumed to span the whole stream.
+type sectionsReaderAt struct {
+	readers []file.SectionReader
+	starts  []int64
+	size    int64
+}
+
+func newSectionsReaderAt(readers []file.SectionReader, size int64) *sectionsReaderAt {
+	sr := &sectionsReaderAt{readers: readers, size: size}
+	start := int64(0)
+	for _, r := range readers {
+		sr.starts = append(sr.starts, start)
+		if sz, ok := r.(interface {
+			Size() int64
+		}); ok {
+			start += sz.Size()
+		} else {
+			start = size
+		}
+	}
+	return sr
+}
+
+func (sr *sectionsReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off >= sr.size {
+		return 0, io.EOF
+	}
+	i := 0
+	for i+1 < len(sr.starts) && sr.starts[i+1] <= off {
+		i++
+	}
+	for ; i < len(sr.readers) && n < len(p); i++ {
+		rn, er := sr.readers[i].ReadAt(p[n:], off+int64(n)-sr.starts[i])
+		n += rn
+		if er != nil && er != io.EOF {
+			return n, er
+		}
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
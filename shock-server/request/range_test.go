@@ -0,0 +1,153 @@
+package request_test
+
+import (
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	. "github.com/MG-RAST/Shock/shock-server/request"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var rangeTests = []struct {
+	h    string
+	size int64
+	r    []ByteRange
+	err  bool
+}{
+	{"bytes=0-99", 1000, []ByteRange{{0, 100}}, false},
+	{"bytes=500-", 1000, []ByteRange{{500, 500}}, false},
+	{"bytes=-100", 1000, []ByteRange{{900, 100}}, false},
+	{"bytes=-2000", 1000, []ByteRange{{0, 1000}}, false},
+	{"bytes=900-1999", 1000, []ByteRange{{900, 100}}, false},
+	{"bytes=0-0, 10-19", 1000, []ByteRange{{0, 1}, {10, 10}}, false},
+	{"bytes=1000-", 1000, nil, true},
+	{"bytes=20-10", 1000, nil, true},
+	{"lines=0-10", 1000, nil, true},
+}
+
+func TestParseRange(t *testing.T) {
+	for _, rt := range rangeTests {
+		r, err := ParseRange(rt.h, rt.size)
+		if (err != nil) != rt.err {
+			t.Errorf("ParseRange(%q) error = %v", rt.h, err)
+			continue
+		}
+		if len(r) != len(rt.r) {
+			t.Errorf("ParseRange(%q) = %v, want %v", rt.h, r, rt.r)
+			continue
+		}
+		for i := range r {
+			if r[i] != rt.r[i] {
+				t.Errorf("ParseRange(%q) = %v, want %v", rt.h, r, rt.r)
+			}
+		}
+	}
+}
+
+// rangeStreamer returns a Streamer of data split over two readers
+func rangeStreamer(w http.ResponseWriter, data string) *Streamer {
+	half := int64(len(data) / 2)
+	r := strings.NewReader(data)
+	return &Streamer{
+		R:           []file.SectionReader{io.NewSectionReader(r, 0, half), io.NewSectionReader(r, half, int64(len(data))-half)},
+		W:           w,
+		ContentType: "text/plain",
+		Filename:    "test.txt",
+		Size:        int64(len(data)),
+	}
+}
+
+func TestStreamRange(t *testing.T) {
+	data := "0123456789abcdefghij"
+
+	w := httptest.NewRecorder()
+	if err := rangeStreamer(w, data).StreamRange("bytes=8-11"); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusPartialContent || w.Body.String() != "89ab" || w.Header().Get("Content-Range") != "bytes 8-11/20" || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("single range: got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	if err := rangeStreamer(w, data).StreamRange("bytes=0-1,-3"); err != nil {
+		t.Fatal(err)
+	}
+	mt, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if w.Code != http.StatusPartialContent || err != nil || mt != "multipart/byteranges" {
+		t.Fatalf("multiple ranges: got %d %v", w.Code, w.Header())
+	}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for _, want := range []struct{ cr, body string }{{"bytes 0-1/20", "01"}, {"bytes 17-19/20", "hij"}} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(part)
+		if part.Header.Get("Content-Range") != want.cr || string(body) != want.body {
+			t.Errorf("multiple ranges: got part %v %q, want %s %q", part.Header, body, want.cr, want.body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("multiple ranges: got more parts, %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	if err := rangeStreamer(w, data).StreamRange("bytes=20-"); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */20" || w.Body.Len() != 0 {
+		t.Errorf("unsatisfiable range: got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	// malformed ranges are ignored
+	w = httptest.NewRecorder()
+	if err := rangeStreamer(w, data).StreamRange("lines=1-2"); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != data {
+		t.Errorf("malformed range: got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRangeHeader(t *testing.T) {
+	modified := time.Date(2014, 3, 4, 10, 30, 15, 0, time.UTC)
+	lm := modified.Format(time.UnixDate)
+	for _, test := range []struct {
+		ifRange string
+		ranged  bool
+	}{
+		{"", true},
+		{`"v1"`, true},
+		{`"v2"`, false},
+		{`W/"v1"`, false},
+		{modified.Format(http.TimeFormat), true},
+		{modified.Add(time.Hour).Format(http.TimeFormat), true},
+		{modified.Add(-time.Second).Format(http.TimeFormat), false},
+		{"yesterday", false},
+	} {
+		r, _ := http.NewRequest("GET", "/node/id?download", nil)
+		r.Header.Set("Range", "bytes=0-9")
+		if test.ifRange != "" {
+			r.Header.Set("If-Range", test.ifRange)
+		}
+		if got := RangeHeader(r, `"v1"`, lm); (got != "") != test.ranged {
+			t.Errorf("If-Range %q: got %q", test.ifRange, got)
+		}
+	}
+	r, _ := http.NewRequest("GET", "/node/id?download", nil)
+	r.Header.Set("If-Range", `"v1"`)
+	if got := RangeHeader(r, `"v1"`, lm); got != "" {
+		t.Errorf("no Range: got %q", got)
+	}
+	r.Header.Set("Range", "bytes=0-9")
+	r.Header.Set("If-Range", modified.Format(http.TimeFormat))
+	if got := RangeHeader(r, `"v1"`, "-"); got != "" {
+		t.Errorf("unknown modification date: got %q", got)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/MG-RAST/Shock/shock-server/auth"
 	"github.com/MG-RAST/Shock/shock-server/conf"
 	e "github.com/MG-RAST/Shock/shock-server/errors"
+	"github.com/MG-RAST/Shock/shock-server/group"
 	"github.com/MG-RAST/Shock/shock-server/logger"
 	"github.com/MG-RAST/Shock/shock-server/node"
 	"github.com/MG-RAST/Shock/shock-server/responder"
@@ -19,6 +20,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type checkSumCom struct {
@@ -55,13 +57,121 @@ func Authenticate(req *http.Request) (u *user.User, err error) {
 		return
 	}
 	header := req.Header.Get("Authorization")
-	u, err = auth.Authenticate(header)
-	return
+	addr, _, _ := net.SplitHostPort(req.RemoteAddr)
+	if u, err = auth.Authenticate(header, addr); err != nil {
+		return
+	}
+	if u.Scope != nil {
+		if err = checkScope(req, u.Scope); err != nil {
+			return nil, err
+		}
+	}
+	return withGroups(u)
+}
+
+// nodeCollections returns the collections of the node with id
+var nodeCollections = func(id string) ([]string, error) {
+	n, err := node.LoadUnauth(id)
+	if err != nil {
+		return nil, err
+	}
+	return n.Collections, nil
+}
+
+// checkScope returns an error unless the request is allowed by the scope
+// of an api token. Tokens limited to nodes or collections can only access
+// those, and not change their acls or collection membership. Node ids in
+// the request body are checked by node.LoadFor.
+func checkScope(req *http.Request, s *user.Scope) error {
+	if !s.Write && req.Method != "GET" && req.Method != "HEAD" && req.Method != "OPTIONS" {
+		return errors.New(e.TokenScope)
+	}
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	// tokens can not issue tokens or change accounts
+	if path[0] == "token" || (path[0] == "user" && req.Method != "GET") {
+		return errors.New(e.TokenScope)
+	}
+	if !s.Restricted() {
+		return nil
+	}
+	if len(path) < 2 || (len(path) > 2 && (path[2] == "acl" || (path[2] == "nodes" && req.Method != "GET"))) {
+		return errors.New(e.TokenScope)
+	}
+	// node ids in the query, such as copy_data on PUT /node/{id}
+	for _, key := range []string{"copy_data", "source"} {
+		for _, v := range req.URL.Query()[key] {
+			for _, id := range strings.Split(v, ",") {
+				if !allowsNode(s, id) {
+					return errors.New(e.TokenScope)
+				}
+			}
+		}
+	}
+	switch path[0] {
+	case "node":
+		if allowsNode(s, path[1]) {
+			return nil
+		}
+	case "collection":
+		if s.AllowsCollection(path[1]) {
+			return nil
+		}
+	}
+	return errors.New(e.TokenScope)
+}
+
+// allowsNode returns true if the scope gives access to the node with id
+func allowsNode(s *user.Scope, id string) bool {
+	if s.AllowsNode(id, nil) {
+		return true
+	}
+	if len(s.Collections) == 0 {
+		return false
+	}
+	collections, err := nodeCollections(id)
+	return err == nil && s.AllowsNode(id, collections)
+}
+
+// withGroups returns a copy of u whose groups are the groups asserted by
+// the auth provider and the local groups the user is a member of. The
+// copy keeps cached users unchanged and local membership current.
+func withGroups(u *user.User) (*user.User, error) {
+	local, err := group.MemberOf(u.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	c := *u
+	c.Groups = append([]string{}, u.Groups...)
+	for _, name := range local {
+		if !util.StringInSlice(name, c.Groups) {
+			c.Groups = append(c.Groups, name)
+		}
+	}
+	return &c, nil
+}
+
+// RequireAuth authenticates a request that requires a user. If it is not
+// authenticated an error response is written and u is nil.
+func RequireAuth(ctx context.Context) (u *user.User) {
+	u, err := Authenticate(ctx.HttpRequest())
+	if err != nil && err.Error() != e.NoAuth {
+		AuthError(err, ctx)
+		return nil
+	}
+	if u == nil {
+		responder.RespondWithError(ctx, http.StatusUnauthorized, e.NoAuth)
+		return nil
+	}
+	return u
 }
 
 func AuthError(err error, ctx context.Context) error {
 	if err.Error() == e.InvalidAuth {
 		return responder.RespondWithError(ctx, http.StatusBadRequest, "Invalid authorization header or content")
+	} else if err.Error() == e.TokenScope {
+		return responder.RespondWithError(ctx, http.StatusUnauthorized, e.TokenScope)
+	} else if err.Error() == e.TooManyFailures {
+		return responder.RespondWithError(ctx, http.StatusTooManyRequests, e.TooManyFailures)
 	}
 	err_msg := "Error at Auth: " + err.Error()
 	logger.Error(err_msg)
@@ -95,6 +205,11 @@ func DataUpload(r *http.Request) (params map[string]string, files node.FormFiles
 		return nil, nil, err
 	}
 
+	if format := decompressFormat(r, params); format != "" {
+		if err = decompressUpload(files, format); err != nil {
+			return nil, nil, err
+		}
+	}
 	return
 }
 
@@ -126,17 +241,6 @@ func ParseMultipartForm(r *http.Request) (params map[string]string, files node.F
 					return nil, nil, errors.New("invalid file param: " + part.FormName())
 				}
 				tmpPath = fmt.Sprintf("%s/temp/%d%d", conf.Conf["data-path"], rand.Int(), rand.Int())
-				/*
-					if fname[len(fname)-3:] == ".gz" && params["decompress"] == "true" {
-						fname = fname[:len(fname)-3]
-						reader, err = gzip.NewReader(&part)
-						if err != nil {
-							break
-						}
-					} else {
-						reader = &part
-					}
-				*/
 				files[part.FormName()] = node.FormFile{Name: part.FileName(), Path: tmpPath, Checksum: make(map[string]string)}
 				if tmpFile, err := os.Create(tmpPath); err == nil {
 					defer tmpFile.Close()
@@ -172,6 +276,36 @@ func ParseMultipartForm(r *http.Request) (params map[string]string, files node.F
 		err = errors.New("Cannot specify upload file path and copy_data node in same request.")
 		return nil, nil, err
 	}
+	if format := decompressFormat(r, params); format != "" && hasUpload {
+		if err = decompressUpload(files, format); err != nil {
+			return nil, nil, err
+		}
+	}
+	return
+}
+
+// decompressFormat returns the compression format requested with the
+// decompress param, either in the form or the url. true means auto detect.
+func decompressFormat(r *http.Request, params map[string]string) string {
+	format, ok := params["decompress"]
+	if !ok {
+		format = r.URL.Query().Get("decompress")
+	}
+	if format == "true" {
+		return "auto"
+	} else if format == "false" {
+		return ""
+	}
+	return format
+}
+
+func decompressUpload(files node.FormFiles, format string) (err error) {
+	f := files["upload"]
+	if err = f.Decompress(format); err != nil {
+		os.Remove(f.Path)
+		return errors.New("could not decompress upload: " + err.Error())
+	}
+	files["upload"] = f
 	return
 }
 
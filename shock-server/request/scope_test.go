@@ -0,0 +1,68 @@
+package request_test
+
+import (
+	"errors"
+	. "github.com/MG-RAST/Shock/shock-server/request"
+	"github.com/MG-RAST/Shock/shock-server/user"
+	"net/http"
+	"testing"
+)
+
+var scopeTests = []struct {
+	method string
+	url    string
+	scope  user.Scope
+	ok     bool
+}{
+	// unrestricted tokens
+	{"GET", "/node/n1", user.Scope{}, true},
+	{"PUT", "/node/n1", user.Scope{}, false},
+	{"PUT", "/node/n1", user.Scope{Write: true}, true},
+	{"POST", "/node", user.Scope{Write: true}, true},
+	{"GET", "/token", user.Scope{Write: true}, false},
+	{"GET", "/user/u1", user.Scope{}, true},
+	{"PUT", "/user/u1", user.Scope{Write: true}, false},
+	// tokens limited to nodes
+	{"GET", "/node/n1", user.Scope{Nodes: []string{"n1"}}, true},
+	{"GET", "/node/n2", user.Scope{Nodes: []string{"n1"}}, false},
+	{"GET", "/node", user.Scope{Nodes: []string{"n1"}}, false},
+	{"HEAD", "/node/n1", user.Scope{Nodes: []string{"n1"}}, true},
+	{"PUT", "/node/n1", user.Scope{Nodes: []string{"n1"}}, false},
+	{"PUT", "/node/n1", user.Scope{Write: true, Nodes: []string{"n1"}}, true},
+	{"GET", "/node/n1/acl", user.Scope{Nodes: []string{"n1"}}, false},
+	{"PUT", "/node/n1?copy_data=n2", user.Scope{Write: true, Nodes: []string{"n1"}}, false},
+	{"PUT", "/node/n1?copy_data=n2", user.Scope{Write: true, Nodes: []string{"n1", "n2"}}, true},
+	{"PUT", "/node/n1?source=n2,n3", user.Scope{Write: true, Nodes: []string{"n1", "n2"}}, false},
+	{"GET", "/collection/c1", user.Scope{Nodes: []string{"n1"}}, false},
+	// tokens limited to collections
+	{"GET", "/collection/c1", user.Scope{Collections: []string{"c1"}}, true},
+	{"GET", "/collection/c2", user.Scope{Collections: []string{"c1"}}, false},
+	{"GET", "/collection/c1/nodes", user.Scope{Collections: []string{"c1"}}, true},
+	{"PUT", "/collection/c1/nodes", user.Scope{Write: true, Collections: []string{"c1"}}, false},
+	{"GET", "/node/n1", user.Scope{Collections: []string{"c1"}}, true},
+	{"GET", "/node/n2", user.Scope{Collections: []string{"c1"}}, false},
+	{"GET", "/node/n3", user.Scope{Collections: []string{"c1"}}, false},
+	{"PUT", "/node/n1?copy_data=n2", user.Scope{Write: true, Collections: []string{"c1"}}, false},
+}
+
+func TestCheckScope(t *testing.T) {
+	collections := map[string][]string{"n1": {"c1"}, "n2": {"c2"}}
+	lookup := *NodeCollections
+	defer func() { *NodeCollections = lookup }()
+	*NodeCollections = func(id string) ([]string, error) {
+		if c, ok := collections[id]; ok {
+			return c, nil
+		}
+		return nil, errors.New("not found")
+	}
+	for _, tt := range scopeTests {
+		req, err := http.NewRequest(tt.method, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := tt.scope
+		if err := CheckScope(req, &s); (err == nil) != tt.ok {
+			t.Errorf("%s %s %+v: got %v, want ok %v", tt.method, tt.url, tt.scope, err, tt.ok)
+		}
+	}
+}
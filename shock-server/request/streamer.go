@@ -3,13 +3,14 @@ package request
 import (
 	"errors"
 	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/logger"
 	"github.com/MG-RAST/Shock/shock-server/node/file"
 	"github.com/MG-RAST/Shock/shock-server/node/file/index"
 	"github.com/MG-RAST/Shock/shock-server/node/filter"
 	"github.com/stretchr/goweb/context"
 	"io"
 	"net/http"
-	"os/exec"
+	"strconv"
 )
 
 type Streamer struct {
@@ -19,9 +20,13 @@ type Streamer struct {
 	Filename    string
 	Size        int64
 	Filter      filter.FilterFunc
+	Compression string
 }
 
 func (s *Streamer) Stream() (err error) {
+	if s.Compression != "" {
+		return s.streamCompressed()
+	}
 	s.W.Header().Set("Content-Type", s.ContentType)
 	s.W.Header().Set("Content-Disposition", fmt.Sprintf(" attachment; filename=%s", s.Filename))
 	if s.Size > 0 && s.Filter == nil {
@@ -42,77 +47,127 @@ func (s *Streamer) Stream() (err error) {
 	return
 }
 
-func (s *Streamer) StreamSamtools(filePath string, region string, args ...string) (err error) {
-	//involking samtools in command line:
-	//samtools view [-c] [-H] [-f INT] ... filname.bam [region]
-
-	argv := []string{}
-	argv = append(argv, "view")
-	argv = append(argv, args...)
-	argv = append(argv, filePath)
+// StreamBam writes the alignments of the BAM file r selected by v as SAM
+// text. idx is the index of the file, needed for region queries. Errors
+// in the query are returned before the response is started.
+func (s *Streamer) StreamBam(r io.ReadSeeker, idx *index.BamIndex, v *index.BamView) (err error) {
+	return s.streamWith(func(w io.Writer) error {
+		return v.Write(w, r, idx)
+	})
+}
 
-	if region != "" {
-		argv = append(argv, region)
-	}
+// StreamTabix writes the records of the text file r overlapping region,
+// after the header lines if header is set. idx is the tabix index of r.
+func (s *Streamer) StreamTabix(r io.ReadSeeker, idx *index.TabixIndex, region string, header bool) (err error) {
+	return s.streamWith(func(w io.Writer) error {
+		return idx.Write(w, r, region, header)
+	})
+}
 
-	index.LoadBamIndex(filePath)
+// StreamFai writes the bases of the regions of the fasta file r as fasta,
+// reverse complemented if revcomp is set. idx is the fai index of r.
+func (s *Streamer) StreamFai(r io.ReaderAt, idx *index.FaiIndex, regions []string, revcomp bool) (err error) {
+	return s.streamWith(func(w io.Writer) error {
+		return idx.Write(w, r, regions, revcomp)
+	})
+}
 
-	cmd := exec.Command("samtools", argv...)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return
+// streamWith streams the output of write, compressed if s.Compression is
+// set. Errors returned before write has written anything are returned,
+// later ones are logged.
+func (s *Streamer) streamWith(write func(io.Writer) error) (err error) {
+	w := &headerWriter{s: s}
+	if err = write(w); err == nil && s.Compression != "" && !w.started {
+		// an empty result is still sent as a valid compressed file
+		err = w.start()
 	}
-	err = cmd.Start()
-	if err != nil {
-		return err
+	if er := w.Close(); err == nil {
+		err = er
 	}
-
-	go io.Copy(s.W, stdout)
-
-	err = cmd.Wait()
-	if err != nil {
-		return err
+	if err != nil && w.started {
+		// the response is under way and can only be cut short
+		logger.Error("err@Streamer: " + err.Error())
+		return nil
 	}
-
-	index.UnLoadBamIndex(filePath)
-
 	return
 }
 
-//helper function to translate args in URL query to samtools args
-//manual: http://samtools.sourceforge.net/samtools.shtml
-func ParseSamtoolsArgs(ctx context.Context) (argv []string, err error) {
+// headerWriter sets the response headers on the first write and writes
+// through the compressor of the streamer, if any
+type headerWriter struct {
+	s       *Streamer
+	started bool
+	cw      io.WriteCloser
+}
 
-	query := ctx.HttpRequest().URL.Query()
-	var (
-		filter_options = map[string]string{
-			"head":     "-h",
-			"headonly": "-H",
-			"count":    "-c",
+func (w *headerWriter) start() (err error) {
+	filename := w.s.Filename
+	var c compressor
+	if w.s.Compression != "" {
+		var ok bool
+		if c, ok = compressors[w.s.Compression]; !ok {
+			return fmt.Errorf("unsupported compression: %s", w.s.Compression)
 		}
-		valued_options = map[string]string{
-			"flag":      "-f",
-			"lib":       "-l",
-			"mapq":      "-q",
-			"readgroup": "-r",
+		filename += c.ext
+	}
+	w.s.W.Header().Set("Content-Type", w.s.ContentType)
+	w.s.W.Header().Set("Content-Disposition", fmt.Sprintf(" attachment; filename=%s", filename))
+	if c.writer != nil {
+		if w.cw, err = c.writer(w.s.W); err != nil {
+			return
 		}
-	)
+	}
+	w.started = true
+	return
+}
 
-	for src, des := range filter_options {
-		if _, ok := query[src]; ok {
-			argv = append(argv, des)
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		if err := w.start(); err != nil {
+			return 0, err
 		}
 	}
+	if w.cw != nil {
+		return w.cw.Write(p)
+	}
+	return w.s.W.Write(p)
+}
+
+// Close ends the compressed output
+func (w *headerWriter) Close() error {
+	if w.cw == nil {
+		return nil
+	}
+	return w.cw.Close()
+}
 
-	for src, des := range valued_options {
-		if _, ok := query[src]; ok {
-			if val := query.Get(src); val != "" {
-				argv = append(argv, des)
-				argv = append(argv, val)
-			} else {
-				return nil, errors.New(fmt.Sprintf("required value not found for query arg: %s ", src))
-			}
+// ParseBamView translates the query of a bam download to the options of
+// samtools view: head (-h), headonly (-H), count (-c), flag (-f),
+// lib (-l), mapq (-q), readgroup (-r) and region.
+func ParseBamView(ctx context.Context) (v *index.BamView, err error) {
+	query := ctx.HttpRequest().URL.Query()
+	v = &index.BamView{Region: query.Get("region")}
+	_, v.Header = query["head"]
+	_, v.HeaderOnly = query["headonly"]
+	_, v.Count = query["count"]
+	for _, arg := range []string{"flag", "lib", "mapq", "readgroup"} {
+		if _, ok := query[arg]; ok && query.Get(arg) == "" {
+			return nil, errors.New(fmt.Sprintf("required value not found for query arg: %s ", arg))
+		}
+	}
+	if f := query.Get("flag"); f != "" {
+		flag, err := strconv.ParseUint(f, 0, 16)
+		if err != nil {
+			return nil, errors.New("flag must be an integer")
+		}
+		v.Flag = uint16(flag)
+	}
+	if q := query.Get("mapq"); q != "" {
+		if v.MinMapq, err = strconv.Atoi(q); err != nil {
+			return nil, errors.New("mapq must be an integer")
 		}
 	}
-	return argv, nil
+	v.Library = query.Get("lib")
+	v.ReadGroup = query.Get("readgroup")
+	return v, nil
 }
@@ -21,6 +21,7 @@ type paginatedResponse struct {
 	Limit  int         `json:"limit"`
 	Offset int         `json:"offset"`
 	Count  int         `json:"total_count"`
+	Next   string      `json:"next_cursor,omitempty"`
 }
 
 func RespondOK(ctx context.Context) error {
@@ -51,6 +52,16 @@ func RespondWithError(ctx context.Context, status int, err string) error {
 	return goweb.API.WriteResponseObject(ctx, http.StatusOK, response)
 }
 
+// RespondWithErrorData responds with several error messages and data
+// describing them in detail
+func RespondWithErrorData(ctx context.Context, status int, errs []string, data interface{}) error {
+	response := new(standardResponse)
+	response.S = status
+	response.D = data
+	response.E = errs
+	return goweb.API.WriteResponseObject(ctx, http.StatusOK, response)
+}
+
 func RespondWithPaginatedData(ctx context.Context, data interface{}, limit, offset, count int) error {
 	// make the standard response object
 	response := new(paginatedResponse)
@@ -63,3 +74,16 @@ func RespondWithPaginatedData(ctx context.Context, data interface{}, limit, offs
 
 	return goweb.API.WriteResponseObject(ctx, http.StatusOK, response)
 }
+
+// RespondWithCursorData responds with a page of a cursor paginated
+// listing. next is the cursor of the following page, empty on the last page.
+func RespondWithCursorData(ctx context.Context, data interface{}, limit, count int, next string) error {
+	response := new(paginatedResponse)
+	response.S = http.StatusOK
+	response.D = data
+	response.E = nil
+	response.Limit = limit
+	response.Count = count
+	response.Next = next
+	return goweb.API.WriteResponseObject(ctx, http.StatusOK, response)
+}
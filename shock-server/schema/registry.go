@@ -0,0 +1,156 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/db"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+// kinds of node property a schema can be registered for
+const (
+	Tag  = "tag"
+	Type = "type"
+)
+
+// Entry is a schema registered for nodes with a tag or an attributes.type
+// value. The schema is stored as JSON text since mongodb keys can not
+// start with $.
+type Entry struct {
+	Kind     string          `bson:"kind" json:"kind"`
+	Name     string          `bson:"name" json:"name"`
+	Schema   json.RawMessage `bson:"schema" json:"schema"`
+	Modified time.Time       `bson:"modified" json:"last_modified"`
+}
+
+// ValidationError lists the schema violations of node attributes
+type ValidationError struct {
+	Errors []Error
+}
+
+func (v *ValidationError) Error() string {
+	msgs := []string{}
+	for _, e := range v.Errors {
+		msgs = append(msgs, e.String())
+	}
+	return "attributes do not match schema: " + strings.Join(msgs, "; ")
+}
+
+// IsValidKind returns true if schemas can be registered for kind
+func IsValidKind(kind string) bool {
+	return kind == Tag || kind == Type
+}
+
+func collection(session *mgo.Session) *mgo.Collection {
+	return session.DB(conf.Conf["mongodb-database"]).C("Schemas")
+}
+
+// Initialize ensures the unique index of the Schemas collection
+func Initialize() {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	collection(session).EnsureIndex(mgo.Index{Key: []string{"kind", "name"}, Unique: true})
+}
+
+// Put registers raw as the schema for kind and name, replacing any
+// schema registered before.
+func Put(kind string, name string, raw []byte) (entry *Entry, err error) {
+	if !IsValidKind(kind) {
+		return nil, errors.New("schema kind must be tag or type")
+	}
+	if name == "" {
+		return nil, errors.New("schema name required")
+	}
+	if _, err = Compile(raw); err != nil {
+		return nil, err
+	}
+	entry = &Entry{Kind: kind, Name: name, Schema: raw, Modified: time.Now()}
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	if _, err = collection(session).Upsert(bson.M{"kind": kind, "name": name}, entry); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Get returns the schema registered for kind and name
+func Get(kind string, name string) (entry *Entry, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	entry = &Entry{}
+	if err = collection(session).Find(bson.M{"kind": kind, "name": name}).One(entry); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Delete removes the schema registered for kind and name
+func Delete(kind string, name string) (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	return collection(session).Remove(bson.M{"kind": kind, "name": name})
+}
+
+// List returns all registered schemas
+func List() (entries []Entry, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	entries = []Entry{}
+	err = collection(session).Find(nil).Sort("kind", "name").All(&entries)
+	return
+}
+
+// Validate checks attributes against the schemas registered for tags and
+// for the type member of attributes. Violations of all schemas are
+// returned together as a *ValidationError.
+func Validate(tags []string, attributes interface{}) (err error) {
+	q := []bson.M{}
+	if len(tags) > 0 {
+		q = append(q, bson.M{"kind": Tag, "name": bson.M{"$in": tags}})
+	}
+	if t := typeName(attributes); t != "" {
+		q = append(q, bson.M{"kind": Type, "name": t})
+	}
+	if len(q) == 0 {
+		return nil
+	}
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	entries := []Entry{}
+	if err = collection(session).Find(bson.M{"$or": q}).Sort("kind", "name").All(&entries); err != nil {
+		return err
+	}
+	verr := &ValidationError{Errors: []Error{}}
+	for _, entry := range entries {
+		s, err := Compile(entry.Schema)
+		if err != nil {
+			return fmt.Errorf("schema %s:%s: %s", entry.Kind, entry.Name, err.Error())
+		}
+		for _, e := range s.Validate(attributes) {
+			e.Schema = entry.Kind + ":" + entry.Name
+			verr.Errors = append(verr.Errors, e)
+		}
+	}
+	if len(verr.Errors) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// typeName returns the type member of attributes if it is a string
+func typeName(attributes interface{}) string {
+	switch a := attributes.(type) {
+	case map[string]interface{}:
+		t, _ := a["type"].(string)
+		return t
+	case bson.M:
+		t, _ := a["type"].(string)
+		return t
+	}
+	return ""
+}
@@ -0,0 +1,556 @@
+// Package schema validates node attributes against JSON Schemas
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// deepest nesting of schemas and references followed while validating
+const maxDepth = 64
+
+var types = map[string]bool{"array": true, "boolean": true, "integer": true, "null": true, "number": true, "object": true, "string": true}
+
+// Schema is a compiled JSON Schema. The draft 4 validation keywords are
+// supported, with references limited to the schema document itself.
+type Schema struct {
+	root    interface{}
+	regexps map[string]*regexp.Regexp
+}
+
+// Error is a single violation of a schema. Path is a JSON pointer to the
+// offending value, Schema names the registered schema that was violated.
+type Error struct {
+	Schema  string `json:"schema,omitempty"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e Error) String() string {
+	s := e.Message
+	if e.Path != "" {
+		s = e.Path + ": " + s
+	}
+	if e.Schema != "" {
+		s = e.Schema + " " + s
+	}
+	return s
+}
+
+// Compile parses and checks a JSON Schema document
+func Compile(raw []byte) (s *Schema, err error) {
+	s = &Schema{regexps: map[string]*regexp.Regexp{}}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	if err = d.Decode(&s.root); err != nil {
+		return nil, errors.New("invalid schema: " + err.Error())
+	}
+	if d.More() {
+		return nil, errors.New("invalid schema: trailing data")
+	}
+	if err = s.check(s.root, ""); err != nil {
+		return nil, errors.New("invalid schema: " + err.Error())
+	}
+	return s, nil
+}
+
+// Validate returns the violations of the schema by v, which can be any
+// value that encodes to JSON. No errors means v is valid.
+func (s *Schema) Validate(v interface{}) (errs []Error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return []Error{{Message: "not a JSON value: " + err.Error()}}
+	}
+	var doc interface{}
+	json.Unmarshal(b, &doc)
+	errs = []Error{}
+	s.validate(&errs, s.root, doc, "", 0)
+	return
+}
+
+// check verifies the keywords of schema sch found at pointer path
+func (s *Schema) check(sch interface{}, path string) error {
+	if _, ok := sch.(bool); ok {
+		return nil
+	}
+	m, ok := sch.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: schema must be an object or boolean", path)
+	}
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := m[k]
+		p := path + "/" + k
+		var err error
+		switch k {
+		case "type":
+			err = checkTypes(v)
+		case "properties", "patternProperties", "definitions":
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s: must be an object", p)
+			}
+			for name, sub := range obj {
+				if k == "patternProperties" {
+					if err = s.compileRegexp(name); err != nil {
+						return fmt.Errorf("%s: %s", p, err.Error())
+					}
+				}
+				if err = s.check(sub, p+"/"+name); err != nil {
+					return err
+				}
+			}
+		case "additionalProperties", "additionalItems", "not", "contains":
+			if err = s.check(v, p); err != nil {
+				return err
+			}
+		case "items":
+			if list, ok := v.([]interface{}); ok {
+				for i, sub := range list {
+					if err = s.check(sub, p+"/"+strconv.Itoa(i)); err != nil {
+						return err
+					}
+				}
+			} else if err = s.check(v, p); err != nil {
+				return err
+			}
+		case "allOf", "anyOf", "oneOf":
+			list, ok := v.([]interface{})
+			if !ok || len(list) == 0 {
+				return fmt.Errorf("%s: must be a non-empty array", p)
+			}
+			for i, sub := range list {
+				if err = s.check(sub, p+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		case "required":
+			list, ok := v.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s: must be an array of strings", p)
+			}
+			for _, name := range list {
+				if _, ok := name.(string); !ok {
+					return fmt.Errorf("%s: must be an array of strings", p)
+				}
+			}
+		case "enum":
+			if _, ok := v.([]interface{}); !ok {
+				return fmt.Errorf("%s: must be an array", p)
+			}
+		case "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+			if n, ok := v.(float64); !ok || n < 0 || n != math.Trunc(n) {
+				return fmt.Errorf("%s: must be a non-negative integer", p)
+			}
+		case "minimum", "maximum":
+			if _, ok := v.(float64); !ok {
+				return fmt.Errorf("%s: must be a number", p)
+			}
+		case "exclusiveMinimum", "exclusiveMaximum":
+			switch v.(type) {
+			case bool, float64:
+			default:
+				return fmt.Errorf("%s: must be a boolean or number", p)
+			}
+		case "multipleOf":
+			if n, ok := v.(float64); !ok || n <= 0 {
+				return fmt.Errorf("%s: must be a number greater than 0", p)
+			}
+		case "pattern":
+			str, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("%s: must be a string", p)
+			}
+			if err = s.compileRegexp(str); err != nil {
+				return fmt.Errorf("%s: %s", p, err.Error())
+			}
+		case "uniqueItems":
+			if _, ok := v.(bool); !ok {
+				return fmt.Errorf("%s: must be a boolean", p)
+			}
+		case "format":
+			if _, ok := v.(string); !ok {
+				return fmt.Errorf("%s: must be a string", p)
+			}
+		case "$ref":
+			ref, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("%s: must be a string", p)
+			}
+			if _, err = s.resolve(ref); err != nil {
+				return fmt.Errorf("%s: %s", p, err.Error())
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", p, err.Error())
+		}
+	}
+	return nil
+}
+
+func checkTypes(v interface{}) error {
+	list, ok := v.([]interface{})
+	if !ok {
+		list = []interface{}{v}
+	}
+	for _, t := range list {
+		if name, ok := t.(string); !ok || !types[name] {
+			return fmt.Errorf("unknown type %v", t)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) compileRegexp(expr string) error {
+	if _, ok := s.regexps[expr]; ok {
+		return nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	s.regexps[expr] = re
+	return nil
+}
+
+// resolve returns the schema a local reference such as
+// #/definitions/sample points to
+func (s *Schema) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, errors.New("only references within the schema are supported: " + ref)
+	}
+	ref, err := url.QueryUnescape(ref[1:])
+	if err != nil {
+		return nil, err
+	}
+	cur := s.root
+	if ref == "" {
+		return cur, nil
+	}
+	if !strings.HasPrefix(ref, "/") {
+		return nil, errors.New("invalid reference #" + ref)
+	}
+	for _, t := range strings.Split(ref[1:], "/") {
+		t = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			next, ok := c[t]
+			if !ok {
+				return nil, errors.New("unresolvable reference #" + ref)
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, errors.New("unresolvable reference #" + ref)
+			}
+			cur = c[i]
+		default:
+			return nil, errors.New("unresolvable reference #" + ref)
+		}
+	}
+	return cur, nil
+}
+
+func typeOf(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if t == math.Trunc(t) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+func hasType(v interface{}, t string) bool {
+	vt := typeOf(v)
+	return vt == t || (t == "number" && vt == "integer")
+}
+
+func (s *Schema) validate(errs *[]Error, sch interface{}, v interface{}, path string, depth int) {
+	fail := func(format string, a ...interface{}) {
+		*errs = append(*errs, Error{Path: path, Message: fmt.Sprintf(format, a...)})
+	}
+	if depth > maxDepth {
+		fail("schema nesting too deep")
+		return
+	}
+	if b, ok := sch.(bool); ok {
+		if !b {
+			fail("is not allowed")
+		}
+		return
+	}
+	m, _ := sch.(map[string]interface{})
+	if ref, ok := m["$ref"].(string); ok {
+		r, _ := s.resolve(ref)
+		s.validate(errs, r, v, path, depth+1)
+		return
+	}
+
+	if t, ok := m["type"]; ok {
+		list, ok := t.([]interface{})
+		if !ok {
+			list = []interface{}{t}
+		}
+		match := false
+		names := []string{}
+		for _, name := range list {
+			names = append(names, name.(string))
+			match = match || hasType(v, name.(string))
+		}
+		if !match {
+			fail("must be of type %s, not %s", strings.Join(names, " or "), typeOf(v))
+			return
+		}
+	}
+	if enum, ok := m["enum"].([]interface{}); ok {
+		match := false
+		for _, e := range enum {
+			match = match || reflect.DeepEqual(e, v)
+		}
+		if !match {
+			fail("must be one of %s", encode(enum))
+		}
+	}
+
+	switch t := v.(type) {
+	case float64:
+		validateNumber(fail, m, t)
+	case string:
+		s.validateString(fail, m, t)
+	case []interface{}:
+		s.validateArray(errs, fail, m, t, path, depth)
+	case map[string]interface{}:
+		s.validateObject(errs, fail, m, t, path, depth)
+	}
+
+	if list, ok := m["allOf"].([]interface{}); ok {
+		for _, sub := range list {
+			s.validate(errs, sub, v, path, depth+1)
+		}
+	}
+	if list, ok := m["anyOf"].([]interface{}); ok {
+		match := false
+		for _, sub := range list {
+			if match = s.valid(sub, v, path, depth); match {
+				break
+			}
+		}
+		if !match {
+			fail("must match at least one of the anyOf schemas")
+		}
+	}
+	if list, ok := m["oneOf"].([]interface{}); ok {
+		n := 0
+		for _, sub := range list {
+			if s.valid(sub, v, path, depth) {
+				n++
+			}
+		}
+		if n != 1 {
+			fail("must match exactly one of the oneOf schemas, matches %d", n)
+		}
+	}
+	if sub, ok := m["not"]; ok && s.valid(sub, v, path, depth) {
+		fail("must not match the not schema")
+	}
+}
+
+// valid reports whether v matches sch without recording errors
+func (s *Schema) valid(sch interface{}, v interface{}, path string, depth int) bool {
+	errs := []Error{}
+	s.validate(&errs, sch, v, path, depth+1)
+	return len(errs) == 0
+}
+
+func validateNumber(fail func(string, ...interface{}), m map[string]interface{}, n float64) {
+	if min, ok := m["minimum"].(float64); ok {
+		if excl, _ := m["exclusiveMinimum"].(bool); excl && n <= min {
+			fail("must be greater than %v", min)
+		} else if n < min {
+			fail("must be at least %v", min)
+		}
+	}
+	if min, ok := m["exclusiveMinimum"].(float64); ok && n <= min {
+		fail("must be greater than %v", min)
+	}
+	if max, ok := m["maximum"].(float64); ok {
+		if excl, _ := m["exclusiveMaximum"].(bool); excl && n >= max {
+			fail("must be less than %v", max)
+		} else if n > max {
+			fail("must be at most %v", max)
+		}
+	}
+	if max, ok := m["exclusiveMaximum"].(float64); ok && n >= max {
+		fail("must be less than %v", max)
+	}
+	if d, ok := m["multipleOf"].(float64); ok {
+		if q := n / d; math.Abs(q-math.Floor(q+0.5)) > 1e-9 {
+			fail("must be a multiple of %v", d)
+		}
+	}
+}
+
+func (s *Schema) validateString(fail func(string, ...interface{}), m map[string]interface{}, str string) {
+	l := float64(utf8.RuneCountInString(str))
+	if min, ok := m["minLength"].(float64); ok && l < min {
+		fail("must be at least %v characters long", min)
+	}
+	if max, ok := m["maxLength"].(float64); ok && l > max {
+		fail("must be at most %v characters long", max)
+	}
+	if p, ok := m["pattern"].(string); ok && !s.regexps[p].MatchString(str) {
+		fail("must match the pattern %s", p)
+	}
+	if f, ok := m["format"].(string); ok && !validFormat(f, str) {
+		fail("must be a valid %s", f)
+	}
+}
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validFormat checks the formats metadata commonly uses, unknown
+// formats are accepted
+func validFormat(format string, str string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, str)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", str)
+		return err == nil
+	case "email":
+		a, err := mail.ParseAddress(str)
+		return err == nil && a.Address == str
+	case "uri":
+		u, err := url.Parse(str)
+		return err == nil && u.IsAbs()
+	case "uuid":
+		return uuidRegexp.MatchString(str)
+	}
+	return true
+}
+
+func (s *Schema) validateArray(errs *[]Error, fail func(string, ...interface{}), m map[string]interface{}, a []interface{}, path string, depth int) {
+	l := float64(len(a))
+	if min, ok := m["minItems"].(float64); ok && l < min {
+		fail("must have at least %v items", min)
+	}
+	if max, ok := m["maxItems"].(float64); ok && l > max {
+		fail("must have at most %v items", max)
+	}
+	if unique, _ := m["uniqueItems"].(bool); unique {
+		for i := range a {
+			for j := i + 1; j < len(a); j++ {
+				if reflect.DeepEqual(a[i], a[j]) {
+					fail("items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+	if items, ok := m["items"].([]interface{}); ok {
+		for i, v := range a {
+			if i < len(items) {
+				s.validate(errs, items[i], v, path+"/"+strconv.Itoa(i), depth+1)
+			} else if add, ok := m["additionalItems"]; ok {
+				s.validate(errs, add, v, path+"/"+strconv.Itoa(i), depth+1)
+			}
+		}
+	} else if items, ok := m["items"]; ok {
+		for i, v := range a {
+			s.validate(errs, items, v, path+"/"+strconv.Itoa(i), depth+1)
+		}
+	}
+	if c, ok := m["contains"]; ok {
+		match := false
+		for i, v := range a {
+			if match = s.valid(c, v, path+"/"+strconv.Itoa(i), depth); match {
+				break
+			}
+		}
+		if !match {
+			fail("must contain an item matching the contains schema")
+		}
+	}
+}
+
+func (s *Schema) validateObject(errs *[]Error, fail func(string, ...interface{}), m map[string]interface{}, obj map[string]interface{}, path string, depth int) {
+	l := float64(len(obj))
+	if min, ok := m["minProperties"].(float64); ok && l < min {
+		fail("must have at least %v properties", min)
+	}
+	if max, ok := m["maxProperties"].(float64); ok && l > max {
+		fail("must have at most %v properties", max)
+	}
+	if required, ok := m["required"].([]interface{}); ok {
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				*errs = append(*errs, Error{Path: path + "/" + escape(name.(string)), Message: "is required"})
+			}
+		}
+	}
+	props, _ := m["properties"].(map[string]interface{})
+	patterns, _ := m["patternProperties"].(map[string]interface{})
+	add, hasAdd := m["additionalProperties"]
+	keys := []string{}
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := path + "/" + escape(k)
+		matched := false
+		if sub, ok := props[k]; ok {
+			s.validate(errs, sub, obj[k], p, depth+1)
+			matched = true
+		}
+		for expr, sub := range patterns {
+			if s.regexps[expr].MatchString(k) {
+				s.validate(errs, sub, obj[k], p, depth+1)
+				matched = true
+			}
+		}
+		if !matched && hasAdd {
+			if b, ok := add.(bool); ok && !b {
+				*errs = append(*errs, Error{Path: p, Message: "is not an allowed property"})
+			} else {
+				s.validate(errs, add, obj[k], p, depth+1)
+			}
+		}
+	}
+}
+
+// escape escapes a member name for use in a JSON pointer
+func escape(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+func encode(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
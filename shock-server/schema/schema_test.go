@@ -0,0 +1,116 @@
+package schema_test
+
+import (
+	"encoding/json"
+	. "github.com/MG-RAST/Shock/shock-server/schema"
+	"reflect"
+	"testing"
+)
+
+const sample = `{
+	"type": "object",
+	"required": ["project", "sample_id"],
+	"properties": {
+		"project": {"type": "string", "minLength": 1},
+		"sample_id": {"type": "string", "pattern": "^mgs[0-9]+$"},
+		"depth": {"type": "number", "minimum": 0, "exclusiveMaximum": 11000},
+		"collected": {"type": "string", "format": "date"},
+		"contact": {"type": "string", "format": "email"},
+		"biome": {"enum": ["soil", "marine", "host-associated"]},
+		"reads": {"type": "integer", "multipleOf": 2},
+		"primers": {"type": "array", "items": {"$ref": "#/definitions/primer"}, "minItems": 1, "uniqueItems": true},
+		"location": {"oneOf": [{"type": "string"}, {"$ref": "#/definitions/point"}]}
+	},
+	"patternProperties": {"^x_": {"type": "string"}},
+	"additionalProperties": false,
+	"definitions": {
+		"primer": {"type": "string", "pattern": "^[ACGTN]+$"},
+		"point": {"type": "object", "required": ["lat", "lon"], "properties": {"lat": {"type": "number"}, "lon": {"type": "number"}}}
+	}
+}`
+
+var validateTests = []struct {
+	doc  string
+	want []Error
+}{
+	{`{"project": "X", "sample_id": "mgs1"}`, []Error{}},
+	{`{"project": "X", "sample_id": "mgs1", "depth": 10.5, "collected": "2014-05-01", "contact": "a@b.org", "biome": "soil", "reads": 4,
+		"primers": ["ACGT"], "location": {"lat": 1, "lon": 2}, "x_note": "n"}`, []Error{}},
+	{`{}`, []Error{{Path: "/project", Message: "is required"}, {Path: "/sample_id", Message: "is required"}}},
+	{`[]`, []Error{{Message: "must be of type object, not array"}}},
+	{`{"project": "", "sample_id": "s1"}`, []Error{
+		{Path: "/project", Message: "must be at least 1 characters long"},
+		{Path: "/sample_id", Message: "must match the pattern ^mgs[0-9]+$"},
+	}},
+	{`{"project": "X", "sample_id": "mgs1", "depth": 11000, "collected": "May", "contact": "b.org", "biome": "lake", "reads": 3}`, []Error{
+		{Path: "/biome", Message: `must be one of ["soil","marine","host-associated"]`},
+		{Path: "/collected", Message: "must be a valid date"},
+		{Path: "/contact", Message: "must be a valid email"},
+		{Path: "/depth", Message: "must be less than 11000"},
+		{Path: "/reads", Message: "must be a multiple of 2"},
+	}},
+	{`{"project": "X", "sample_id": "mgs1", "reads": 2.5, "primers": ["AC", "AC", "xx"], "location": {"lat": 1}, "x_n": 1, "other": true}`, []Error{
+		{Path: "/location", Message: "must match exactly one of the oneOf schemas, matches 0"},
+		{Path: "/other", Message: "is not an allowed property"},
+		{Path: "/primers", Message: "items 0 and 1 are equal"},
+		{Path: "/primers/2", Message: "must match the pattern ^[ACGTN]+$"},
+		{Path: "/reads", Message: "must be of type integer, not number"},
+		{Path: "/x_n", Message: "must be of type string, not integer"},
+	}},
+}
+
+func TestValidate(t *testing.T) {
+	s, err := Compile([]byte(sample))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	for _, tt := range validateTests {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+			t.Fatalf("%s: %v", tt.doc, err)
+		}
+		if got := s.Validate(doc); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %v\nwant %v", tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	valid := []string{`{}`, `true`, `{"type": ["string", "null"]}`, `{"items": [{}, false]}`, `{"$ref": "#"}`, `{"x-unknown": 1}`}
+	for _, raw := range valid {
+		if _, err := Compile([]byte(raw)); err != nil {
+			t.Errorf("Compile(%s): %v", raw, err)
+		}
+	}
+	invalid := []string{
+		``,
+		`[]`,
+		`{"type": "text"}`,
+		`{"properties": []}`,
+		`{"properties": {"a": 1}}`,
+		`{"required": "a"}`,
+		`{"minLength": -1}`,
+		`{"multipleOf": 0}`,
+		`{"pattern": "("}`,
+		`{"patternProperties": {"(": {}}}`,
+		`{"anyOf": []}`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"$ref": "http://example.org/schema"}`,
+		`{} {}`,
+	}
+	for _, raw := range invalid {
+		if _, err := Compile([]byte(raw)); err == nil {
+			t.Errorf("Compile(%s): expected error", raw)
+		}
+	}
+}
+
+func TestRecursiveRef(t *testing.T) {
+	s, err := Compile([]byte(`{"$ref": "#"}`))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if errs := s.Validate(map[string]interface{}{}); len(errs) != 1 {
+		t.Errorf("self reference: got %v, want one error", errs)
+	}
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"github.com/MG-RAST/Shock/shock-server/util"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// local stores data files in the data directory layout
+type local struct {
+	root string
+}
+
+func NewLocal(root string) Driver {
+	return &local{root: root}
+}
+
+func (l *local) path(key string) string {
+	if filepath.IsAbs(key) {
+		return key
+	}
+	return l.root + "/" + key
+}
+
+func (l *local) Put(key string, path string) (err error) {
+	dst := l.path(key)
+	if err = os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		return
+	}
+	if err = os.Rename(path, dst); err != nil {
+		// different device, fall back to copy
+		if _, err = util.CopyFile(path, dst); err != nil {
+			return
+		}
+		os.Remove(path)
+	}
+	return
+}
+
+func (l *local) Get(key string) (io.ReadCloser, error) {
+	return os.Open(l.path(key))
+}
+
+func (l *local) ReaderAt(key string) (file.ReaderAt, error) {
+	f, err := os.Open(l.path(key))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (l *local) Stat(key string) (size int64, err error) {
+	fi, err := os.Stat(l.path(key))
+	if err != nil {
+		return
+	}
+	return fi.Size(), nil
+}
+
+func (l *local) Delete(key string) (err error) {
+	if err = os.Remove(l.path(key)); os.IsNotExist(err) {
+		return nil
+	}
+	return
+}
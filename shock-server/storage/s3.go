@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3 stores data files as objects in an S3 compatible bucket using
+// path style addressing and AWS signature version 4.
+type s3 struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func NewS3(endpoint, bucket, region, accessKey, secretKey string) Driver {
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3{
+		endpoint:  strings.TrimRight(endpoint, "/"),
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{},
+	}
+}
+
+func (s *s3) Put(key string, p string) (err error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	res, err := s.do("PUT", key, f, fi.Size(), nil)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+	return os.Remove(p)
+}
+
+func (s *s3) Get(key string) (io.ReadCloser, error) {
+	res, err := s.do("GET", key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *s3) ReaderAt(key string) (file.ReaderAt, error) {
+	size, err := s.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{s: s, key: key, size: size}, nil
+}
+
+func (s *s3) Stat(key string) (size int64, err error) {
+	res, err := s.do("HEAD", key, nil, 0, nil)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+	return strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+}
+
+func (s *s3) Delete(key string) (err error) {
+	res, err := s.do("DELETE", key, nil, 0, nil)
+	if err != nil {
+		if err.Error() == "404 Not Found" {
+			return nil
+		}
+		return
+	}
+	res.Body.Close()
+	return
+}
+
+func (s *s3) do(method string, key string, body io.Reader, length int64, header http.Header) (res *http.Response, err error) {
+	u, err := url.Parse(s.endpoint + "/" + s.bucket + "/" + key)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+	s.sign(req, time.Now().UTC())
+	if res, err = s.client.Do(req); err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, errors.New(res.Status)
+	}
+	return
+}
+
+// sign adds AWS signature version 4 headers. The payload is not
+// signed so uploads can be streamed from disk.
+func (s *s3) sign(req *http.Request, t time.Time) {
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	headers := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonical := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		req.URL.RawQuery,
+		headers,
+		strings.Join(signed, ";"),
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + fmt.Sprintf("%x", sha256.Sum256([]byte(canonical)))
+
+	key := hmacSum([]byte("AWS4"+s.secretKey), date)
+	key = hmacSum(key, s.region)
+	key = hmacSum(key, "s3")
+	key = hmacSum(key, "aws4_request")
+	signature := fmt.Sprintf("%x", hmacSum(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, strings.Join(signed, ";"), signature))
+}
+
+func hmacSum(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = strings.Replace(url.QueryEscape(part), "+", "%20", -1)
+	}
+	return strings.Join(parts, "/")
+}
+
+// s3Object is a file.ReaderAt for an object. Sequential reads share one
+// streaming GET, ReadAt issues ranged GETs.
+type s3Object struct {
+	s    *s3
+	key  string
+	size int64
+	off  int64
+	body io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (n int, err error) {
+	if o.off >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		h := http.Header{"Range": {fmt.Sprintf("bytes=%d-", o.off)}}
+		res, err := o.s.do("GET", o.key, nil, 0, h)
+		if err != nil {
+			return 0, err
+		}
+		o.body = res.Body
+	}
+	n, err = o.body.Read(p)
+	o.off += int64(n)
+	return
+}
+
+func (o *s3Object) ReadAt(p []byte, off int64) (n int, err error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p)) - 1
+	if end >= o.size {
+		end = o.size - 1
+	}
+	h := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, end)}}
+	res, err := o.s.do("GET", o.key, nil, 0, h)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	n, err = io.ReadFull(res.Body, p[:end-off+1])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+func (o *s3Object) Stat() (os.FileInfo, error) {
+	return objectInfo{name: path.Base(o.key), size: o.size}, nil
+}
+
+func (o *s3Object) Close() (err error) {
+	if o.body != nil {
+		err = o.body.Close()
+		o.body = nil
+	}
+	return
+}
+
+type objectInfo struct {
+	name string
+	size int64
+}
+
+func (fi objectInfo) Name() string       { return fi.name }
+func (fi objectInfo) Size() int64        { return fi.size }
+func (fi objectInfo) Mode() os.FileMode  { return 0444 }
+func (fi objectInfo) ModTime() time.Time { return time.Time{} }
+func (fi objectInfo) IsDir() bool        { return false }
+func (fi objectInfo) Sys() interface{}   { return nil }
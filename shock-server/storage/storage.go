@@ -0,0 +1,115 @@
+// Package storage implements the drivers that hold node data files
+package storage
+
+import (
+	"errors"
+	"github.com/MG-RAST/Shock/shock-server/conf"
+	"github.com/MG-RAST/Shock/shock-server/node/file"
+	"io"
+	"os"
+)
+
+// Driver stores node data under a key. Keys are relative paths
+// within the store, the local driver also accepts absolute paths.
+type Driver interface {
+	// Put moves the local file at path into the store under key
+	Put(key string, path string) (err error)
+	// Get returns a sequential reader of the data
+	Get(key string) (io.ReadCloser, error)
+	// ReaderAt returns a random access reader of the data
+	ReaderAt(key string) (file.ReaderAt, error)
+	// Stat returns the size of the data
+	Stat(key string) (int64, error)
+	// Delete removes the data from the store
+	Delete(key string) error
+}
+
+const Local = "local"
+
+var (
+	drivers     = map[string]Driver{}
+	DefaultName = Local
+)
+
+// Initialize sets up the local driver and any remote drivers found
+// in the configuration. Requires conf.Initialize
+func Initialize() (err error) {
+	drivers = map[string]Driver{Local: NewLocal(conf.Conf["data-path"])}
+	if conf.Conf["s3-endpoint"] != "" {
+		drivers["s3"] = NewS3(conf.Conf["s3-endpoint"], conf.Conf["s3-bucket"], conf.Conf["s3-region"], conf.Conf["s3-access-key"], conf.Conf["s3-secret-key"])
+	}
+	if conf.Conf["storage"] != "" {
+		if _, has := drivers[conf.Conf["storage"]]; !has {
+			return errors.New("storage driver not configured: " + conf.Conf["storage"])
+		}
+		DefaultName = conf.Conf["storage"]
+	}
+	return
+}
+
+// Register adds a driver under name, replacing any existing one.
+func Register(name string, d Driver) {
+	drivers[name] = d
+}
+
+// Has returns true if a driver is registered under name
+func Has(name string) bool {
+	_, has := drivers[name]
+	return has || name == ""
+}
+
+// Get returns the driver for name. The empty name is the local
+// driver, nodes created before drivers existed have no store set.
+func Get(name string) Driver {
+	if name == "" {
+		name = Local
+	}
+	if d, has := drivers[name]; has {
+		return d
+	}
+	return missing{name}
+}
+
+// IsLocal returns true if name refers to the local driver
+func IsLocal(name string) bool {
+	return name == "" || name == Local
+}
+
+// Copy copies the data under fromKey in one driver to toKey in another
+// by way of a temporary file in the data directory.
+func Copy(from Driver, fromKey string, to Driver, toKey string, tmpPath string) (err error) {
+	r, err := from.Get(fromKey)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return
+	}
+	if err = to.Put(toKey, tmpPath); err != nil {
+		os.Remove(tmpPath)
+	}
+	return
+}
+
+// missing is returned for stores that are not configured on this server
+type missing struct {
+	name string
+}
+
+func (m missing) err() error {
+	return errors.New("storage driver not configured: " + m.name)
+}
+
+func (m missing) Put(string, string) error               { return m.err() }
+func (m missing) Get(string) (io.ReadCloser, error)      { return nil, m.err() }
+func (m missing) ReaderAt(string) (file.ReaderAt, error) { return nil, m.err() }
+func (m missing) Stat(string) (int64, error)             { return 0, m.err() }
+func (m missing) Delete(string) error                    { return m.err() }
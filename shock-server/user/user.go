@@ -2,12 +2,24 @@ package user
 
 import (
 	"code.google.com/p/go-uuid/uuid"
+	"code.google.com/p/go.crypto/bcrypt"
+	"crypto/subtle"
+	"errors"
 	"github.com/MG-RAST/Shock/shock-server/conf"
 	"github.com/MG-RAST/Shock/shock-server/db"
+	"github.com/MG-RAST/Shock/shock-server/node/acl"
+	"github.com/MG-RAST/Shock/shock-server/util"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strings"
 )
 
+// usernames can not contain the basic auth separator ':'
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@+-]{0,127}$`)
+
+var ErrInvalidUsername = errors.New("username must be 1 to 128 letters, digits, '.', '_', '@', '+' or '-' starting with a letter or digit")
+
 // Array of User
 type Users []User
 
@@ -17,9 +29,49 @@ type User struct {
 	Username     string      `bson:"username" json:"username"`
 	Fullname     string      `bson:"fullname" json:"fullname"`
 	Email        string      `bson:"email" json:"email"`
-	Password     string      `bson:"password" json:"-"`
+	Password     string      `bson:"password" json:"-"` // bcrypt hash, plaintext in records not yet upgraded
 	Admin        bool        `bson:"shock_admin" json:"shock_admin"`
 	CustomFields interface{} `bson:"custom_fields" json:"custom_fields"`
+	Groups       []string    `bson:"-" json:"groups,omitempty"` // local groups and groups asserted by the auth provider, as <provider>:<name>
+	Scope        *Scope      `bson:"-" json:"-"`                // set when authenticated with an api token
+}
+
+// Scope limits the requests of a user authenticated with an api token:
+// read only unless Write is set, and only to the listed nodes and
+// collections if any are listed.
+type Scope struct {
+	Token       string
+	Write       bool
+	Nodes       []string
+	Collections []string
+}
+
+// Restricted returns true if the scope lists nodes or collections
+func (s *Scope) Restricted() bool {
+	return s != nil && (len(s.Nodes) > 0 || len(s.Collections) > 0)
+}
+
+// AllowsNode returns true if the scope gives access to the node with id,
+// which is in collections
+func (s *Scope) AllowsNode(id string, collections []string) bool {
+	if !s.Restricted() {
+		return true
+	}
+	if util.StringInSlice(id, s.Nodes) {
+		return true
+	}
+	for _, cid := range collections {
+		if s.AllowsCollection(cid) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsCollection returns true if the scope gives access to the
+// collection with id
+func (s *Scope) AllowsCollection(id string) bool {
+	return !s.Restricted() || util.StringInSlice(id, s.Collections)
 }
 
 // Initialize creates a copy of the mongodb connection and then uses that connection to
@@ -34,7 +86,10 @@ func Initialize() {
 }
 
 func New(username string, password string, isAdmin bool) (u *User, err error) {
-	u = &User{Uuid: uuid.New(), Username: username, Password: password, Admin: isAdmin}
+	u = &User{Uuid: uuid.New(), Username: username, Admin: isAdmin}
+	if err = u.SetPassword(password); err != nil {
+		return nil, err
+	}
 	if err = u.Save(); err != nil {
 		u = nil
 	}
@@ -52,17 +107,44 @@ func FindByUuid(uuid string) (u *User, err error) {
 	return
 }
 
-func FindByUsernamePassword(username string, password string) (u *User, err error) {
+func FindByUsername(username string) (u *User, err error) {
 	session := db.Connection.Session.Copy()
 	defer session.Close()
 	c := session.DB(conf.Conf["mongodb-database"]).C("Users")
 	u = &User{}
-	if err = c.Find(bson.M{"username": username, "password": password}).One(&u); err != nil {
+	if err = c.Find(bson.M{"username": username}).One(&u); err != nil {
 		return nil, err
 	}
 	return
 }
 
+// FindByUsernamePassword returns the user if password matches. A
+// plaintext password stored before hashing was introduced is replaced by
+// its hash on the first successful login.
+func FindByUsernamePassword(username string, password string) (u *User, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := session.DB(conf.Conf["mongodb-database"]).C("Users")
+	u = &User{}
+	if err = c.Find(bson.M{"username": username}).One(&u); err != nil {
+		return nil, err
+	}
+	if !u.CheckPassword(password) {
+		return nil, mgo.ErrNotFound
+	}
+	if !isHash(u.Password) {
+		plain := u.Password
+		if err = u.SetPassword(password); err != nil {
+			return nil, err
+		}
+		// only while the record still holds the plaintext
+		if err = c.Update(bson.M{"uuid": u.Uuid, "password": plain}, bson.M{"$set": bson.M{"password": u.Password}}); err != nil && err != mgo.ErrNotFound {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
 func AdminGet(u *Users) (err error) {
 	session := db.Connection.Session.Copy()
 	defer session.Close()
@@ -71,6 +153,61 @@ func AdminGet(u *Users) (err error) {
 	return
 }
 
+// List returns one page of all users sorted by username
+func List(limit int, offset int) (users Users, count int, err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	q := session.DB(conf.Conf["mongodb-database"]).C("Users").Find(nil)
+	if count, err = q.Count(); err != nil {
+		return nil, 0, err
+	}
+	users = Users{}
+	err = q.Sort("username").Limit(limit).Skip(offset).All(&users)
+	return
+}
+
+// SetPassword stores the bcrypt hash of password, the caller saves the user
+func (u *User) SetPassword(password string) (err error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return
+	}
+	u.Password = string(hash)
+	return
+}
+
+// CheckPassword returns true if password matches the stored hash, or the
+// stored plaintext of a record not yet upgraded. Users without a password,
+// e.g. those of an external auth provider, never match.
+func (u *User) CheckPassword(password string) bool {
+	if u.Password == "" {
+		return false
+	}
+	if isHash(u.Password) {
+		return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1
+}
+
+// isHash returns true if the stored password is a bcrypt hash
+func isHash(password string) bool {
+	return len(password) == 60 && (strings.HasPrefix(password, "$2a$") || strings.HasPrefix(password, "$2b$") || strings.HasPrefix(password, "$2y$"))
+}
+
+// IsValidUsername returns true if username can be used for a new user
+func IsValidUsername(username string) bool {
+	return validUsername.MatchString(username)
+}
+
+// Ids returns the uuid of the user and the acl entries of its groups
+func (u *User) Ids() []string {
+	ids := []string{u.Uuid}
+	for _, g := range u.Groups {
+		ids = append(ids, acl.GroupId(g))
+	}
+	return ids
+}
+
 func (u *User) SetUuid() (err error) {
 	if uu, err := dbGetUuid(u.Username); err == nil {
 		u.Uuid = uu
@@ -101,3 +238,19 @@ func (u *User) Save() (err error) {
 	c := session.DB(conf.Conf["mongodb-database"]).C("Users")
 	return c.Insert(&u)
 }
+
+// Update stores changes to an existing user
+func (u *User) Update() (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := session.DB(conf.Conf["mongodb-database"]).C("Users")
+	return c.Update(bson.M{"uuid": u.Uuid}, u)
+}
+
+// Delete removes the user. Nodes owned by the user are left in place.
+func (u *User) Delete() (err error) {
+	session := db.Connection.Session.Copy()
+	defer session.Close()
+	c := session.DB(conf.Conf["mongodb-database"]).C("Users")
+	return c.Remove(bson.M{"uuid": u.Uuid})
+}
@@ -0,0 +1,37 @@
+package user_test
+
+import (
+	. "github.com/MG-RAST/Shock/shock-server/user"
+	"strings"
+	"testing"
+)
+
+func TestPassword(t *testing.T) {
+	u := &User{}
+	if err := u.SetPassword("s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u.Password, "$2a$") || strings.Contains(u.Password, "s3cret") {
+		t.Errorf("password not hashed: %s", u.Password)
+	}
+	if !u.CheckPassword("s3cret") {
+		t.Error("hashed password does not match")
+	}
+	if u.CheckPassword("s3cre") || u.CheckPassword("") {
+		t.Error("wrong password matches")
+	}
+}
+
+func TestLegacyPassword(t *testing.T) {
+	u := &User{Password: "plain"}
+	if !u.CheckPassword("plain") {
+		t.Error("plaintext password does not match")
+	}
+	if u.CheckPassword("plai") || u.CheckPassword("plainer") {
+		t.Error("wrong password matches")
+	}
+	u = &User{}
+	if u.CheckPassword("") {
+		t.Error("user without password matches")
+	}
+}